@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"strings"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/buurzx/in-mem-kvdb/internal/initialization"
 	network "github.com/buurzx/in-mem-kvdb/internal/network/tcp"
+	"github.com/buurzx/in-mem-kvdb/internal/network/tlsconfig"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
@@ -48,7 +50,24 @@ func runCli(ctx context.Context, cfg *Config) error {
 
 	options := []network.TCPClientOption{
 		network.WithClientIdleTimeout(time.Duration(cfg.Network.IdleTimeout) * time.Second),
-		network.WithBufferSize(cfg.Network.MaxMessageSize),
+	}
+
+	if cfg.Network.MaxResponseSize > 0 {
+		options = append(options, network.WithClientMaxResponseSize(cfg.Network.MaxResponseSize))
+	}
+
+	if cfg.Network.TLS.Enabled {
+		reloader, err := tlsconfig.NewReloader(cfg.Network.TLS, logger)
+		if err != nil {
+			logger.Fatal("failed to load tls certificates", zap.Error(err))
+		}
+
+		host, _, err := net.SplitHostPort(cfg.Network.Address)
+		if err != nil {
+			logger.Fatal("invalid server address", zap.Error(err))
+		}
+
+		options = append(options, network.WithClientTLSConfig(reloader.ClientConfig(host)))
 	}
 
 	client, err := network.NewTcpClient(cfg.Network.Address, options...)
@@ -56,6 +75,12 @@ func runCli(ctx context.Context, cfg *Config) error {
 		logger.Fatal("failed to create tcp client", zap.Error(err))
 	}
 
+	if cfg.Credentials.User != "" {
+		if err := authenticate(client, cfg.Credentials.User, cfg.Credentials.Password); err != nil {
+			logger.Fatal("failed to authenticate", zap.String("user", cfg.Credentials.User), zap.Error(err))
+		}
+	}
+
 	// create tcp connections to the server
 	// Channel for handling client requests
 	requestChan := make(chan string)
@@ -75,6 +100,23 @@ func runCli(ctx context.Context, cfg *Config) error {
 	}
 }
 
+// authenticate sends AUTH with the credentials as binary strings, so they
+// may hold any bytes.
+func authenticate(client *network.TCPClient, user, password string) error {
+	request := fmt.Sprintf("AUTH $%d:%s $%d:%s", len(user), user, len(password), password)
+
+	response, err := client.Send([]byte(request))
+	if err != nil {
+		return err
+	}
+
+	if reply := string(response); reply != "[OK]" {
+		return errors.New(strings.TrimPrefix(reply, "[error] "))
+	}
+
+	return nil
+}
+
 func sendRequests(
 	ctx context.Context,
 	client *network.TCPClient,
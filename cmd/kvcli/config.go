@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/buurzx/in-mem-kvdb/internal/network/tlsconfig"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/urfave/cli/v2"
 )
@@ -12,11 +13,18 @@ import (
 // Config is a application configuration structure
 type Config struct {
 	Network struct {
-		Address string `yaml:"address" env:"KVDB_ADDRESS" env-description:"Network address"`
-		MaxMessageSize int    `yaml:"max-message-size" env:"NETWORK_MAX_MESSAGE_SIZE" env-description:"Maximum message size"`
-		IdleTimeout    int    `yaml:"idle-timeout" env:"NETWORK_IDLE_TIMEOUT" env-description:"Idle timeout"`
+		Address         string           `yaml:"address" env:"KVDB_ADDRESS" env-description:"Network address"`
+		MaxResponseSize int              `yaml:"max-response-size" env:"NETWORK_MAX_RESPONSE_SIZE" env-description:"Maximum response size, 512 MiB by default"`
+		IdleTimeout     int              `yaml:"idle-timeout" env:"NETWORK_IDLE_TIMEOUT" env-description:"Idle timeout"`
+		TLS             tlsconfig.Config `yaml:"tls"`
 	} `yaml:"network"`
 
+	// Credentials are sent with AUTH after connecting, unless User is empty.
+	Credentials struct {
+		User     string `yaml:"user" env:"KVDB_USER" env-description:"User to authenticate as"`
+		Password string `yaml:"password" env:"KVDB_PASSWORD" env-description:"Password of the user"`
+	} `yaml:"credentials"`
+
 	Logger struct {
 		Level          string `yaml:"level" env:"LOG_LEVEL" env-description:"Log level"`
 		OutputFilePath string `yaml:"output-file-path" env:"LOG_OUTPUT_FILE_PATH" env-description:"Log output file path"`
@@ -48,6 +56,22 @@ func (c *Config) buildFlags() []cli.Flag {
 			Destination: &c.Network.Address,
 			Required:    false,
 		},
+		&cli.StringFlag{
+			Name:        "user",
+			EnvVars:     []string{"KVDB_USER"},
+			Value:       c.Credentials.User,
+			Destination: &c.Credentials.User,
+			Usage:       "User to authenticate as",
+			Required:    false,
+		},
+		&cli.StringFlag{
+			Name:        "password",
+			EnvVars:     []string{"KVDB_PASSWORD"},
+			Value:       c.Credentials.Password,
+			Destination: &c.Credentials.Password,
+			Usage:       "Password of the user",
+			Required:    false,
+		},
 		&cli.StringFlag{
 			Name:        "logger-level",
 			EnvVars:     []string{"LOG_LEVEL"},
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/buurzx/in-mem-kvdb/cmd/kvcli"
+	"github.com/buurzx/in-mem-kvdb/cmd/passwd"
 	"github.com/buurzx/in-mem-kvdb/cmd/server"
 	"github.com/urfave/cli/v2"
 )
@@ -16,6 +17,7 @@ func main() {
 		Commands: []*cli.Command{
 			kvcli.BuildCmd(),
 			server.BuildCmd(),
+			passwd.BuildCmd(),
 		},
 	}
 
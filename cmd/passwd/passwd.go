@@ -0,0 +1,35 @@
+package passwd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
+	"github.com/urfave/cli/v2"
+)
+
+var errEmptyPassword = errors.New("password is empty")
+
+func BuildCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "kvdb-passwd",
+		Usage: "Hash a password read from stdin for the password-hash of a user",
+		Action: func(c *cli.Context) error {
+			password, err := bufio.NewReader(c.App.Reader).ReadString('\n')
+			password = strings.TrimRight(password, "\r\n")
+			if password == "" {
+				return errors.Join(errEmptyPassword, err)
+			}
+
+			hash, err := auth.HashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintln(c.App.Writer, hash)
+			return err
+		},
+	}
+}
@@ -5,28 +5,51 @@ import (
 	"log"
 	"os"
 
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
+	"github.com/buurzx/in-mem-kvdb/internal/initialization"
+	"github.com/buurzx/in-mem-kvdb/internal/network/tlsconfig"
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 // Config is a application configuration structure
 type Config struct {
-	Engine struct {
-		Type string `yaml:"type" env:"ENGINE_TYPE" env-description:"Database engine type" env-default:"in_memory"`
-	} `yaml:"engine"`
+	Engine   initialization.EngineConfig   `yaml:"engine"`
+	WAL      initialization.WALConfig      `yaml:"wal"`
+	Snapshot initialization.SnapshotConfig `yaml:"snapshot"`
+	Auth     auth.Config                   `yaml:"auth"`
 
 	Network struct {
 		Address        string `yaml:"address" env:"KVDB_ADDRESS" env-description:"Network address"`
+		Protocol       string `yaml:"protocol" env:"KVDB_NETWORK_PROTOCOL" env-description:"Wire protocol: text or resp" env-default:"text"`
 		MaxConnections int    `yaml:"max-connections" env:"KVDB_MAX_CONNECTIONS" env-description:"Maximum number of connections" env-default:"100"`
 		MaxMessageSize int    `yaml:"max-message-size" env:"KVDB_NETWORK_MAX_MESSAGE_SIZE" env-description:"Maximum message size" env-default:"4096"`
 		IdleTimeout    int    `yaml:"idle-timeout" env:"KVDB_NETWORK_IDLE_TIMEOUT" env-description:"Idle timeout" env-default:"300"`
+		// ShutdownTimeout is how long in-flight requests are waited for on shutdown.
+		ShutdownTimeout int `yaml:"shutdown-timeout" env:"KVDB_NETWORK_SHUTDOWN_TIMEOUT" env-description:"Shutdown timeout in seconds" env-default:"30"`
+		// Listeners are served in addition to Address, each with its own protocol.
+		Listeners []ListenerConfig `yaml:"listeners"`
+		// TLS applies to every listener.
+		TLS tlsconfig.Config `yaml:"tls"`
 	} `yaml:"network"`
 
+	HTTP struct {
+		Enabled     bool   `yaml:"enabled" env:"KVDB_HTTP_ENABLED" env-description:"Serve the HTTP JSON API" env-default:"false"`
+		Address     string `yaml:"address" env:"KVDB_HTTP_ADDRESS" env-description:"HTTP network address" env-default:"127.0.0.1:8081"`
+		MaxBodySize int64  `yaml:"max-body-size" env:"KVDB_HTTP_MAX_BODY_SIZE" env-description:"Maximum request body size" env-default:"1048576"`
+	} `yaml:"http"`
+
 	Logger struct {
 		Level          string `yaml:"level" env:"LOG_LEVEL" env-description:"Log level" env-default:"info"`
 		OutputFilePath string `yaml:"output-file-path" env:"LOG_OUTPUT_FILE_PATH" env-description:"Log output file path"`
 	} `yaml:"logger"`
 }
 
+// ListenerConfig is an additional address the server listens on.
+type ListenerConfig struct {
+	Address  string `yaml:"address"`
+	Protocol string `yaml:"protocol"`
+}
+
 func mustParseConfiguration() *Config {
 	const confg = "config.yml"
 	var (
@@ -5,11 +5,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/database"
 	"github.com/buurzx/in-mem-kvdb/internal/initialization"
+	"github.com/buurzx/in-mem-kvdb/internal/network/httpapi"
 	network "github.com/buurzx/in-mem-kvdb/internal/network/tcp"
+	"github.com/buurzx/in-mem-kvdb/internal/network/tlsconfig"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
@@ -36,43 +40,181 @@ func runServer(ctx context.Context) error {
 		log.Fatal(err)
 	}
 
-	db, err := initialization.CreateDatabase(logger)
+	db, err := initialization.CreateDatabase(ctxWithCancel, initialization.DatabaseConfig{
+		Engine:   config.Engine,
+		WAL:      config.WAL,
+		Snapshot: config.Snapshot,
+		Auth:     config.Auth,
+	}, logger)
 	if err != nil {
 		logger.Fatal("failed to create database", zap.Error(err))
 	}
 
-	server, err := network.NewTCPServer(
-		logger,
-		network.WithServerAddress(config.Network.Address),
-		network.WithServerIdleTimeout(time.Duration(config.Network.IdleTimeout)*time.Second),
-		network.WithServerMaxConnections(config.Network.MaxConnections),
-		network.WithServerBufferSize(config.Network.MaxMessageSize),
+	listeners := append([]ListenerConfig{{
+		Address:  config.Network.Address,
+		Protocol: config.Network.Protocol,
+	}}, config.Network.Listeners...)
+
+	var (
+		tlsOptions     []network.TCPServerOption
+		httpTLSOptions []httpapi.HTTPServerOption
 	)
-	if err != nil {
-		logger.Fatal("failed to create tcp server", zap.Error(err))
+	if config.Network.TLS.Enabled {
+		reloader, err := tlsconfig.NewReloader(config.Network.TLS, logger)
+		if err != nil {
+			logger.Fatal("failed to load tls certificates", zap.Error(err))
+		}
+
+		tlsConfig, err := reloader.ServerConfig()
+		if err != nil {
+			logger.Fatal("invalid tls configuration", zap.Error(err))
+		}
+
+		tlsOptions = append(tlsOptions, network.WithServerTLSConfig(tlsConfig))
+		httpTLSOptions = append(httpTLSOptions, httpapi.WithServerTLSConfig(tlsConfig))
+		go reloader.WatchPeriodically(ctxWithCancel)
 	}
 
-	// Start server in a goroutine
-	go func() {
-		logger.Info("starting in-mem-kvdb server", zap.String("address", config.Network.Address))
-		server.Start(ctxWithCancel, db)
-	}()
+	servers := make([]drainableServer, 0, len(listeners)+1)
+	for _, listener := range listeners {
+		protocol, err := network.ParseProtocol(listener.Protocol)
+		if err != nil {
+			logger.Fatal("invalid listener protocol", zap.String("address", listener.Address), zap.Error(err))
+		}
+
+		options := append([]network.TCPServerOption{
+			network.WithServerAddress(listener.Address),
+			network.WithServerProtocol(protocol),
+			network.WithServerIdleTimeout(time.Duration(config.Network.IdleTimeout) * time.Second),
+			network.WithServerMaxConnections(config.Network.MaxConnections),
+			network.WithServerMaxMessageSize(config.Network.MaxMessageSize),
+		}, tlsOptions...)
+
+		server, err := network.NewTCPServer(logger, options...)
+		if err != nil {
+			logger.Fatal("failed to create tcp server", zap.String("address", listener.Address), zap.Error(err))
+		}
+
+		servers = append(servers, server)
+
+		go func() {
+			logger.Info("starting in-mem-kvdb server",
+				zap.String("address", listener.Address),
+				zap.String("protocol", string(protocol)),
+				zap.Bool("tls", config.Network.TLS.Enabled))
+			// requests keep running while the server drains
+			server.Start(context.WithoutCancel(ctxWithCancel), db)
+		}()
+	}
+
+	if config.HTTP.Enabled {
+		options := append([]httpapi.HTTPServerOption{
+			httpapi.WithServerAddress(config.HTTP.Address),
+			httpapi.WithServerMaxBodySize(config.HTTP.MaxBodySize),
+			httpapi.WithServerIdleTimeout(time.Duration(config.Network.IdleTimeout) * time.Second),
+		}, httpTLSOptions...)
+
+		server, err := httpapi.NewHTTPServer(logger, options...)
+		if err != nil {
+			logger.Fatal("failed to create http server", zap.String("address", config.HTTP.Address), zap.Error(err))
+		}
+
+		servers = append(servers, server)
+
+		go func() {
+			logger.Info("starting in-mem-kvdb http server",
+				zap.String("address", config.HTTP.Address),
+				zap.Bool("tls", config.Network.TLS.Enabled))
+			server.Start(context.WithoutCancel(ctxWithCancel), db)
+		}()
+	}
 
 	handleSignals(cancel, logger)
 
 	// Wait for either context cancellation or server error
 
 	<-ctxWithCancel.Done()
-	logger.Info("shutting down server gracefully...")
-	if err := server.Close(); err != nil {
-		logger.Error("error during shutdown", zap.Error(err))
-	}
 
-	os.Exit(0)
+	shutdownTimeout := time.Duration(config.Network.ShutdownTimeout) * time.Second
+	logger.Info("shutting down server gracefully...", zap.Duration("timeout", shutdownTimeout))
+
+	shutdown(servers, db, config, shutdownTimeout, logger)
 
 	return nil
 }
 
+// drainableServer is a server shut down gracefully.
+type drainableServer interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+	Wait(ctx context.Context) error
+	ActiveConnections() int
+}
+
+// closeTimeout bounds the wait for requests of connections closed by force
+// to return, so they do not write to a closed database.
+const closeTimeout = 5 * time.Second
+
+// shutdown stops accepting connections, notifies idle clients and waits for
+// in-flight requests up to the timeout, closes connections left and waits for
+// their requests to return, then flushes the database.
+func shutdown(servers []drainableServer, db *database.Database, config *Config, timeout time.Duration, logger *zap.Logger) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	drained := make([]bool, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Warn("in-flight requests did not finish before shutdown timeout", zap.Error(err))
+				return
+			}
+			drained[i] = true
+		}()
+	}
+	wg.Wait()
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer closeCancel()
+
+	forced := 0
+	for i, server := range servers {
+		if drained[i] {
+			continue
+		}
+
+		forced += server.ActiveConnections()
+		if err := server.Close(); err != nil {
+			logger.Error("error during shutdown", zap.Error(err))
+		}
+
+		if err := server.Wait(closeCtx); err != nil {
+			logger.Error("requests of closed connections did not return", zap.Error(err))
+		}
+	}
+
+	if db.SnapshotsEnabled() {
+		if err := db.Snapshot(context.Background()); err != nil {
+			logger.Error("failed to save snapshot on shutdown", zap.Error(err))
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Error("failed to close database", zap.Error(err))
+	}
+
+	logger.Info("server stopped",
+		zap.Bool("drained", forced == 0),
+		zap.Int("forced_connections", forced),
+		zap.Duration("elapsed", time.Since(start)))
+}
+
 func handleSignals(cancel context.CancelFunc, logger *zap.Logger) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
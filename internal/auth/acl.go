@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+)
+
+// ErrNoPermission is returned for commands the user is not allowed to run.
+var ErrNoPermission = errors.New("no permission")
+
+var (
+	errInvalidRule     = errors.New("invalid ACL rule")
+	errInvalidCategory = errors.New("unknown command category")
+)
+
+const allCategories = "all"
+
+// user is replaced rather than modified once it is shared, so that it can be
+// read without holding the lock of the Authenticator.
+type user struct {
+	// password is nil for users who cannot authenticate.
+	password   *passwordHash
+	categories map[compute.Category]bool
+	// keys are glob patterns of the keys the user may access.
+	keys []string
+}
+
+func newUser(config UserConfig) (*user, error) {
+	password, err := parsePasswordHash(config.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{password: &password, categories: make(map[compute.Category]bool)}
+
+	categories := config.Categories
+	if categories == nil {
+		categories = []string{allCategories}
+	}
+
+	for _, category := range categories {
+		if err := u.allow(category, true); err != nil {
+			return nil, err
+		}
+	}
+
+	u.keys = config.Keys
+	if u.keys == nil {
+		u.keys = []string{"*"}
+	}
+
+	return u, nil
+}
+
+func (u *user) clone() *user {
+	clone := *u
+	clone.categories = maps.Clone(u.categories)
+	clone.keys = slices.Clone(u.keys)
+
+	return &clone
+}
+
+// allow allows or denies commands of the category, "all" stands for every category.
+func (u *user) allow(category string, allowed bool) error {
+	category = strings.ToLower(category)
+	if category == allCategories {
+		for _, category := range compute.Categories() {
+			u.categories[category] = allowed
+		}
+		return nil
+	}
+
+	if !slices.Contains(compute.Categories(), compute.Category(category)) {
+		return fmt.Errorf("%w %q", errInvalidCategory, category)
+	}
+
+	u.categories[compute.Category(category)] = allowed
+	return nil
+}
+
+// apply changes the user by a rule of ACL SETUSER:
+//
+//	#<hash>        sets the password hash
+//	resetpass      removes the password, so the user cannot authenticate
+//	+@<category>   allows commands of the category, or of every category with +@all
+//	-@<category>   denies commands of the category, or of every category with -@all
+//	~<pattern>     allows access to keys matching the glob pattern
+//	allkeys        allows access to every key, the same as ~*
+//	resetkeys      denies access to every key
+func (u *user) apply(rule string) error {
+	switch {
+	case strings.HasPrefix(rule, "#"):
+		password, err := parsePasswordHash(rule[1:])
+		if err != nil {
+			return err
+		}
+		u.password = &password
+	case strings.EqualFold(rule, "resetpass"):
+		u.password = nil
+	case strings.HasPrefix(rule, "+@"):
+		return u.allow(rule[2:], true)
+	case strings.HasPrefix(rule, "-@"):
+		return u.allow(rule[2:], false)
+	case strings.HasPrefix(rule, "~"):
+		u.keys = append(u.keys, rule[1:])
+	case strings.EqualFold(rule, "allkeys"):
+		u.keys = append(u.keys, "*")
+	case strings.EqualFold(rule, "resetkeys"):
+		u.keys = nil
+	default:
+		return fmt.Errorf("%w %q", errInvalidRule, rule)
+	}
+
+	return nil
+}
+
+func (u *user) canAccess(key string) bool {
+	for _, pattern := range u.keys {
+		if matchGlob(pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canAccessPrefix reports whether every key starting with the prefix matches
+// one of the patterns, which holds for patterns ending with '*' that match
+// the prefix itself.
+func (u *user) canAccessPrefix(prefix string) bool {
+	for _, pattern := range u.keys {
+		if strings.HasSuffix(pattern, "*") && matchGlob(pattern, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *user) canAccessAllKeys() bool {
+	return slices.Contains(u.keys, "*")
+}
+
+// rules describes the user by the rules of ACL SETUSER.
+func (u *user) rules() []string {
+	var rules []string
+	if u.password != nil {
+		rules = append(rules, "#"+u.password.String())
+	}
+
+	for _, pattern := range u.keys {
+		rules = append(rules, "~"+pattern)
+	}
+
+	var categories []string
+	for _, category := range compute.Categories() {
+		if u.categories[category] {
+			categories = append(categories, "+@"+string(category))
+		}
+	}
+
+	switch len(categories) {
+	case 0:
+		rules = append(rules, "-@"+allCategories)
+	case len(compute.Categories()):
+		rules = append(rules, "+@"+allCategories)
+	default:
+		rules = append(rules, categories...)
+	}
+
+	return rules
+}
+
+// Authorize returns ErrNoPermission unless the user may run the command on
+// its keys. Commands that may access every key require access to every key,
+// commands accessing keys by a prefix require access to every key with it.
+func (a *Authenticator) Authorize(name string, query compute.Query) error {
+	a.mutex.RLock()
+	user, ok := a.users[name]
+	a.mutex.RUnlock()
+
+	if !ok {
+		// the user was deleted after the session authenticated
+		return ErrAuthRequired
+	}
+
+	if category := query.Category(); category != compute.CategoryNone && !user.categories[category] {
+		return fmt.Errorf("%w to run %s", ErrNoPermission, query.Name())
+	}
+
+	keys, all := query.Keys()
+	if all && !user.canAccessAllKeys() {
+		return fmt.Errorf("%w to run %s on every key", ErrNoPermission, query.Name())
+	}
+
+	for _, key := range keys {
+		if !user.canAccess(key) {
+			return fmt.Errorf("%w to access key %q", ErrNoPermission, key)
+		}
+	}
+
+	if prefix, ok := query.KeyPrefix(); ok && !user.canAccessPrefix(prefix) {
+		return fmt.Errorf("%w to access keys %q", ErrNoPermission, prefix+"*")
+	}
+
+	return nil
+}
+
+// SetUser creates the user or changes an existing one by the rules, see
+// user.apply. A ">password" rule sets the password hashed. New users have no
+// password, categories and keys until rules grant them.
+func (a *Authenticator) SetUser(name string, rules []string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("%w: %q", errInvalidUser, name)
+	}
+
+	// passwords are hashed before taking the lock, as hashing is slow
+	rules = slices.Clone(rules)
+	for i, rule := range rules {
+		if password, ok := strings.CutPrefix(rule, ">"); ok {
+			hash, err := HashPassword(password)
+			if err != nil {
+				return err
+			}
+			rules[i] = "#" + hash
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	u := &user{categories: make(map[compute.Category]bool)}
+	if existing, ok := a.users[name]; ok {
+		u = existing.clone()
+	}
+
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return err
+		}
+	}
+
+	a.users[name] = u
+	delete(a.verified, name)
+
+	return nil
+}
+
+// DelUser deletes the users and returns how many existed. Sessions of the
+// deleted users have to authenticate again.
+func (a *Authenticator) DelUser(names ...string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			delete(a.verified, name)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// List describes every user as "user <name> <rule> ...", ordered by name.
+func (a *Authenticator) List() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	names := slices.Sorted(maps.Keys(a.users))
+
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, strings.Join(append([]string{"user", name}, a.users[name].rules()...), " "))
+	}
+
+	return list
+}
+
+// matchGlob reports whether the key matches the pattern, where '*' matches
+// any sequence of bytes and '?' matches a single byte.
+func matchGlob(pattern, key string) bool {
+	// position to resume from when the last '*' has to match one more byte
+	star, resume := -1, 0
+
+	p, k := 0, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, resume = p, k
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case star >= 0:
+			resume++
+			p, k = star+1, resume
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrAuthRequired is returned for commands of connections that did not authenticate.
+	ErrAuthRequired = errors.New("authentication required")
+	// ErrInvalidCredentials is returned when the user does not exist or the password does not match.
+	ErrInvalidCredentials = errors.New("invalid username-password pair")
+	// ErrDisabled is returned for AUTH when no users are configured.
+	ErrDisabled = errors.New("AUTH called without any users configured")
+	// ErrTooManyAttempts is returned to clients that failed to authenticate
+	// too many times in a row until they may try again.
+	ErrTooManyAttempts = errors.New("too many failed authentication attempts, try again later")
+
+	errNoUsers       = errors.New("at least one user is required when auth is enabled")
+	errDuplicateUser = errors.New("duplicate user")
+	errInvalidUser   = errors.New("user name is required")
+)
+
+// Config is the authentication configuration section. Passwords are stored
+// as hashes produced by HashPassword.
+type Config struct {
+	Enabled bool         `yaml:"enabled" env:"KVDB_AUTH_ENABLED" env-description:"Require AUTH before other commands" env-default:"false"`
+	Users   []UserConfig `yaml:"users"`
+}
+
+// UserConfig is a user allowed to authenticate. A user without categories
+// may run commands of every category, and a user without keys may access
+// every key.
+type UserConfig struct {
+	Name         string `yaml:"name"`
+	PasswordHash string `yaml:"password-hash"`
+	// Categories are the command categories the user may run: read, write and admin.
+	Categories []string `yaml:"categories"`
+	// Keys are glob patterns of keys the user may access, such as team-a:*.
+	Keys []string `yaml:"keys"`
+}
+
+// Authenticator checks credentials and permissions of users, which can be
+// changed at runtime with ACL commands.
+type Authenticator struct {
+	mutex sync.RWMutex
+	users map[string]*user
+	// verified holds digests of the last password verified for each user,
+	// so that authenticating again does not pay for key derivation.
+	verified map[string][sha256.Size]byte
+	// throttle slows down clients failing to authenticate.
+	throttle *throttle
+}
+
+// dummyHash is verified for unknown users, so that they take as long as
+// users with a wrong password.
+var dummyHash = sync.OnceValue(func() passwordHash {
+	return newPasswordHash("", make([]byte, saltSize), hashIterations)
+})
+
+func NewAuthenticator(config Config) (*Authenticator, error) {
+	if len(config.Users) == 0 {
+		return nil, errNoUsers
+	}
+
+	users := make(map[string]*user, len(config.Users))
+	for _, userConfig := range config.Users {
+		if userConfig.Name == "" {
+			return nil, errInvalidUser
+		}
+
+		if _, ok := users[userConfig.Name]; ok {
+			return nil, fmt.Errorf("%w %q", errDuplicateUser, userConfig.Name)
+		}
+
+		user, err := newUser(userConfig)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", userConfig.Name, err)
+		}
+
+		users[userConfig.Name] = user
+	}
+
+	return &Authenticator{
+		users:    users,
+		verified: make(map[string][sha256.Size]byte),
+		throttle: newThrottle(),
+	}, nil
+}
+
+// Authenticate returns ErrInvalidCredentials unless the password is the
+// password of the user. Clients failing too many times in a row get
+// ErrTooManyAttempts, without the password being verified, until they wait
+// long enough.
+func (a *Authenticator) Authenticate(client, name, password string) error {
+	if !a.throttle.allow(client) {
+		return ErrTooManyAttempts
+	}
+
+	if err := a.authenticate(name, password); err != nil {
+		return err
+	}
+
+	a.throttle.succeed(client)
+
+	return nil
+}
+
+func (a *Authenticator) authenticate(name, password string) error {
+	a.mutex.RLock()
+	user, ok := a.users[name]
+	verified, cached := a.verified[name]
+	a.mutex.RUnlock()
+
+	if !ok || user.password == nil {
+		dummyHash().verify(password)
+		return ErrInvalidCredentials
+	}
+
+	hash := *user.password
+	digest := hash.digest(password)
+
+	if cached && subtle.ConstantTimeCompare(digest[:], verified[:]) == 1 {
+		return nil
+	}
+
+	if !hash.verify(password) {
+		return ErrInvalidCredentials
+	}
+
+	a.mutex.Lock()
+	// the password may have changed while it was verified
+	if current, ok := a.users[name]; ok && current.password != nil && current.password.String() == hash.String() {
+		a.verified[name] = digest
+	}
+	a.mutex.Unlock()
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHash hashes with few iterations to keep tests fast.
+func testHash(password string) string {
+	return newPasswordHash(password, []byte("0123456789abcdef"), 1000).String()
+}
+
+func TestHashPassword(t *testing.T) {
+	encoded, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.Regexp(t, `^pbkdf2-sha256\$600000\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, encoded)
+
+	hash, err := parsePasswordHash(encoded)
+	require.NoError(t, err)
+	assert.True(t, hash.verify("secret"))
+	assert.False(t, hash.verify("Secret"))
+
+	other, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, encoded, other, "hashes are salted")
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	tests := []string{
+		"secret",
+		"sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$key",
+		"pbkdf2-sha256$0$MDEyMzQ1Njc4OWFiY2RlZg$ShiH6DdzmQKsmthZLn6AXGuwlBn2cy8Wr0Hd8yR+ugQ",
+		"pbkdf2-sha256$1000$$ShiH6DdzmQKsmthZLn6AXGuwlBn2cy8Wr0Hd8yR+ugQ",
+		"pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$short",
+	}
+
+	for _, test := range tests {
+		_, err := parsePasswordHash(test)
+		assert.ErrorIs(t, err, errInvalidHash, test)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	authenticator, err := NewAuthenticator(Config{
+		Enabled: true,
+		Users: []UserConfig{
+			{Name: "admin", PasswordHash: testHash("secret")},
+			{Name: "reader", PasswordHash: testHash("other secret")},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, authenticator.Authenticate("", "admin", "secret"))
+	assert.NoError(t, authenticator.Authenticate("", "reader", "other secret"))
+	assert.ErrorIs(t, authenticator.Authenticate("", "admin", "other secret"), ErrInvalidCredentials)
+	assert.ErrorIs(t, authenticator.Authenticate("", "nobody", "secret"), ErrInvalidCredentials)
+
+	// verified passwords are cached, others are still checked
+	assert.NoError(t, authenticator.Authenticate("", "admin", "secret"))
+	assert.ErrorIs(t, authenticator.Authenticate("", "admin", "secret "), ErrInvalidCredentials)
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewAuthenticator(Config{Enabled: true})
+		assert.ErrorIs(t, err, errNoUsers)
+
+		_, err = NewAuthenticator(Config{Users: []UserConfig{
+			{Name: "admin", PasswordHash: testHash("a")},
+			{Name: "admin", PasswordHash: testHash("b")},
+		}})
+		assert.ErrorIs(t, err, errDuplicateUser)
+
+		_, err = NewAuthenticator(Config{Users: []UserConfig{{Name: "admin", PasswordHash: "secret"}}})
+		assert.ErrorIs(t, err, errInvalidHash)
+	})
+}
+
+func TestAuthenticatorThrottle(t *testing.T) {
+	authenticator, err := NewAuthenticator(Config{
+		Enabled: true,
+		Users:   []UserConfig{{Name: "admin", PasswordHash: testHash("secret")}},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	authenticator.throttle.now = func() time.Time { return now }
+
+	for range freeAttempts {
+		assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "wrong"), ErrInvalidCredentials)
+	}
+
+	// the first attempt over the limit is verified, then the client waits
+	assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "wrong"), ErrInvalidCredentials)
+	assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "secret"), ErrTooManyAttempts)
+
+	// other clients are not throttled
+	assert.NoError(t, authenticator.Authenticate("10.0.0.2", "admin", "secret"))
+
+	now = now.Add(minLockout)
+	assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "wrong"), ErrInvalidCredentials)
+
+	// the lockout doubles with every failure
+	now = now.Add(minLockout)
+	assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "secret"), ErrTooManyAttempts)
+
+	now = now.Add(minLockout)
+	assert.NoError(t, authenticator.Authenticate("10.0.0.1", "admin", "secret"))
+
+	// a success forgets the failures
+	assert.ErrorIs(t, authenticator.Authenticate("10.0.0.1", "admin", "wrong"), ErrInvalidCredentials)
+	assert.NoError(t, authenticator.Authenticate("10.0.0.1", "admin", "secret"))
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		matches bool
+	}{
+		{pattern: "*", key: "", matches: true},
+		{pattern: "*", key: "any/key with spaces", matches: true},
+		{pattern: "team-a:*", key: "team-a:users:1", matches: true},
+		{pattern: "team-a:*", key: "team-a:", matches: true},
+		{pattern: "team-a:*", key: "team-b:users", matches: false},
+		{pattern: "team-?:*", key: "team-b:users", matches: true},
+		{pattern: "team-?:*", key: "team-bc:users", matches: false},
+		{pattern: "*:config", key: "team-a:users:config", matches: true},
+		{pattern: "*:config", key: "team-a:config:old", matches: false},
+		{pattern: "a*b*c", key: "aXbYbZc", matches: true},
+		{pattern: "a*b*c", key: "aXbYc!", matches: false},
+		{pattern: "exact", key: "exact", matches: true},
+		{pattern: "exact", key: "exactly", matches: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, matchGlob(test.pattern, test.key), "%s %s", test.pattern, test.key)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashAlgorithm = "pbkdf2-sha256"
+	// hashIterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+	hashIterations = 600_000
+	saltSize       = 16
+	keySize        = 32
+)
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// passwordHash is a password hashed by PBKDF2-HMAC-SHA256, encoded as
+// pbkdf2-sha256$<iterations>$<salt>$<key> with base64 salt and key.
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// HashPassword hashes the password with a random salt, the result is what
+// password-hash of a user is set to.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return newPasswordHash(password, salt, hashIterations).String(), nil
+}
+
+func newPasswordHash(password string, salt []byte, iterations int) passwordHash {
+	key, _ := pbkdf2.Key(sha256.New, password, salt, iterations, keySize)
+
+	return passwordHash{iterations: iterations, salt: salt, key: key}
+}
+
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != hashAlgorithm {
+		return passwordHash{}, fmt.Errorf("%w: expected %s$<iterations>$<salt>$<key>", errInvalidHash, hashAlgorithm)
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return passwordHash{}, fmt.Errorf("%w: iterations must be a positive integer", errInvalidHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return passwordHash{}, fmt.Errorf("%w: invalid salt", errInvalidHash)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) != keySize {
+		return passwordHash{}, fmt.Errorf("%w: invalid key", errInvalidHash)
+	}
+
+	return passwordHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+func (h passwordHash) verify(password string) bool {
+	key, _ := pbkdf2.Key(sha256.New, password, h.salt, h.iterations, keySize)
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// digest is a cheap salted hash of the password, used to recognize a password
+// already verified.
+func (h passwordHash) digest(password string) [sha256.Size]byte {
+	return sha256.Sum256(append(h.salt[:len(h.salt):len(h.salt)], password...))
+}
+
+func (h passwordHash) String() string {
+	return strings.Join([]string{
+		hashAlgorithm,
+		strconv.Itoa(h.iterations),
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key),
+	}, "$")
+}
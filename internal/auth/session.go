@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Session is the state of a client connection, which lives as long as the
+// connection and is passed to the database in the request context.
+type Session struct {
+	mutex sync.RWMutex
+	user  string
+	// client is the host the connection comes from.
+	client string
+}
+
+// NewSession returns the session of a connection from the remote address.
+// Failed authentications are throttled per host, so connections from the
+// same host share their failures.
+func NewSession(remoteAddress string) *Session {
+	host, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		host = remoteAddress
+	}
+
+	return &Session{client: host}
+}
+
+// Client returns the host the connection comes from.
+func (s *Session) Client() string {
+	return s.client
+}
+
+// User returns the name of the authenticated user, empty until the session
+// authenticates.
+func (s *Session) User() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.user
+}
+
+func (s *Session) Authenticated() bool {
+	return s.User() != ""
+}
+
+// Login marks the session as authenticated by the user.
+func (s *Session) Login(user string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.user = user
+}
+
+type sessionKey struct{}
+
+// NewContext returns a context carrying the session.
+func NewContext(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// FromContext returns the session carried by the context, if any.
+func FromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// freeAttempts is the number of failed attempts a client may make in a
+	// row before it has to wait between attempts.
+	freeAttempts = 5
+	// minLockout is the wait after the first attempt over freeAttempts,
+	// it doubles with every further attempt up to maxLockout.
+	minLockout = time.Second
+	maxLockout = time.Minute
+	// forgetAfter is the time after which failures of a client that stopped
+	// trying are forgotten.
+	forgetAfter = 10 * time.Minute
+	// pruneThreshold is the number of tracked clients over which forgotten
+	// clients are removed.
+	pruneThreshold = 1024
+)
+
+// throttle limits the rate of authentication attempts of clients that keep
+// failing, so that they can not keep the CPU busy with password hashing.
+type throttle struct {
+	mutex   sync.Mutex
+	clients map[string]*attempts
+	now     func() time.Time
+}
+
+type attempts struct {
+	// count is the number of attempts since the last success, including
+	// attempts being verified.
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		clients: make(map[string]*attempts),
+		now:     time.Now,
+	}
+}
+
+// allow reserves an attempt of the client before its password is verified,
+// so that concurrent attempts count as well. It reports false while the
+// client is locked out.
+func (t *throttle) allow(client string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+
+	a, ok := t.clients[client]
+	if !ok || now.Sub(a.last) > forgetAfter {
+		if len(t.clients) >= pruneThreshold {
+			t.prune(now)
+		}
+
+		a = &attempts{}
+		t.clients[client] = a
+	}
+
+	if now.Before(a.lockedUntil) {
+		return false
+	}
+
+	a.count++
+	a.last = now
+	if over := a.count - freeAttempts; over > 0 {
+		lockout := minLockout
+		for i := 1; i < over && lockout < maxLockout; i++ {
+			lockout *= 2
+		}
+		a.lockedUntil = now.Add(min(lockout, maxLockout))
+	}
+
+	return true
+}
+
+// succeed forgets the failures of a client that authenticated.
+func (t *throttle) succeed(client string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.clients, client)
+}
+
+// prune must be called with mutex held.
+func (t *throttle) prune(now time.Time) {
+	for client, a := range t.clients {
+		if now.Sub(a.last) > forgetAfter {
+			delete(t.clients, client)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+)
+
+var errPopTimeout = errors.New("timed out, no item was pushed")
+
+// WatchFunc watches the client of a request that waits for data. The
+// returned context is done once the client goes away, stop ends the watch
+// and must be called before the next request of the client is read.
+type WatchFunc func(ctx context.Context) (watched context.Context, stop context.CancelFunc)
+
+type watchKey struct{}
+
+// NewWatchContext returns a context carrying the watch of the client
+// connection, which commands call before they wait for data, like BLPOP
+// waiting for an item to be pushed. Without it they wait until the context
+// is done.
+func NewWatchContext(ctx context.Context, watch WatchFunc) context.Context {
+	return context.WithValue(ctx, watchKey{}, watch)
+}
+
+type execKey struct{}
+
+// execContext marks the context of the commands run by EXEC, which must
+// not wait since no other command runs until they reply.
+func execContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, execKey{}, true)
+}
+
+func inExec(ctx context.Context) bool {
+	return ctx.Value(execKey{}) != nil
+}
+
+// popFunc removes an item from one end of a list.
+type popFunc func(ctx context.Context, key string) (string, bool, error)
+
+// blockingPop pops an item from the first non-empty list of the keys and
+// replies with the key and the item. If all of them are empty, it waits for
+// an item to be pushed until the timeout, zero meaning no timeout, and
+// replies with nil if none was. No storage lock is held while waiting.
+func (d *Database) blockingPop(ctx context.Context, query compute.Query, pop popFunc) Reply {
+	args := query.Arguments()
+	keys := args[:len(args)-1]
+
+	// pushes made once the lists are found empty wake the wait up
+	pushed, stop := d.storage.WaitPush(keys)
+	defer stop()
+
+	var timeout <-chan time.Time
+	if seconds := query.FloatArgument(len(args) - 1); seconds > 0 && seconds < math.MaxInt64/float64(time.Second) {
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	watched := false
+	for {
+		for _, key := range keys {
+			item, found, err := pop(ctx, key)
+			if err != nil {
+				return errorReply(err)
+			}
+
+			if found {
+				return arrayReply(bulkReply(key), bulkReply(item))
+			}
+		}
+
+		if inExec(ctx) {
+			return popTimeoutReply()
+		}
+
+		if watch, ok := ctx.Value(watchKey{}).(WatchFunc); ok && !watched {
+			var stopWatch context.CancelFunc
+			ctx, stopWatch = watch(ctx)
+			defer stopWatch()
+			watched = true
+		}
+
+		select {
+		case <-pushed:
+		case <-timeout:
+			return popTimeoutReply()
+		case <-ctx.Done():
+			// the client went away or the server is shutting down
+			return popTimeoutReply()
+		}
+	}
+}
+
+// popTimeoutReply is the nil reply of blocking pops finding no item.
+func popTimeoutReply() Reply {
+	return Reply{Kind: NilReply, Err: errPopTimeout}
+}
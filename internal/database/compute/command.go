@@ -1,42 +1,941 @@
 package compute
 
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
-	UnknownCommandID = iota
+	UnknownCommandID CommandID = iota
 	SetCommandID
 	GetCommandID
 	DelCommandID
+	SaveCommandID
+	BgsaveCommandID
+	ExpireCommandID
+	PexpireCommandID
+	TTLCommandID
+	PttlCommandID
+	PersistCommandID
+	ScanCommandID
+	RangeCommandID
+	KeysCommandID
+	PingCommandID
+	AuthCommandID
+	ACLCommandID
+	IncrCommandID
+	DecrCommandID
+	IncrbyCommandID
+	DecrbyCommandID
+	IncrbyfloatCommandID
+	MultiCommandID
+	ExecCommandID
+	DiscardCommandID
+	WatchCommandID
+	UnwatchCommandID
+	SetnxCommandID
+	GetsetCommandID
+	GetdelCommandID
+	CasCommandID
+	MgetCommandID
+	MsetCommandID
+	MsetnxCommandID
+	HsetCommandID
+	HgetCommandID
+	HmgetCommandID
+	HdelCommandID
+	HgetallCommandID
+	HkeysCommandID
+	HlenCommandID
+	HexistsCommandID
+	HincrbyCommandID
+	LpushCommandID
+	RpushCommandID
+	LpopCommandID
+	RpopCommandID
+	LrangeCommandID
+	LlenCommandID
+	LindexCommandID
+	LtrimCommandID
+	BlpopCommandID
+	BrpopCommandID
 )
 
 var (
-	UnknownCommand = "UNKNOWN"
-	SetCommand     = "SET"
-	GetCommand     = "GET"
-	DelCommand     = "DEL"
+	UnknownCommand     = "UNKNOWN"
+	SetCommand         = "SET"
+	GetCommand         = "GET"
+	DelCommand         = "DEL"
+	SaveCommand        = "SAVE"
+	BgsaveCommand      = "BGSAVE"
+	ExpireCommand      = "EXPIRE"
+	PexpireCommand     = "PEXPIRE"
+	TTLCommand         = "TTL"
+	PttlCommand        = "PTTL"
+	PersistCommand     = "PERSIST"
+	ScanCommand        = "SCAN"
+	RangeCommand       = "RANGE"
+	KeysCommand        = "KEYS"
+	PingCommand        = "PING"
+	AuthCommand        = "AUTH"
+	ACLCommand         = "ACL"
+	IncrCommand        = "INCR"
+	DecrCommand        = "DECR"
+	IncrbyCommand      = "INCRBY"
+	DecrbyCommand      = "DECRBY"
+	IncrbyfloatCommand = "INCRBYFLOAT"
+	MultiCommand       = "MULTI"
+	ExecCommand        = "EXEC"
+	DiscardCommand     = "DISCARD"
+	WatchCommand       = "WATCH"
+	UnwatchCommand     = "UNWATCH"
+	SetnxCommand       = "SETNX"
+	GetsetCommand      = "GETSET"
+	GetdelCommand      = "GETDEL"
+	CasCommand         = "CAS"
+	MgetCommand        = "MGET"
+	MsetCommand        = "MSET"
+	MsetnxCommand      = "MSETNX"
+	HsetCommand        = "HSET"
+	HgetCommand        = "HGET"
+	HmgetCommand       = "HMGET"
+	HdelCommand        = "HDEL"
+	HgetallCommand     = "HGETALL"
+	HkeysCommand       = "HKEYS"
+	HlenCommand        = "HLEN"
+	HexistsCommand     = "HEXISTS"
+	HincrbyCommand     = "HINCRBY"
+	LpushCommand       = "LPUSH"
+	RpushCommand       = "RPUSH"
+	LpopCommand        = "LPOP"
+	RpopCommand        = "RPOP"
+	LrangeCommand      = "LRANGE"
+	LlenCommand        = "LLEN"
+	LindexCommand      = "LINDEX"
+	LtrimCommand       = "LTRIM"
+	BlpopCommand       = "BLPOP"
+	BrpopCommand       = "BRPOP"
 )
 
 var namesToID = map[string]CommandID{
-	UnknownCommand: UnknownCommandID,
-	SetCommand:     SetCommandID,
-	GetCommand:     GetCommandID,
-	DelCommand:     DelCommandID,
+	UnknownCommand:     UnknownCommandID,
+	SetCommand:         SetCommandID,
+	GetCommand:         GetCommandID,
+	DelCommand:         DelCommandID,
+	SaveCommand:        SaveCommandID,
+	BgsaveCommand:      BgsaveCommandID,
+	ExpireCommand:      ExpireCommandID,
+	PexpireCommand:     PexpireCommandID,
+	TTLCommand:         TTLCommandID,
+	PttlCommand:        PttlCommandID,
+	PersistCommand:     PersistCommandID,
+	ScanCommand:        ScanCommandID,
+	RangeCommand:       RangeCommandID,
+	KeysCommand:        KeysCommandID,
+	PingCommand:        PingCommandID,
+	AuthCommand:        AuthCommandID,
+	ACLCommand:         ACLCommandID,
+	IncrCommand:        IncrCommandID,
+	DecrCommand:        DecrCommandID,
+	IncrbyCommand:      IncrbyCommandID,
+	DecrbyCommand:      DecrbyCommandID,
+	IncrbyfloatCommand: IncrbyfloatCommandID,
+	MultiCommand:       MultiCommandID,
+	ExecCommand:        ExecCommandID,
+	DiscardCommand:     DiscardCommandID,
+	WatchCommand:       WatchCommandID,
+	UnwatchCommand:     UnwatchCommandID,
+	SetnxCommand:       SetnxCommandID,
+	GetsetCommand:      GetsetCommandID,
+	GetdelCommand:      GetdelCommandID,
+	CasCommand:         CasCommandID,
+	MgetCommand:        MgetCommandID,
+	MsetCommand:        MsetCommandID,
+	MsetnxCommand:      MsetnxCommandID,
+	HsetCommand:        HsetCommandID,
+	HgetCommand:        HgetCommandID,
+	HmgetCommand:       HmgetCommandID,
+	HdelCommand:        HdelCommandID,
+	HgetallCommand:     HgetallCommandID,
+	HkeysCommand:       HkeysCommandID,
+	HlenCommand:        HlenCommandID,
+	HexistsCommand:     HexistsCommandID,
+	HincrbyCommand:     HincrbyCommandID,
+	LpushCommand:       LpushCommandID,
+	RpushCommand:       RpushCommandID,
+	LpopCommand:        LpopCommandID,
+	RpopCommand:        RpopCommandID,
+	LrangeCommand:      LrangeCommandID,
+	LlenCommand:        LlenCommandID,
+	LindexCommand:      LindexCommandID,
+	LtrimCommand:       LtrimCommandID,
+	BlpopCommand:       BlpopCommandID,
+	BrpopCommand:       BrpopCommandID,
 }
 
 type CommandID int
 
+// Category groups commands for access control.
+type Category string
+
+const (
+	// CategoryNone commands, such as PING, are allowed to every user.
+	CategoryNone  Category = ""
+	CategoryRead  Category = "read"
+	CategoryWrite Category = "write"
+	CategoryAdmin Category = "admin"
+)
+
+// Categories returns the categories commands are grouped into.
+func Categories() []Category {
+	return []Category{CategoryRead, CategoryWrite, CategoryAdmin}
+}
+
+// ACL subcommands.
+const (
+	ACLList    = "LIST"
+	ACLSetUser = "SETUSER"
+	ACLDelUser = "DELUSER"
+	ACLWhoAmI  = "WHOAMI"
+)
+
+// variadic is the maxArgs of commands taking any number of arguments.
+const variadic = math.MaxInt
+
 func commandNameToCommandID(name string) CommandID {
-	if id, ok := namesToID[name]; ok {
+	if id, ok := namesToID[strings.ToUpper(name)]; ok {
 		return id
 	}
 
 	return UnknownCommandID
 }
 
-var argsNumberForCommand = map[CommandID]int{
-	SetCommandID: 2,
-	GetCommandID: 1,
-	DelCommandID: 1,
+// CommandNames returns names of the supported commands.
+func CommandNames() []string {
+	names := make([]string, 0, len(namesToID))
+	for name, id := range namesToID {
+		if id != UnknownCommandID {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// commandSpec describes arguments accepted by a command.
+type commandSpec struct {
+	name    string
+	usage   string
+	minArgs int
+	maxArgs int
+	// validate checks the syntax of arguments, whose number is already in range,
+	// and returns the reason they are invalid.
+	validate func(args []string) string
+	// sensitive arguments, such as passwords, are not logged.
+	sensitive bool
+	category  Category
+	// categorize returns the category of commands whose category depends on
+	// their arguments, instead of category.
+	categorize func(args []string) Category
+	// keys returns the keys the command accesses.
+	keys func(args []string) []string
+	// prefix returns the prefix of keys the command may access.
+	prefix func(args []string) string
+	// allKeys commands, such as SCAN, may access every key.
+	allKeys bool
+}
+
+var commandSpecs = map[CommandID]commandSpec{
+	SetCommandID: {
+		name:     SetCommand,
+		usage:    "SET <key> <value> [NX | XX] [EX <seconds> | PX <milliseconds>]",
+		minArgs:  2,
+		maxArgs:  5,
+		validate: validateSet,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	GetCommandID: {
+		name:     GetCommand,
+		usage:    "GET <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	DelCommandID: {
+		name:     DelCommand,
+		usage:    "DEL <key> [key ...]",
+		minArgs:  1,
+		maxArgs:  variadic,
+		category: CategoryWrite,
+		keys:     allArgs,
+	},
+	SaveCommandID: {
+		name:     SaveCommand,
+		usage:    "SAVE",
+		category: CategoryAdmin,
+	},
+	BgsaveCommandID: {
+		name:     BgsaveCommand,
+		usage:    "BGSAVE",
+		category: CategoryAdmin,
+	},
+	ExpireCommandID: {
+		name:     ExpireCommand,
+		usage:    "EXPIRE <key> <seconds>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: expireValidator(time.Second),
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	PexpireCommandID: {
+		name:     PexpireCommand,
+		usage:    "PEXPIRE <key> <milliseconds>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: expireValidator(time.Millisecond),
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	TTLCommandID: {
+		name:     TTLCommand,
+		usage:    "TTL <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	PttlCommandID: {
+		name:     PttlCommand,
+		usage:    "PTTL <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	PersistCommandID: {
+		name:     PersistCommand,
+		usage:    "PERSIST <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	ScanCommandID: {
+		name:     ScanCommand,
+		usage:    "SCAN <cursor> [COUNT <count>]",
+		minArgs:  1,
+		maxArgs:  3,
+		validate: validateScan,
+		category: CategoryRead,
+		allKeys:  true,
+	},
+	RangeCommandID: {
+		name:     RangeCommand,
+		usage:    "RANGE <start> <end> [LIMIT <count>]",
+		minArgs:  2,
+		maxArgs:  4,
+		validate: validateRange,
+		category: CategoryRead,
+		allKeys:  true,
+	},
+	KeysCommandID: {
+		name:     KeysCommand,
+		usage:    "KEYS <prefix>*",
+		minArgs:  1,
+		maxArgs:  1,
+		validate: validateKeys,
+		category: CategoryRead,
+		prefix:   keysPrefix,
+	},
+	PingCommandID: {
+		name:    PingCommand,
+		usage:   "PING [message]",
+		maxArgs: 1,
+	},
+	AuthCommandID: {
+		name:      AuthCommand,
+		usage:     "AUTH <user> <password>",
+		minArgs:   2,
+		maxArgs:   2,
+		sensitive: true,
+	},
+	ACLCommandID: {
+		name:       ACLCommand,
+		usage:      "ACL LIST | ACL WHOAMI | ACL SETUSER <user> [rule ...] | ACL DELUSER <user> [user ...]",
+		minArgs:    1,
+		maxArgs:    variadic,
+		validate:   validateACL,
+		sensitive:  true,
+		categorize: categorizeACL,
+	},
+	IncrCommandID: {
+		name:     IncrCommand,
+		usage:    "INCR <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	DecrCommandID: {
+		name:     DecrCommand,
+		usage:    "DECR <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	IncrbyCommandID: {
+		name:     IncrbyCommand,
+		usage:    "INCRBY <key> <increment>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: validateIncrement,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	DecrbyCommandID: {
+		name:     DecrbyCommand,
+		usage:    "DECRBY <key> <decrement>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: validateIncrement,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	IncrbyfloatCommandID: {
+		name:     IncrbyfloatCommand,
+		usage:    "INCRBYFLOAT <key> <increment>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: validateFloatIncrement,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	// commands queued by MULTI are authorized one by one
+	MultiCommandID: {
+		name:  MultiCommand,
+		usage: "MULTI",
+	},
+	ExecCommandID: {
+		name:  ExecCommand,
+		usage: "EXEC",
+	},
+	DiscardCommandID: {
+		name:  DiscardCommand,
+		usage: "DISCARD",
+	},
+	WatchCommandID: {
+		name:     WatchCommand,
+		usage:    "WATCH <key> [key ...]",
+		minArgs:  1,
+		maxArgs:  variadic,
+		category: CategoryRead,
+		keys:     allArgs,
+	},
+	UnwatchCommandID: {
+		name:  UnwatchCommand,
+		usage: "UNWATCH",
+	},
+	SetnxCommandID: {
+		name:     SetnxCommand,
+		usage:    "SETNX <key> <value>",
+		minArgs:  2,
+		maxArgs:  2,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	GetsetCommandID: {
+		name:     GetsetCommand,
+		usage:    "GETSET <key> <value>",
+		minArgs:  2,
+		maxArgs:  2,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	GetdelCommandID: {
+		name:     GetdelCommand,
+		usage:    "GETDEL <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	CasCommandID: {
+		name:     CasCommand,
+		usage:    "CAS <key> <expected> <value> [EX <seconds> | PX <milliseconds>]",
+		minArgs:  3,
+		maxArgs:  5,
+		validate: validateCAS,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	MgetCommandID: {
+		name:     MgetCommand,
+		usage:    "MGET <key> [key ...]",
+		minArgs:  1,
+		maxArgs:  variadic,
+		category: CategoryRead,
+		keys:     allArgs,
+	},
+	MsetCommandID: {
+		name:     MsetCommand,
+		usage:    "MSET <key> <value> [key value ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		validate: validatePairs,
+		category: CategoryWrite,
+		keys:     pairKeys,
+	},
+	MsetnxCommandID: {
+		name:     MsetnxCommand,
+		usage:    "MSETNX <key> <value> [key value ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		validate: validatePairs,
+		category: CategoryWrite,
+		keys:     pairKeys,
+	},
+	HsetCommandID: {
+		name:     HsetCommand,
+		usage:    "HSET <key> <field> <value> [field value ...]",
+		minArgs:  3,
+		maxArgs:  variadic,
+		validate: validateFieldPairs,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	HgetCommandID: {
+		name:     HgetCommand,
+		usage:    "HGET <key> <field>",
+		minArgs:  2,
+		maxArgs:  2,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HmgetCommandID: {
+		name:     HmgetCommand,
+		usage:    "HMGET <key> <field> [field ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HdelCommandID: {
+		name:     HdelCommand,
+		usage:    "HDEL <key> <field> [field ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	HgetallCommandID: {
+		name:     HgetallCommand,
+		usage:    "HGETALL <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HkeysCommandID: {
+		name:     HkeysCommand,
+		usage:    "HKEYS <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HlenCommandID: {
+		name:     HlenCommand,
+		usage:    "HLEN <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HexistsCommandID: {
+		name:     HexistsCommand,
+		usage:    "HEXISTS <key> <field>",
+		minArgs:  2,
+		maxArgs:  2,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	HincrbyCommandID: {
+		name:     HincrbyCommand,
+		usage:    "HINCRBY <key> <field> <increment>",
+		minArgs:  3,
+		maxArgs:  3,
+		validate: validateFieldIncrement,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	LpushCommandID: {
+		name:     LpushCommand,
+		usage:    "LPUSH <key> <value> [value ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	RpushCommandID: {
+		name:     RpushCommand,
+		usage:    "RPUSH <key> <value> [value ...]",
+		minArgs:  2,
+		maxArgs:  variadic,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	LpopCommandID: {
+		name:     LpopCommand,
+		usage:    "LPOP <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	RpopCommandID: {
+		name:     RpopCommand,
+		usage:    "RPOP <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	LrangeCommandID: {
+		name:     LrangeCommand,
+		usage:    "LRANGE <key> <start> <stop>",
+		minArgs:  3,
+		maxArgs:  3,
+		validate: validateListRange,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	LlenCommandID: {
+		name:     LlenCommand,
+		usage:    "LLEN <key>",
+		minArgs:  1,
+		maxArgs:  1,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	LindexCommandID: {
+		name:     LindexCommand,
+		usage:    "LINDEX <key> <index>",
+		minArgs:  2,
+		maxArgs:  2,
+		validate: validateListIndex,
+		category: CategoryRead,
+		keys:     firstKey,
+	},
+	LtrimCommandID: {
+		name:     LtrimCommand,
+		usage:    "LTRIM <key> <start> <stop>",
+		minArgs:  3,
+		maxArgs:  3,
+		validate: validateListRange,
+		category: CategoryWrite,
+		keys:     firstKey,
+	},
+	BlpopCommandID: {
+		name:     BlpopCommand,
+		usage:    "BLPOP <key> [key ...] <timeout>",
+		minArgs:  2,
+		maxArgs:  variadic,
+		validate: validateBlockingPop,
+		category: CategoryWrite,
+		keys:     allButLast,
+	},
+	BrpopCommandID: {
+		name:     BrpopCommand,
+		usage:    "BRPOP <key> [key ...] <timeout>",
+		minArgs:  2,
+		maxArgs:  variadic,
+		validate: validateBlockingPop,
+		category: CategoryWrite,
+		keys:     allButLast,
+	},
+}
+
+func firstKey(args []string) []string {
+	return args[:1]
+}
+
+func allArgs(args []string) []string {
+	return args
+}
+
+// allButLast returns the keys of commands ending with another argument.
+func allButLast(args []string) []string {
+	return args[:len(args)-1]
+}
+
+// pairKeys returns the keys of interleaved keys and values.
+func pairKeys(args []string) []string {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+
+	return keys
+}
+
+// loggedTokens returns the tokens of a request with sensitive arguments redacted.
+func (s commandSpec) loggedTokens(tokens []string) []string {
+	if !s.sensitive {
+		return tokens
+	}
+
+	return tokens[:1]
+}
+
+func validateACL(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case ACLList, ACLWhoAmI:
+		if len(args) != 1 {
+			return "ACL " + strings.ToUpper(args[0]) + " takes no arguments"
+		}
+	case ACLSetUser, ACLDelUser:
+		if len(args) < 2 {
+			return "user name is required"
+		}
+	default:
+		return fmt.Sprintf("unknown subcommand %q", args[0])
+	}
+
+	return ""
+}
+
+// categorizeACL lets every user see who they are, while managing users
+// is an admin command.
+func categorizeACL(args []string) Category {
+	if strings.EqualFold(args[0], ACLWhoAmI) {
+		return CategoryNone
+	}
+
+	return CategoryAdmin
+}
+
+func validateSet(args []string) string {
+	_, reason := parseSetOptions(args[2:])
+	return reason
+}
+
+func validateCAS(args []string) string {
+	options, reason := parseSetOptions(args[3:])
+	if reason == "" && (options.NX || options.XX) {
+		return "syntax error"
+	}
+
+	return reason
+}
+
+// SetOptions are the options of SET and CAS.
+type SetOptions struct {
+	// NX sets only missing keys and XX only existing ones.
+	NX, XX bool
+	// TTL is the time to live given by EX or PX, zero without them.
+	TTL time.Duration
+}
+
+// parseSetOptions parses "[NX | XX] [EX <seconds> | PX <milliseconds>]" in
+// any order and returns the reason they are invalid.
+func parseSetOptions(args []string) (SetOptions, string) {
+	var options SetOptions
+	for i := 0; i < len(args); i++ {
+		switch {
+		case oneOf(args[i], "NX") && !options.XX:
+			options.NX = true
+		case oneOf(args[i], "XX") && !options.NX:
+			options.XX = true
+		case oneOf(args[i], "EX", "PX") && options.TTL == 0 && i+1 < len(args):
+			if reason := validatePositive(args[i+1], "expire time"); reason != "" {
+				return options, reason
+			}
+
+			unit := time.Second
+			if oneOf(args[i], "PX") {
+				unit = time.Millisecond
+			}
+
+			ttl, _ := strconv.ParseInt(args[i+1], 10, 64)
+			if !fitsDuration(ttl, unit) {
+				return options, "invalid expire time"
+			}
+
+			options.TTL = time.Duration(ttl) * unit
+			i++
+		default:
+			return options, "syntax error"
+		}
+	}
+
+	return options, ""
+}
+
+// fitsDuration reports whether the number of units can be converted to a
+// time.Duration without overflowing.
+func fitsDuration(value int64, unit time.Duration) bool {
+	return value <= math.MaxInt64/int64(unit) && value >= math.MinInt64/int64(unit)
+}
+
+func validatePairs(args []string) string {
+	if len(args)%2 != 0 {
+		return "keys and values must come in pairs"
+	}
+
+	return ""
+}
+
+// validateFieldPairs checks the fields and values following the key of a hash.
+func validateFieldPairs(args []string) string {
+	if len(args)%2 != 1 {
+		return "fields and values must come in pairs"
+	}
+
+	return ""
+}
+
+func validateListIndex(args []string) string {
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		return "index is not an integer or out of range"
+	}
+
+	return ""
+}
+
+// validateListRange checks the start and stop indexes following the key of a list.
+func validateListRange(args []string) string {
+	for _, index := range args[1:] {
+		if _, err := strconv.Atoi(index); err != nil {
+			return "index is not an integer or out of range"
+		}
+	}
+
+	return ""
+}
+
+// validateBlockingPop checks the timeout in seconds ending the keys, zero
+// means waiting forever.
+func validateBlockingPop(args []string) string {
+	timeout, ok := parseFloat(args[len(args)-1])
+	switch {
+	case !ok:
+		return "timeout is not a float or out of range"
+	case timeout < 0:
+		return "timeout is negative"
+	}
+
+	return ""
+}
+
+// expireValidator returns the validator of an expire time in the unit.
+func expireValidator(unit time.Duration) func(args []string) string {
+	return func(args []string) string {
+		expireTime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "expire time is not an integer"
+		}
+
+		if !fitsDuration(expireTime, unit) {
+			return "invalid expire time"
+		}
+
+		return ""
+	}
+}
+
+func validateIncrement(args []string) string {
+	if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+		return "increment is not an integer or out of range"
+	}
+
+	return ""
+}
+
+// validateFieldIncrement checks the increment following the key and field of a hash.
+func validateFieldIncrement(args []string) string {
+	return validateIncrement(args[1:])
+}
+
+func validateFloatIncrement(args []string) string {
+	if _, ok := parseFloat(args[1]); !ok {
+		return "increment is not a valid float"
+	}
+
+	return ""
+}
+
+// parseFloat parses a finite float, NaN and infinities are not valid.
+func parseFloat(value string) (float64, bool) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+
+	return number, true
+}
+
+func validateScan(args []string) string {
+	if args[0] != "0" {
+		if key, err := hex.DecodeString(args[0]); err != nil || len(key) == 0 {
+			return "invalid cursor"
+		}
+	}
+
+	return validateOption(args[1:], "COUNT")
+}
+
+func validateRange(args []string) string {
+	return validateOption(args[2:], "LIMIT")
+}
+
+func keysPrefix(args []string) string {
+	return strings.TrimSuffix(args[0], "*")
+}
+
+func validateKeys(args []string) string {
+	prefix, ok := strings.CutSuffix(args[0], "*")
+	if !ok || strings.ContainsAny(prefix, "*?[") {
+		return "only prefix patterns like prefix* are supported"
+	}
+
+	return ""
 }
 
-func commandArgumentsNumber(commandID CommandID) int {
-	return argsNumberForCommand[commandID]
+// validateOption checks an optional trailing "<option> <positive integer>" pair.
+func validateOption(args []string, option string) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	if len(args) != 2 || !oneOf(args[0], option) {
+		return "syntax error"
+	}
+
+	return validatePositive(args[1], strings.ToLower(option))
+}
+
+func validatePositive(value, name string) string {
+	if number, err := strconv.ParseInt(value, 10, 64); err != nil || number <= 0 {
+		return fmt.Sprintf("%s must be a positive integer", name)
+	}
+
+	return ""
+}
+
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if strings.EqualFold(value, option) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -2,18 +2,54 @@ package compute
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 
 	"go.uber.org/zap"
 )
 
 var (
-	errInvalidLogger    = errors.New("invalid logger")
-	errEmptyRequest     = errors.New("empty request")
-	errInvalidCommand   = errors.New("invalid command")
-	errInvalidArguments = errors.New("invalid arguments")
+	ErrEmptyRequest         = errors.New("empty request")
+	ErrUnknownCommand       = errors.New("unknown command")
+	ErrWrongArgumentsNumber = errors.New("wrong number of arguments")
+	ErrInvalidArgument      = errors.New("invalid argument")
+
+	errInvalidLogger = errors.New("invalid logger")
 )
 
+// QueryError describes why a request is not a valid query,
+// Err is one of the ErrXXX errors of the package.
+type QueryError struct {
+	Err     error
+	Command string
+	Reason  string
+	Usage   string
+}
+
+func (e *QueryError) Error() string {
+	var message strings.Builder
+	message.WriteString(e.Err.Error())
+
+	if e.Command != "" {
+		fmt.Fprintf(&message, " %q", e.Command)
+	}
+
+	if e.Reason != "" {
+		message.WriteString(": " + e.Reason)
+	}
+
+	if e.Usage != "" {
+		message.WriteString(", usage: " + e.Usage)
+	}
+
+	return message.String()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
 type Compute struct {
 	logger *zap.Logger
 }
@@ -28,27 +64,57 @@ func New(logger *zap.Logger) (*Compute, error) {
 	}, nil
 }
 
+// Parse turns a request into a query with the number and the syntax of its
+// arguments validated, otherwise it returns a *SyntaxError if the request
+// cannot be tokenized or a *QueryError.
 func (c *Compute) Parse(request string) (Query, error) {
-	tokens := strings.Fields(request)
+	tokens, err := tokenize(request)
+	if err != nil {
+		c.logger.Debug("invalid request syntax", zap.Error(err))
+		return Query{}, err
+	}
+
+	return c.ParseTokens(tokens)
+}
+
+// ParseTokens turns a request already split into tokens, such as a RESP
+// array, into a query the same way Parse does.
+func (c *Compute) ParseTokens(tokens []string) (Query, error) {
 	if len(tokens) == 0 {
-		c.logger.Error("empty request")
-		return Query{}, errEmptyRequest
+		c.logger.Debug("empty request")
+		return Query{}, &QueryError{Err: ErrEmptyRequest}
 	}
 
 	command := tokens[0]
 
 	commandID := commandNameToCommandID(command)
 	if commandID == UnknownCommandID {
-		c.logger.Debug("invalid command", zap.String("query", request))
-		return Query{}, errInvalidCommand
+		c.logger.Debug("invalid command", zap.Strings("query", tokens))
+
+		names := CommandNames()
+		slices.Sort(names)
+
+		return Query{}, &QueryError{
+			Err:     ErrUnknownCommand,
+			Command: command,
+			Reason:  "available commands: " + strings.Join(names, ", "),
+		}
 	}
 
 	query := NewQuery(commandID, tokens[1:])
+	spec := commandSpecs[commandID]
+
+	argumentsNumber := len(query.Arguments())
+	if argumentsNumber < spec.minArgs || argumentsNumber > spec.maxArgs {
+		c.logger.Debug("invalid arguments for query", zap.Strings("query", spec.loggedTokens(tokens)))
+		return Query{}, &QueryError{Err: ErrWrongArgumentsNumber, Command: spec.name, Usage: spec.usage}
+	}
 
-	argumentsNumber := commandArgumentsNumber(commandID)
-	if len(query.Arguments()) != argumentsNumber {
-		c.logger.Debug("invalid arguments for query", zap.String("query", request))
-		return Query{}, errInvalidArguments
+	if spec.validate != nil {
+		if reason := spec.validate(query.Arguments()); reason != "" {
+			c.logger.Debug("invalid arguments for query", zap.Strings("query", spec.loggedTokens(tokens)))
+			return Query{}, &QueryError{Err: ErrInvalidArgument, Command: spec.name, Reason: reason, Usage: spec.usage}
+		}
 	}
 
 	return query, nil
@@ -1,6 +1,7 @@
 package compute
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,7 +21,7 @@ func TestParse(t *testing.T) {
 			t.Errorf("expected error, got nil")
 		}
 
-		assert.Equal(t, errEmptyRequest, err)
+		assert.ErrorIs(t, err, ErrEmptyRequest)
 	})
 
 	t.Run("invalid command", func(t *testing.T) {
@@ -29,7 +30,7 @@ func TestParse(t *testing.T) {
 			t.Errorf("expected error, got nil")
 		}
 
-		assert.Equal(t, errInvalidCommand, err)
+		assert.ErrorIs(t, err, ErrUnknownCommand)
 	})
 
 	t.Run("invalid arguments", func(t *testing.T) {
@@ -38,7 +39,8 @@ func TestParse(t *testing.T) {
 			t.Errorf("expected error, got nil")
 		}
 
-		assert.Equal(t, errInvalidArguments, err)
+		assert.ErrorIs(t, err, ErrWrongArgumentsNumber)
+		assert.EqualError(t, err, `wrong number of arguments "GET", usage: GET <key>`)
 	})
 
 	t.Run("valid request", func(t *testing.T) {
@@ -50,4 +52,105 @@ func TestParse(t *testing.T) {
 			args:      []string{"key"},
 		}, query)
 	})
+
+	t.Run("case insensitive command", func(t *testing.T) {
+		query, err := c.Parse("set key value px 100")
+		require.NoError(t, err)
+
+		assert.Equal(t, SetCommandID, query.CommandID())
+		assert.Equal(t, int64(100), query.IntArgument(3))
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		query, err := c.ParseTokens([]string{"set", "key", "value with spaces"})
+		require.NoError(t, err)
+
+		assert.Equal(t, NewQuery(SetCommandID, []string{"key", "value with spaces"}), query)
+
+		_, err = c.ParseTokens(nil)
+		assert.ErrorIs(t, err, ErrEmptyRequest)
+	})
+}
+
+func TestParseArguments(t *testing.T) {
+	c, err := New(zap.NewNop())
+	require.NoError(t, err)
+
+	tests := map[string]error{
+		"SET key value":                        nil,
+		"SET key value extra":                  ErrInvalidArgument,
+		"SET key value EX":                     ErrInvalidArgument,
+		"SET key value EX 0":                   ErrInvalidArgument,
+		"SET key value KEEP 10":                ErrInvalidArgument,
+		"SET key value EX 10 extra":            ErrInvalidArgument,
+		"SET key value NX EX 10 x":             ErrWrongArgumentsNumber,
+		"SET key value PX 10 NX":               nil,
+		"SET key value XX":                     nil,
+		"SET key value NX XX":                  ErrInvalidArgument,
+		"SET key value EX 1 PX":                ErrInvalidArgument,
+		"SET key value EX 9223372036854775807": ErrInvalidArgument,
+		"SET key value PX 9223372036854":       nil,
+		"CAS key old new PX 9223372036855":     ErrInvalidArgument,
+		"CAS key old new EX 9223372037":        ErrInvalidArgument,
+		"CAS key old new":                      nil,
+		"CAS key old new EX 10":                nil,
+		"CAS key old new NX":                   ErrInvalidArgument,
+		"CAS key old":                          ErrWrongArgumentsNumber,
+		"EXPIRE key -1":                        nil,
+		"EXPIRE key soon":                      ErrInvalidArgument,
+		"SAVE now":                             ErrWrongArgumentsNumber,
+		"SCAN 0":                               nil,
+		"SCAN 6b6579 COUNT 5":                  nil,
+		"SCAN key":                             ErrInvalidArgument,
+		"SCAN 0 COUNT":                         ErrInvalidArgument,
+		"RANGE a b LIMIT 10":                   nil,
+		"RANGE a b LIMIT -1":                   ErrInvalidArgument,
+		"KEYS event:*":                         nil,
+		"KEYS event:*:a":                       ErrInvalidArgument,
+		"RANGE a b c d e":                      ErrWrongArgumentsNumber,
+		"UNKNOWN":                              ErrUnknownCommand,
+		"   ":                                  ErrEmptyRequest,
+		"DEL key":                              nil,
+		"DEL":                                  ErrWrongArgumentsNumber,
+		"DEL key another":                      nil,
+		"MGET a b c":                           nil,
+		"MGET":                                 ErrWrongArgumentsNumber,
+		"MSET a 1 b 2":                         nil,
+		"MSET a 1 b":                           ErrInvalidArgument,
+		"MSETNX a":                             ErrWrongArgumentsNumber,
+		"HSET user name ann age 30":            nil,
+		"HSET user name ann age":               ErrInvalidArgument,
+		"HGET user":                            ErrWrongArgumentsNumber,
+		"HDEL user name age":                   nil,
+		"HINCRBY user age 1":                   nil,
+		"HINCRBY user age one":                 ErrInvalidArgument,
+		"RPUSH jobs a b c":                     nil,
+		"LPUSH jobs":                           ErrWrongArgumentsNumber,
+		"LRANGE jobs 0 -1":                     nil,
+		"LRANGE jobs 0 last":                   ErrInvalidArgument,
+		"LINDEX jobs -1":                       nil,
+		"LTRIM jobs 1":                         ErrWrongArgumentsNumber,
+		"BLPOP jobs other 0.5":                 nil,
+		"BRPOP jobs -1":                        ErrInvalidArgument,
+		"BLPOP 5":                              ErrWrongArgumentsNumber,
+		"TTL key":                              nil,
+		"PERSIST key another":                  ErrWrongArgumentsNumber,
+		"PEXPIRE key 100":                      nil,
+		"BGSAVE":                               nil,
+	}
+
+	for request, expected := range tests {
+		t.Run(request, func(t *testing.T) {
+			_, err := c.Parse(request)
+
+			if expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var queryErr *QueryError
+			require.True(t, errors.As(err, &queryErr))
+			assert.ErrorIs(t, err, expected)
+		})
+	}
 }
@@ -1,5 +1,7 @@
 package compute
 
+import "strconv"
+
 type Query struct {
 	commandID CommandID
 	args      []string
@@ -19,3 +21,61 @@ func (q Query) CommandID() CommandID {
 func (q Query) Arguments() []string {
 	return q.args
 }
+
+// IntArgument returns the integer argument at the index, its syntax is
+// validated by Compute.Parse.
+func (q Query) IntArgument(index int) int64 {
+	value, _ := strconv.ParseInt(q.args[index], 10, 64)
+	return value
+}
+
+// FloatArgument returns the float argument at the index, its syntax is
+// validated by Compute.Parse.
+func (q Query) FloatArgument(index int) float64 {
+	value, _ := parseFloat(q.args[index])
+	return value
+}
+
+// SetOptions returns the options of SET and CAS starting at the index, their
+// syntax is validated by Compute.Parse.
+func (q Query) SetOptions(index int) SetOptions {
+	options, _ := parseSetOptions(q.args[index:])
+	return options
+}
+
+// Name returns the name of the command.
+func (q Query) Name() string {
+	return commandSpecs[q.commandID].name
+}
+
+// Category returns the access control category of the command.
+func (q Query) Category() Category {
+	spec := commandSpecs[q.commandID]
+	if spec.categorize != nil {
+		return spec.categorize(q.args)
+	}
+
+	return spec.category
+}
+
+// Keys returns the keys the command accesses, all is set for commands that
+// may access every key.
+func (q Query) Keys() (keys []string, all bool) {
+	spec := commandSpecs[q.commandID]
+	if spec.keys != nil {
+		keys = spec.keys(q.args)
+	}
+
+	return keys, spec.allKeys
+}
+
+// KeyPrefix returns the prefix of keys the command may access, ok is set only
+// for commands accessing keys by a prefix.
+func (q Query) KeyPrefix() (prefix string, ok bool) {
+	spec := commandSpecs[q.commandID]
+	if spec.prefix == nil {
+		return "", false
+	}
+
+	return spec.prefix(q.args), true
+}
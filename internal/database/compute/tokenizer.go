@@ -0,0 +1,179 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSyntax = errors.New("syntax error")
+
+// SyntaxError reports a request that cannot be split into tokens.
+type SyntaxError struct {
+	// Offset is the byte offset of the request the error was found at.
+	Offset int
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at byte %d: %s", ErrInvalidSyntax, e.Offset, e.Reason)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrInvalidSyntax
+}
+
+// tokenize splits the request into tokens separated by whitespace.
+// A token is one of:
+//   - a bare word, taken as is
+//   - a double-quoted string supporting \n, \r, \t, \0, \\, \", \' and \xHH escapes
+//   - a single-quoted string taken as is but for the \' and \\ escapes
+//   - a length-prefixed binary string $<length>:<bytes>, where bytes are
+//     exactly length arbitrary bytes, other words starting with $ are bare
+//
+// Quoted and binary strings must be followed by whitespace or the end of the request.
+func tokenize(request string) ([]string, error) {
+	var tokens []string
+
+	for offset := 0; offset < len(request); {
+		if isSpace(request[offset]) {
+			offset++
+			continue
+		}
+
+		var token string
+		var err error
+
+		switch request[offset] {
+		case '"':
+			token, offset, err = readDoubleQuoted(request, offset)
+		case '\'':
+			token, offset, err = readSingleQuoted(request, offset)
+		case '$':
+			if isBinary(request, offset) {
+				token, offset, err = readBinary(request, offset)
+				break
+			}
+
+			fallthrough
+		default:
+			start := offset
+			for offset < len(request) && !isSpace(request[offset]) {
+				offset++
+			}
+			token = request[start:offset]
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if offset < len(request) && !isSpace(request[offset]) {
+			return nil, &SyntaxError{Offset: offset, Reason: "expected whitespace after the string"}
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// readDoubleQuoted reads the string starting with a quote at the offset and
+// returns it with the offset following the closing quote.
+func readDoubleQuoted(request string, start int) (string, int, error) {
+	var token strings.Builder
+
+	for offset := start + 1; offset < len(request); offset++ {
+		switch c := request[offset]; c {
+		case '"':
+			return token.String(), offset + 1, nil
+		case '\\':
+			if offset+1 == len(request) {
+				return "", 0, &SyntaxError{Offset: start, Reason: "unterminated quoted string"}
+			}
+
+			offset++
+			switch escaped := request[offset]; escaped {
+			case 'n':
+				token.WriteByte('\n')
+			case 'r':
+				token.WriteByte('\r')
+			case 't':
+				token.WriteByte('\t')
+			case '0':
+				token.WriteByte(0)
+			case '\\', '"', '\'':
+				token.WriteByte(escaped)
+			case 'x':
+				if offset+2 >= len(request) {
+					return "", 0, &SyntaxError{Offset: offset - 1, Reason: `incomplete \x escape`}
+				}
+
+				value, err := strconv.ParseUint(request[offset+1:offset+3], 16, 8)
+				if err != nil {
+					return "", 0, &SyntaxError{Offset: offset - 1, Reason: `invalid \x escape`}
+				}
+
+				token.WriteByte(byte(value))
+				offset += 2
+			default:
+				return "", 0, &SyntaxError{Offset: offset - 1, Reason: fmt.Sprintf("unknown escape sequence \\%c", escaped)}
+			}
+		default:
+			token.WriteByte(c)
+		}
+	}
+
+	return "", 0, &SyntaxError{Offset: start, Reason: "unterminated quoted string"}
+}
+
+func readSingleQuoted(request string, start int) (string, int, error) {
+	var token strings.Builder
+
+	for offset := start + 1; offset < len(request); offset++ {
+		c := request[offset]
+
+		switch {
+		case c == '\'':
+			return token.String(), offset + 1, nil
+		case c == '\\' && offset+1 < len(request) && (request[offset+1] == '\'' || request[offset+1] == '\\'):
+			offset++
+			token.WriteByte(request[offset])
+		default:
+			token.WriteByte(c)
+		}
+	}
+
+	return "", 0, &SyntaxError{Offset: start, Reason: "unterminated quoted string"}
+}
+
+// isBinary reports whether the token at the offset starts with $<digits>:.
+func isBinary(request string, start int) bool {
+	offset := start + 1
+	for offset < len(request) && request[offset] >= '0' && request[offset] <= '9' {
+		offset++
+	}
+
+	return offset > start+1 && offset < len(request) && request[offset] == ':'
+}
+
+// readBinary reads a $<length>:<bytes> string starting at the offset.
+func readBinary(request string, start int) (string, int, error) {
+	lengthEnd := start + strings.IndexByte(request[start:], ':')
+	length, err := strconv.Atoi(request[start+1 : lengthEnd])
+	if err != nil {
+		return "", 0, &SyntaxError{Offset: start + 1, Reason: "invalid binary string length"}
+	}
+
+	dataStart := lengthEnd + 1
+	if len(request)-dataStart < length {
+		return "", 0, &SyntaxError{Offset: start, Reason: fmt.Sprintf("binary string is shorter than %d bytes", length)}
+	}
+
+	return request[dataStart : dataStart+length], dataStart + length, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,89 @@
+package compute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := map[string]struct {
+		request  string
+		expected []string
+	}{
+		"bare words": {
+			request:  " SET\tkey  value\r\n",
+			expected: []string{"SET", "key", "value"},
+		},
+		"double quoted": {
+			request:  `SET key "{\"name\": \"kvdb\"}"`,
+			expected: []string{"SET", "key", `{"name": "kvdb"}`},
+		},
+		"escape sequences": {
+			request:  `"line\nbreak\t\x00\xff\\"`,
+			expected: []string{"line\nbreak\t\x00\xff\\"},
+		},
+		"single quoted": {
+			request:  `'it\'s \n raw'`,
+			expected: []string{`it's \n raw`},
+		},
+		"empty strings": {
+			request:  `"" ''`,
+			expected: []string{"", ""},
+		},
+		"binary": {
+			request:  "SET key $11:hello\x00\n \"x\" next",
+			expected: []string{"SET", "key", "hello\x00\n \"x\"", "next"},
+		},
+		"empty binary": {
+			request:  "$0:",
+			expected: []string{""},
+		},
+		"dollar words": {
+			request:  "SET price $5 $set $x:y",
+			expected: []string{"SET", "price", "$5", "$set", "$x:y"},
+		},
+		"quote inside a bare word": {
+			request:  `it's`,
+			expected: []string{`it's`},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tokens, err := tokenize(test.request)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expected, tokens)
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := map[string]struct {
+		request string
+		offset  int
+	}{
+		"unterminated double quote": {request: `SET key "value`, offset: 8},
+		"unterminated single quote": {request: `SET 'key`, offset: 4},
+		"unknown escape":            {request: `"a\qb"`, offset: 2},
+		"invalid hex escape":        {request: `"\xZZ"`, offset: 1},
+		"incomplete hex escape":     {request: `"\x4`, offset: 1},
+		"text after quote":          {request: `"key"value`, offset: 5},
+		"short binary":              {request: `SET $10:abc`, offset: 4},
+		"binary length overflow":    {request: `$99999999999999999999:abc`, offset: 1},
+		"text after binary":         {request: `$3:abcd`, offset: 6},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := tokenize(test.request)
+
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.ErrorIs(t, err, ErrInvalidSyntax)
+			assert.Equal(t, test.offset, syntaxErr.Offset)
+		})
+	}
+}
@@ -2,35 +2,98 @@ package database
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"sort"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
 	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
 	"go.uber.org/zap"
 )
 
 type Compute interface {
 	Parse(request string) (compute.Query, error)
+	ParseTokens(tokens []string) (compute.Query, error)
 }
 
 type Storage interface {
-	Set(context.Context, string, string)
-	Del(context.Context, string)
+	Set(context.Context, string, string) error
+	SetWithExpiration(context.Context, string, string, time.Time) error
+	Del(context.Context, ...string) (int, error)
+	GetMany(ctx context.Context, keys []string) ([]string, []bool)
+	SetMany(ctx context.Context, pairs []storage.KeyValue, condition storage.Condition) (bool, error)
+	HGet(ctx context.Context, key string, fields []string) ([]string, []bool, error)
+	HGetAll(context.Context, string) ([]storage.KeyValue, error)
+	HLen(context.Context, string) (int, error)
+	HSet(ctx context.Context, key string, pairs []storage.KeyValue) (int, error)
+	HUpdate(ctx context.Context, key, field string, fn storage.UpdateFunc) error
+	HDel(ctx context.Context, key string, fields []string) (int, error)
+	LPush(ctx context.Context, key string, items []string) (int, error)
+	RPush(ctx context.Context, key string, items []string) (int, error)
+	LPop(context.Context, string) (string, bool, error)
+	RPop(context.Context, string) (string, bool, error)
+	LLen(context.Context, string) (int, error)
+	LIndex(ctx context.Context, key string, index int) (string, bool, error)
+	LRange(ctx context.Context, key string, start, stop int) ([]string, error)
+	LTrim(ctx context.Context, key string, start, stop int) error
+	WaitPush(keys []string) (<-chan struct{}, func())
 	Get(context.Context, string) (string, error)
+	Expire(context.Context, string, time.Time) (bool, error)
+	Persist(context.Context, string) (bool, error)
+	Update(context.Context, string, storage.UpdateFunc) error
+	Version(context.Context, string) (uint64, error)
+	Atomically(context.Context, func(context.Context) error) error
+	CompareAndSet(ctx context.Context, key, value string, expiresAt time.Time, condition storage.Condition) (storage.SetResult, error)
+	GetDel(context.Context, string) (string, error)
+	TTL(context.Context, string) (time.Duration, bool, error)
+	Range(ctx context.Context, start, end string, limit int) ([]storage.KeyValue, error)
+	Snapshot(context.Context) error
+	BackgroundSnapshot() error
+	SnapshotsEnabled() bool
+	Close() error
 }
 
-type CommandHandler func(context.Context, []string) string
+// Authenticator checks credentials and permissions of users and manages them.
+type Authenticator interface {
+	Authenticate(client, user, password string) error
+	Authorize(user string, query compute.Query) error
+	SetUser(user string, rules []string) error
+	DelUser(users ...string) int
+	List() []string
+}
+
+const (
+	// scanDefaultCount is the number of keys returned by SCAN without COUNT.
+	scanDefaultCount = 10
+	// emptyReply is returned by commands replying with an empty list.
+	emptyReply = "(empty array)"
+)
+
+type CommandHandler func(context.Context, compute.Query) Reply
 
 type Database struct {
-	compute  Compute
-	storage  Storage
-	logger   *zap.Logger
-	commands map[string]CommandHandler
+	compute       Compute
+	storage       Storage
+	authenticator Authenticator
+	logger        *zap.Logger
+	handlers      map[compute.CommandID]CommandHandler
 }
 
-func New(compute Compute, storage Storage, logger *zap.Logger) (*Database, error) {
+var (
+	errNoSession     = errors.New("AUTH requires a client connection")
+	errNotInteger    = errors.New("value is not an integer or out of range")
+	errNotFloat      = errors.New("value is not a valid float")
+	errOverflow      = errors.New("increment or decrement would overflow")
+	errNotSet        = errors.New("not set, the condition does not hold")
+	errInvalidCursor = errors.New("invalid cursor")
+)
+
+func New(compute Compute, storage Storage, logger *zap.Logger, options ...Option) (*Database, error) {
 	if compute == nil {
 		return nil, errors.New("invalid compute")
 	}
@@ -40,76 +103,974 @@ func New(compute Compute, storage Storage, logger *zap.Logger) (*Database, error
 	}
 
 	db := &Database{
-		compute:  compute,
-		storage:  storage,
-		logger:   logger,
-		commands: make(map[string]CommandHandler),
+		compute: compute,
+		storage: storage,
+		logger:  logger,
 	}
 
-	// Register commands
-	db.commands["GET"] = db.handleGetRequest
-	db.commands["SET"] = db.handleSetRequest
-	db.commands["DEL"] = db.handleDelRequest
+	for _, opt := range options {
+		opt(db)
+	}
+
+	db.handlers = db.commandHandlers()
 
 	return db, nil
 }
 
+func (d *Database) commandHandlers() map[compute.CommandID]CommandHandler {
+	return map[compute.CommandID]CommandHandler{
+		compute.GetCommandID:         d.handleGetRequest,
+		compute.SetCommandID:         d.handleSetRequest,
+		compute.DelCommandID:         d.handleDelRequest,
+		compute.ExpireCommandID:      d.handleExpireRequest,
+		compute.PexpireCommandID:     d.handlePexpireRequest,
+		compute.TTLCommandID:         d.handleTTLRequest,
+		compute.PttlCommandID:        d.handlePttlRequest,
+		compute.PersistCommandID:     d.handlePersistRequest,
+		compute.SaveCommandID:        d.handleSaveRequest,
+		compute.BgsaveCommandID:      d.handleBgsaveRequest,
+		compute.ScanCommandID:        d.handleScanRequest,
+		compute.RangeCommandID:       d.handleRangeRequest,
+		compute.KeysCommandID:        d.handleKeysRequest,
+		compute.PingCommandID:        d.handlePingRequest,
+		compute.AuthCommandID:        d.handleAuthRequest,
+		compute.ACLCommandID:         d.handleACLRequest,
+		compute.IncrCommandID:        d.handleIncrRequest,
+		compute.DecrCommandID:        d.handleDecrRequest,
+		compute.IncrbyCommandID:      d.handleIncrbyRequest,
+		compute.DecrbyCommandID:      d.handleDecrbyRequest,
+		compute.IncrbyfloatCommandID: d.handleIncrbyfloatRequest,
+		compute.MultiCommandID:       d.handleMultiRequest,
+		compute.ExecCommandID:        d.handleExecRequest,
+		compute.DiscardCommandID:     d.handleDiscardRequest,
+		compute.WatchCommandID:       d.handleWatchRequest,
+		compute.UnwatchCommandID:     d.handleUnwatchRequest,
+		compute.SetnxCommandID:       d.handleSetnxRequest,
+		compute.GetsetCommandID:      d.handleGetsetRequest,
+		compute.GetdelCommandID:      d.handleGetdelRequest,
+		compute.CasCommandID:         d.handleCasRequest,
+		compute.MgetCommandID:        d.handleMgetRequest,
+		compute.MsetCommandID:        d.handleMsetRequest,
+		compute.MsetnxCommandID:      d.handleMsetnxRequest,
+		compute.HsetCommandID:        d.handleHsetRequest,
+		compute.HgetCommandID:        d.handleHgetRequest,
+		compute.HmgetCommandID:       d.handleHmgetRequest,
+		compute.HdelCommandID:        d.handleHdelRequest,
+		compute.HgetallCommandID:     d.handleHgetallRequest,
+		compute.HkeysCommandID:       d.handleHkeysRequest,
+		compute.HlenCommandID:        d.handleHlenRequest,
+		compute.HexistsCommandID:     d.handleHexistsRequest,
+		compute.HincrbyCommandID:     d.handleHincrbyRequest,
+		compute.LpushCommandID:       d.handleLpushRequest,
+		compute.RpushCommandID:       d.handleRpushRequest,
+		compute.LpopCommandID:        d.handleLpopRequest,
+		compute.RpopCommandID:        d.handleRpopRequest,
+		compute.LrangeCommandID:      d.handleLrangeRequest,
+		compute.LlenCommandID:        d.handleLlenRequest,
+		compute.LindexCommandID:      d.handleLindexRequest,
+		compute.LtrimCommandID:       d.handleLtrimRequest,
+		compute.BlpopCommandID:       d.handleBlpopRequest,
+		compute.BrpopCommandID:       d.handleBrpopRequest,
+	}
+}
+
+// HandleRequest parses the request into a query, whose arguments are
+// validated by compute, and executes it, replying in the text format.
+// The context carries the auth.Session of the client connection.
 func (d *Database) HandleRequest(ctx context.Context, request string) string {
-	parts := strings.Fields(request)
-	if len(parts) == 0 {
-		return "Empty command"
+	return d.Execute(ctx, request).Text()
+}
+
+// Execute parses the request into a query and executes it.
+func (d *Database) Execute(ctx context.Context, request string) Reply {
+	query, err := d.compute.Parse(request)
+	if err != nil {
+		return d.reject(ctx, err)
+	}
+
+	return d.execute(ctx, query)
+}
+
+// HandleCommand executes a request already split into tokens.
+func (d *Database) HandleCommand(ctx context.Context, tokens []string) Reply {
+	query, err := d.compute.ParseTokens(tokens)
+	if err != nil {
+		return d.reject(ctx, err)
+	}
+
+	return d.execute(ctx, query)
+}
+
+func (d *Database) execute(ctx context.Context, query compute.Query) Reply {
+	handler, ok := d.handlers[query.CommandID()]
+	if !ok {
+		d.logger.Error("no handler for command", zap.Int("command_id", int(query.CommandID())))
+		return errorReply(compute.ErrUnknownCommand)
+	}
+
+	if err := d.authorize(ctx, query); err != nil {
+		return d.reject(ctx, err)
+	}
+
+	// after MULTI commands are queued until EXEC
+	if transaction, ok := transactionFromContext(ctx); ok && transaction.queue(query) {
+		return statusReply("QUEUED")
+	}
+
+	return handler(ctx, query)
+}
+
+// reject replies with the error of a request that could not be executed,
+// which discards the transaction of the connection if one was started.
+func (d *Database) reject(ctx context.Context, err error) Reply {
+	if transaction, ok := transactionFromContext(ctx); ok {
+		transaction.fail()
+	}
+
+	return errorReply(err)
+}
+
+// authorize checks that the session of the connection authenticated as
+// a user allowed to run the query, every command but AUTH is checked.
+func (d *Database) authorize(ctx context.Context, query compute.Query) error {
+	if d.authenticator == nil || query.CommandID() == compute.AuthCommandID {
+		return nil
+	}
+
+	session, ok := auth.FromContext(ctx)
+	if !ok || !session.Authenticated() {
+		return auth.ErrAuthRequired
+	}
+
+	if err := d.authenticator.Authorize(session.User(), query); err != nil {
+		d.logger.Debug("command denied", zap.String("user", session.User()), zap.String("command", query.Name()), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func formatError(err error) string {
+	return fmt.Sprintf("[error] %s", err.Error())
+}
+
+// Snapshot synchronously saves a point-in-time snapshot of the storage.
+func (d *Database) Snapshot(ctx context.Context) error {
+	return d.storage.Snapshot(ctx)
+}
+
+// SnapshotsEnabled reports whether Snapshot can save snapshots.
+func (d *Database) SnapshotsEnabled() bool {
+	return d.storage.SnapshotsEnabled()
+}
+
+// Close releases the storage, flushing any pending durability data.
+func (d *Database) Close() error {
+	return d.storage.Close()
+}
+
+// handleSetRequest replies with OK once the value is stored, or with nil when
+// the NX or XX condition does not hold.
+func (d *Database) handleSetRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+	key, value := args[0], args[1]
+	options := query.SetOptions(2)
+
+	var expiresAt time.Time
+	if options.TTL > 0 {
+		expiresAt = time.Now().Add(options.TTL)
+	}
+
+	var condition storage.Condition
+	switch {
+	case options.NX:
+		condition.Kind = storage.ConditionMissing
+	case options.XX:
+		condition.Kind = storage.ConditionExists
+	case expiresAt.IsZero():
+		if err := d.storage.Set(ctx, key, value); err != nil {
+			return errorReply(err)
+		}
+		return okReply
+	default:
+		if err := d.storage.SetWithExpiration(ctx, key, value, expiresAt); err != nil {
+			return errorReply(err)
+		}
+		return okReply
+	}
+
+	result, err := d.storage.CompareAndSet(ctx, key, value, expiresAt, condition)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if !result.Stored {
+		return notSetReply()
+	}
+
+	return okReply
+}
+
+// handleSetnxRequest replies with 1 if the missing key was set and 0 otherwise.
+func (d *Database) handleSetnxRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	result, err := d.storage.CompareAndSet(ctx, args[0], args[1], time.Time{}, storage.Condition{Kind: storage.ConditionMissing})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(result.Stored)
+}
+
+// handleGetsetRequest sets the key and replies with its previous value.
+func (d *Database) handleGetsetRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	result, err := d.storage.CompareAndSet(ctx, args[0], args[1], time.Time{}, storage.Condition{})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if !result.Found {
+		return nilReply()
+	}
+
+	return bulkReply(result.Previous)
+}
+
+func (d *Database) handleGetdelRequest(ctx context.Context, query compute.Query) Reply {
+	value, err := d.storage.GetDel(ctx, query.Arguments()[0])
+	if errors.Is(err, storage.ErrNotFound) {
+		return nilReply()
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return bulkReply(value)
+}
+
+// handleCasRequest sets the key if its value is the expected one and replies
+// with 1 if it was set and 0 otherwise.
+func (d *Database) handleCasRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+	options := query.SetOptions(3)
+
+	var expiresAt time.Time
+	if options.TTL > 0 {
+		expiresAt = time.Now().Add(options.TTL)
+	}
+
+	condition := storage.Condition{Kind: storage.ConditionEqual, Value: args[1]}
+
+	result, err := d.storage.CompareAndSet(ctx, args[0], args[2], expiresAt, condition)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(result.Stored)
+}
+
+func (d *Database) handleGetRequest(ctx context.Context, query compute.Query) Reply {
+	value, err := d.storage.Get(ctx, query.Arguments()[0])
+	if errors.Is(err, storage.ErrNotFound) {
+		return nilReply()
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return bulkReply(value)
+}
+
+// handleDelRequest replies with the number of deleted keys.
+func (d *Database) handleDelRequest(ctx context.Context, query compute.Query) Reply {
+	deleted, err := d.storage.Del(ctx, query.Arguments()...)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(deleted))
+}
+
+// handleMgetRequest replies with the values of the keys, nil for missing ones.
+func (d *Database) handleMgetRequest(ctx context.Context, query compute.Query) Reply {
+	values, found := d.storage.GetMany(ctx, query.Arguments())
+
+	elements := make([]Reply, 0, len(values))
+	for i, value := range values {
+		if !found[i] {
+			elements = append(elements, nilReply())
+			continue
+		}
+
+		elements = append(elements, bulkReply(value))
+	}
+
+	return arrayReply(elements...)
+}
+
+func (d *Database) handleMsetRequest(ctx context.Context, query compute.Query) Reply {
+	if _, err := d.storage.SetMany(ctx, keyValues(query.Arguments()), storage.Condition{}); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+// handleMsetnxRequest replies with 1 if none of the keys existed and all of
+// them were set, and 0 otherwise.
+func (d *Database) handleMsetnxRequest(ctx context.Context, query compute.Query) Reply {
+	condition := storage.Condition{Kind: storage.ConditionMissing}
+
+	stored, err := d.storage.SetMany(ctx, keyValues(query.Arguments()), condition)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(stored)
+}
+
+// handleHsetRequest replies with the number of fields added to the hash.
+func (d *Database) handleHsetRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	added, err := d.storage.HSet(ctx, args[0], keyValues(args[1:]))
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(added))
+}
+
+func (d *Database) handleHgetRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	values, found, err := d.storage.HGet(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if !found[0] {
+		return nilReply()
+	}
+
+	return bulkReply(values[0])
+}
+
+// handleHmgetRequest replies with the values of the fields, nil for missing ones.
+func (d *Database) handleHmgetRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	values, found, err := d.storage.HGet(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	elements := make([]Reply, 0, len(values))
+	for i, value := range values {
+		if !found[i] {
+			elements = append(elements, nilReply())
+			continue
+		}
+
+		elements = append(elements, bulkReply(value))
+	}
+
+	return arrayReply(elements...)
+}
+
+// handleHdelRequest replies with the number of fields deleted from the hash.
+func (d *Database) handleHdelRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	deleted, err := d.storage.HDel(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(deleted))
+}
+
+// handleHgetallRequest replies with the fields and values of the hash
+// ordered by field.
+func (d *Database) handleHgetallRequest(ctx context.Context, query compute.Query) Reply {
+	pairs, err := d.storage.HGetAll(ctx, query.Arguments()[0])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	elements := make([]Reply, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		elements = append(elements, bulkReply(pair.Key), bulkReply(pair.Value))
+	}
+
+	return Reply{Kind: MapReply, Elements: elements}
+}
+
+func (d *Database) handleHkeysRequest(ctx context.Context, query compute.Query) Reply {
+	pairs, err := d.storage.HGetAll(ctx, query.Arguments()[0])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	fields := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		fields = append(fields, pair.Key)
+	}
+
+	return bulkArrayReply(fields)
+}
+
+func (d *Database) handleHlenRequest(ctx context.Context, query compute.Query) Reply {
+	length, err := d.storage.HLen(ctx, query.Arguments()[0])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(length))
+}
+
+func (d *Database) handleHexistsRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	_, found, err := d.storage.HGet(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(found[0])
+}
+
+// handleHincrbyRequest adds the increment to the integer stored in the field,
+// which is created as 0 when missing, and replies with the new value.
+func (d *Database) handleHincrbyRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	var result int64
+	if err := d.storage.HUpdate(ctx, args[0], args[1], addInteger(query.IntArgument(2), &result)); err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(result)
+}
+
+// handleLpushRequest replies with the length of the list after the push.
+func (d *Database) handleLpushRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	length, err := d.storage.LPush(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
 	}
 
-	command := strings.ToUpper(parts[0])
-	handler, exists := d.commands[command]
-	if !exists {
-		validCommands := make([]string, 0, len(d.commands))
-		for cmd := range d.commands {
-			validCommands = append(validCommands, cmd)
+	return integerReply(int64(length))
+}
+
+// handleRpushRequest replies with the length of the list after the push.
+func (d *Database) handleRpushRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	length, err := d.storage.RPush(ctx, args[0], args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(length))
+}
+
+func (d *Database) handleLpopRequest(ctx context.Context, query compute.Query) Reply {
+	return itemReply(d.storage.LPop(ctx, query.Arguments()[0]))
+}
+
+func (d *Database) handleRpopRequest(ctx context.Context, query compute.Query) Reply {
+	return itemReply(d.storage.RPop(ctx, query.Arguments()[0]))
+}
+
+// handleBlpopRequest pops the first item of the first non-empty list,
+// waiting for one to be pushed if all of them are empty.
+func (d *Database) handleBlpopRequest(ctx context.Context, query compute.Query) Reply {
+	return d.blockingPop(ctx, query, d.storage.LPop)
+}
+
+// handleBrpopRequest pops the last item of the first non-empty list,
+// waiting for one to be pushed if all of them are empty.
+func (d *Database) handleBrpopRequest(ctx context.Context, query compute.Query) Reply {
+	return d.blockingPop(ctx, query, d.storage.RPop)
+}
+
+func (d *Database) handleLrangeRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	items, err := d.storage.LRange(ctx, args[0], int(query.IntArgument(1)), int(query.IntArgument(2)))
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return bulkArrayReply(items)
+}
+
+func (d *Database) handleLlenRequest(ctx context.Context, query compute.Query) Reply {
+	length, err := d.storage.LLen(ctx, query.Arguments()[0])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(int64(length))
+}
+
+func (d *Database) handleLindexRequest(ctx context.Context, query compute.Query) Reply {
+	return itemReply(d.storage.LIndex(ctx, query.Arguments()[0], int(query.IntArgument(1))))
+}
+
+func (d *Database) handleLtrimRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	if err := d.storage.LTrim(ctx, args[0], int(query.IntArgument(1)), int(query.IntArgument(2))); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+// itemReply replies with the item of a list, nil if there is none.
+func itemReply(item string, found bool, err error) Reply {
+	switch {
+	case err != nil:
+		return errorReply(err)
+	case !found:
+		return nilReply()
+	default:
+		return bulkReply(item)
+	}
+}
+
+// keyValues pairs interleaved keys and values.
+func keyValues(args []string) []storage.KeyValue {
+	pairs := make([]storage.KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, storage.KeyValue{Key: args[i], Value: args[i+1]})
+	}
+
+	return pairs
+}
+
+func (d *Database) handleExpireRequest(ctx context.Context, query compute.Query) Reply {
+	return d.expire(ctx, query, time.Second)
+}
+
+func (d *Database) handlePexpireRequest(ctx context.Context, query compute.Query) Reply {
+	return d.expire(ctx, query, time.Millisecond)
+}
+
+func (d *Database) expire(ctx context.Context, query compute.Query, unit time.Duration) Reply {
+	expiresAt := time.Now().Add(time.Duration(query.IntArgument(1)) * unit)
+
+	found, err := d.storage.Expire(ctx, query.Arguments()[0], expiresAt)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(found)
+}
+
+func (d *Database) handleTTLRequest(ctx context.Context, query compute.Query) Reply {
+	return d.ttl(ctx, query.Arguments()[0], time.Second)
+}
+
+func (d *Database) handlePttlRequest(ctx context.Context, query compute.Query) Reply {
+	return d.ttl(ctx, query.Arguments()[0], time.Millisecond)
+}
+
+// ttl replies with the remaining time to live in the given unit,
+// -2 if the key does not exist and -1 if it never expires.
+func (d *Database) ttl(ctx context.Context, key string, unit time.Duration) Reply {
+	ttl, found, err := d.storage.TTL(ctx, key)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	switch {
+	case !found:
+		return integerReply(-2)
+	case ttl < 0:
+		return integerReply(-1)
+	default:
+		return integerReply(int64(ttl.Round(unit) / unit))
+	}
+}
+
+func (d *Database) handlePersistRequest(ctx context.Context, query compute.Query) Reply {
+	persisted, err := d.storage.Persist(ctx, query.Arguments()[0])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return boolReply(persisted)
+}
+
+func (d *Database) handleIncrRequest(ctx context.Context, query compute.Query) Reply {
+	return d.incrBy(ctx, query.Arguments()[0], 1)
+}
+
+func (d *Database) handleDecrRequest(ctx context.Context, query compute.Query) Reply {
+	return d.incrBy(ctx, query.Arguments()[0], -1)
+}
+
+func (d *Database) handleIncrbyRequest(ctx context.Context, query compute.Query) Reply {
+	return d.incrBy(ctx, query.Arguments()[0], query.IntArgument(1))
+}
+
+func (d *Database) handleDecrbyRequest(ctx context.Context, query compute.Query) Reply {
+	decrement := query.IntArgument(1)
+	if decrement == math.MinInt64 {
+		return errorReply(errOverflow)
+	}
+
+	return d.incrBy(ctx, query.Arguments()[0], -decrement)
+}
+
+// incrBy adds the increment to the integer stored at the key, which is
+// created as 0 when missing, and replies with the new value. The value is
+// changed atomically by the engine.
+func (d *Database) incrBy(ctx context.Context, key string, increment int64) Reply {
+	var result int64
+	if err := d.storage.Update(ctx, key, addInteger(increment, &result)); err != nil {
+		return errorReply(err)
+	}
+
+	return integerReply(result)
+}
+
+// addInteger returns a function adding the increment to an integer value,
+// a missing one counting from 0, which stores the sum in result.
+func addInteger(increment int64, result *int64) storage.UpdateFunc {
+	return func(value string, found bool) (string, error) {
+		var current int64
+		if found {
+			var err error
+			if current, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return "", errNotInteger
+			}
+		}
+
+		if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+			return "", errOverflow
+		}
+
+		*result = current + increment
+		return strconv.FormatInt(*result, 10), nil
+	}
+}
+
+// handleIncrbyfloatRequest adds the increment to the float stored at the key,
+// which is created as 0 when missing, and replies with the new value.
+func (d *Database) handleIncrbyfloatRequest(ctx context.Context, query compute.Query) Reply {
+	increment := query.FloatArgument(1)
+
+	var result string
+	err := d.storage.Update(ctx, query.Arguments()[0], func(value string, found bool) (string, error) {
+		var current float64
+		if found {
+			var err error
+			if current, err = strconv.ParseFloat(value, 64); err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+				return "", errNotFloat
+			}
+		}
+
+		sum := current + increment
+		if math.IsInf(sum, 0) {
+			return "", errOverflow
+		}
+
+		result = strconv.FormatFloat(sum, 'f', -1, 64)
+		return result, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return bulkReply(result)
+}
+
+func (d *Database) handleMultiRequest(ctx context.Context, query compute.Query) Reply {
+	transaction, ok := transactionFromContext(ctx)
+	if !ok {
+		return errorReply(errNoConnection)
+	}
+
+	if err := transaction.begin(); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+// handleExecRequest runs the queued commands while no other command runs and
+// replies with their replies, or with a nil array if a watched key changed
+// since WATCH.
+func (d *Database) handleExecRequest(ctx context.Context, query compute.Query) Reply {
+	transaction, ok := transactionFromContext(ctx)
+	if !ok {
+		return errorReply(errNoConnection)
+	}
+
+	queued, watched, err := transaction.exec()
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var replies []Reply
+	err = d.storage.Atomically(ctx, func(ctx context.Context) error {
+		ctx = execContext(ctx)
+
+		for key, version := range watched {
+			current, err := d.storage.Version(ctx, key)
+			if err != nil {
+				return err
+			}
+
+			if current != version {
+				return errWatchedKeyChanged
+			}
+		}
+
+		// the transaction has ended, so the queries are executed rather than queued
+		replies = make([]Reply, 0, len(queued))
+		for _, query := range queued {
+			replies = append(replies, d.execute(ctx, query))
+		}
+
+		return nil
+	})
+	if errors.Is(err, errWatchedKeyChanged) {
+		return watchAbortReply()
+	}
+
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return arrayReply(replies...)
+}
+
+func (d *Database) handleDiscardRequest(ctx context.Context, query compute.Query) Reply {
+	transaction, ok := transactionFromContext(ctx)
+	if !ok {
+		return errorReply(errNoConnection)
+	}
+
+	if err := transaction.discard(); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+func (d *Database) handleWatchRequest(ctx context.Context, query compute.Query) Reply {
+	transaction, ok := transactionFromContext(ctx)
+	if !ok {
+		return errorReply(errNoConnection)
+	}
+
+	versions := make(map[string]uint64, len(query.Arguments()))
+	for _, key := range query.Arguments() {
+		version, err := d.storage.Version(ctx, key)
+		if err != nil {
+			return errorReply(err)
 		}
-		sort.Strings(validCommands) // Sort for consistent output
-		return fmt.Sprintf("Invalid command. Available commands: %s", strings.Join(validCommands, ", "))
+
+		versions[key] = version
+	}
+
+	if err := transaction.watch(versions); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+func (d *Database) handleUnwatchRequest(ctx context.Context, query compute.Query) Reply {
+	transaction, ok := transactionFromContext(ctx)
+	if !ok {
+		return errorReply(errNoConnection)
+	}
+
+	transaction.unwatch()
+
+	return okReply
+}
+
+func (d *Database) handleSaveRequest(ctx context.Context, query compute.Query) Reply {
+	if err := d.storage.Snapshot(ctx); err != nil {
+		return errorReply(err)
+	}
+
+	return okReply
+}
+
+func (d *Database) handleBgsaveRequest(ctx context.Context, query compute.Query) Reply {
+	if err := d.storage.BackgroundSnapshot(); err != nil {
+		return errorReply(err)
 	}
 
-	return handler(ctx, parts[1:])
+	return statusReply("Background saving started")
 }
 
-func (d *Database) handleSetRequest(ctx context.Context, query []string) string {
-	if len(query) < 2 {
-		return "Invalid SET command. Usage: SET <key> <value>"
+// handleScanRequest iterates keys in order. The reply is the cursor to pass
+// to the next call, which is 0 once the iteration is complete, and the keys.
+// Non-zero cursors are the hex encoded key to resume from.
+func (d *Database) handleScanRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	var start string
+	if args[0] != "0" {
+		// an empty key would restart the iteration from the beginning
+		key, err := hex.DecodeString(args[0])
+		if err != nil || len(key) == 0 {
+			return errorReply(errInvalidCursor)
+		}
+		start = string(key)
 	}
 
-	key := query[0]
-	value := query[1]
+	count := scanDefaultCount
+	if len(args) == 3 {
+		count = int(query.IntArgument(2))
+	}
 
-	d.storage.Set(ctx, key, value)
+	// one extra pair tells where the next call has to resume from
+	pairs, err := d.storage.Range(ctx, start, "", count+1)
+	if err != nil {
+		return errorReply(err)
+	}
 
-	return "[OK]"
+	cursor := "0"
+	if len(pairs) > count {
+		cursor = hex.EncodeToString([]byte(pairs[count].Key))
+		pairs = pairs[:count]
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+
+	return arrayReply(bulkReply(cursor), bulkArrayReply(keys))
 }
 
-func (d *Database) handleGetRequest(ctx context.Context, query []string) string {
-	if len(query) < 1 {
-		return "Invalid GET command. Usage: GET <key>"
+// handleRangeRequest replies with pairs having keys in [start, end).
+func (d *Database) handleRangeRequest(ctx context.Context, query compute.Query) Reply {
+	args := query.Arguments()
+
+	var limit int
+	if len(args) == 4 {
+		limit = int(query.IntArgument(3))
 	}
 
-	key := query[0]
+	pairs, err := d.storage.Range(ctx, args[0], args[1], limit)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	elements := make([]Reply, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		elements = append(elements, bulkReply(pair.Key), bulkReply(pair.Value))
+	}
+
+	return Reply{Kind: MapReply, Elements: elements}
+}
+
+// handleKeysRequest replies with keys matching a prefix pattern like
+// "event:*", "*" matches every key.
+func (d *Database) handleKeysRequest(ctx context.Context, query compute.Query) Reply {
+	prefix := strings.TrimSuffix(query.Arguments()[0], "*")
 
-	value, err := d.storage.Get(ctx, key)
+	pairs, err := d.storage.Range(ctx, prefix, prefixEnd(prefix), 0)
 	if err != nil {
-		return fmt.Sprintf("[error] %s", err.Error())
+		return errorReply(err)
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+	}
+
+	return bulkArrayReply(keys)
+}
+
+func (d *Database) handlePingRequest(ctx context.Context, query compute.Query) Reply {
+	if args := query.Arguments(); len(args) == 1 {
+		return bulkReply(args[0])
 	}
 
-	return value
+	return statusReply("PONG")
 }
 
-func (d *Database) handleDelRequest(ctx context.Context, query []string) string {
-	if len(query) < 1 {
-		return "Invalid DEL command. Usage: DEL <key>"
+// handleAuthRequest authenticates the session of the connection as the user.
+// A failed attempt keeps the session as it was.
+func (d *Database) handleAuthRequest(ctx context.Context, query compute.Query) Reply {
+	if d.authenticator == nil {
+		return errorReply(auth.ErrDisabled)
+	}
+
+	session, ok := auth.FromContext(ctx)
+	if !ok {
+		return errorReply(errNoSession)
 	}
 
-	key := query[0]
+	user, password := query.Arguments()[0], query.Arguments()[1]
+	if err := d.authenticator.Authenticate(session.Client(), user, password); err != nil {
+		d.logger.Warn("authentication failed", zap.String("user", user), zap.String("client", session.Client()), zap.Error(err))
+		return errorReply(err)
+	}
+
+	session.Login(user)
 
-	d.storage.Del(ctx, key)
+	return okReply
+}
+
+// handleACLRequest lists, changes and deletes users, or replies with the
+// user of the connection.
+func (d *Database) handleACLRequest(ctx context.Context, query compute.Query) Reply {
+	if d.authenticator == nil {
+		return errorReply(auth.ErrDisabled)
+	}
+
+	args := query.Arguments()
+
+	switch strings.ToUpper(args[0]) {
+	case compute.ACLList:
+		return bulkArrayReply(d.authenticator.List())
+	case compute.ACLSetUser:
+		if err := d.authenticator.SetUser(args[1], args[2:]); err != nil {
+			return errorReply(err)
+		}
+		d.logger.Info("acl user changed", zap.String("user", args[1]))
+		return okReply
+	case compute.ACLDelUser:
+		deleted := d.authenticator.DelUser(args[1:]...)
+		d.logger.Info("acl users deleted", zap.Strings("users", args[1:]), zap.Int("deleted", deleted))
+		return integerReply(int64(deleted))
+	default:
+		session, ok := auth.FromContext(ctx)
+		if !ok || !session.Authenticated() {
+			return errorReply(auth.ErrAuthRequired)
+		}
+		return bulkReply(session.User())
+	}
+}
+
+// prefixEnd returns the smallest key greater than every key with the prefix,
+// or an empty string if there is no such key.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
 
-	return "[OK]"
+	return ""
 }
@@ -0,0 +1,11 @@
+package database
+
+type Option func(*Database)
+
+// WithAuthenticator requires connections to authenticate with AUTH before
+// running other commands.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(d *Database) {
+		d.authenticator = authenticator
+	}
+}
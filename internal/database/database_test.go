@@ -0,0 +1,657 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	inmemory "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/in_memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestDatabase(t *testing.T, options ...Option) *Database {
+	t.Helper()
+
+	logger := zap.NewNop()
+
+	computeLayer, err := compute.New(logger)
+	require.NoError(t, err)
+
+	engine := inmemory.NewEngine(logger, inmemory.WithSweepInterval(0))
+	t.Cleanup(func() { engine.Close() })
+
+	storageLayer, err := storage.New(logger, engine)
+	require.NoError(t, err)
+
+	db, err := New(computeLayer, storageLayer, logger, options...)
+	require.NoError(t, err)
+
+	return db
+}
+
+// testPasswordHash is "secret" hashed with few iterations to keep tests fast.
+const testPasswordHash = "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$tiKWHy4FAGCWE8gn6GtKhaxD2OeeAUUWXFT/p1aaNl8"
+
+func newTestAuthenticator(t *testing.T, users ...auth.UserConfig) *auth.Authenticator {
+	t.Helper()
+
+	authenticator, err := auth.NewAuthenticator(auth.Config{Enabled: true, Users: users})
+	require.NoError(t, err)
+
+	return authenticator
+}
+
+// loggedIn returns a context of a session authenticated as the user.
+func loggedIn(user string) context.Context {
+	session := auth.NewSession("")
+	session.Login(user)
+
+	return auth.NewContext(context.Background(), session)
+}
+
+func TestHandleRequest(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "SET key value", expected: "[OK]"},
+		{request: "get key", expected: "value"},
+		{request: "SET key value extra", expected: `[error] invalid argument "SET": syntax error, usage: SET <key> <value> [NX | XX] [EX <seconds> | PX <milliseconds>]`},
+		{request: "GET", expected: `[error] wrong number of arguments "GET", usage: GET <key>`},
+		{request: "", expected: "[error] empty request"},
+		{request: "SET session token EX 60", expected: "[OK]"},
+		{request: "TTL session", expected: "60"},
+		{request: "EXPIRE key soon", expected: `[error] invalid argument "EXPIRE": expire time is not an integer, usage: EXPIRE <key> <seconds>`},
+		{request: "EXPIRE key 9223372036854775807", expected: `[error] invalid argument "EXPIRE": invalid expire time, usage: EXPIRE <key> <seconds>`},
+		{request: "SET key value EX 9223372036854775807", expected: `[error] invalid argument "SET": invalid expire time, usage: SET <key> <value> [NX | XX] [EX <seconds> | PX <milliseconds>]`},
+		{request: "TTL key", expected: "-1"},
+		{request: "DEL key", expected: "1"},
+		{request: "GET key", expected: "[error] not found"},
+		{request: "SET price $5", expected: "[OK]"},
+		{request: "GET price", expected: "$5"},
+		{request: "KEYS *", expected: "[error] engine does not support ordered iteration"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	assert.Contains(t, db.HandleRequest(ctx, "FLUSHALL"), `[error] unknown command "FLUSHALL": available commands: ACL, AUTH, BGSAVE, BLPOP, BRPOP, CAS, DECR, DECRBY, DEL`)
+}
+
+func TestHandleCommand(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	assert.Equal(t, okReply, db.HandleCommand(ctx, []string{"SET", "key", "two words"}))
+	assert.Equal(t, bulkReply("two words"), db.HandleCommand(ctx, []string{"GET", "key"}))
+	assert.Equal(t, nilReply(), db.HandleCommand(ctx, []string{"GET", "missing"}))
+	assert.Equal(t, integerReply(-1), db.HandleCommand(ctx, []string{"TTL", "key"}))
+	assert.Equal(t, statusReply("PONG"), db.HandleCommand(ctx, []string{"PING"}))
+
+	reply := db.HandleCommand(ctx, []string{"GET"})
+	assert.Equal(t, ErrorReply, reply.Kind)
+	assert.ErrorIs(t, reply.Err, compute.ErrWrongArgumentsNumber)
+
+	// handlers do not rely on compute to reject cursors SCAN can not resume from
+	reply = db.handleScanRequest(ctx, compute.NewQuery(compute.ScanCommandID, []string{"not-hex"}))
+	assert.ErrorIs(t, reply.Err, errInvalidCursor)
+}
+
+func TestCounters(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "INCR counter", expected: "1"},
+		{request: "INCRBY counter 41", expected: "42"},
+		{request: "DECR counter", expected: "41"},
+		{request: "DECRBY counter 50", expected: "-9"},
+		{request: "GET counter", expected: "-9"},
+		{request: "INCRBY counter 1.5", expected: `[error] invalid argument "INCRBY": increment is not an integer or out of range, usage: INCRBY <key> <increment>`},
+		{request: "SET text value", expected: "[OK]"},
+		{request: "INCR text", expected: "[error] value is not an integer or out of range"},
+		{request: "GET text", expected: "value"},
+		{request: "SET max 9223372036854775807", expected: "[OK]"},
+		{request: "INCR max", expected: "[error] increment or decrement would overflow"},
+		{request: "DECRBY counter -9223372036854775808", expected: "[error] increment or decrement would overflow"},
+		{request: "INCRBYFLOAT price 10.5", expected: "10.5"},
+		{request: "INCRBYFLOAT price -0.25", expected: "10.25"},
+		{request: "INCRBYFLOAT counter 0.5", expected: "-8.5"},
+		{request: "INCR price", expected: "[error] value is not an integer or out of range"},
+		{request: "INCRBYFLOAT text 1", expected: "[error] value is not a valid float"},
+		{request: "INCRBYFLOAT price inf", expected: `[error] invalid argument "INCRBYFLOAT": increment is not a valid float, usage: INCRBYFLOAT <key> <increment>`},
+		{request: "SET session 1 EX 60", expected: "[OK]"},
+		{request: "INCR session", expected: "2"},
+		{request: "TTL session", expected: "60"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					db.HandleRequest(ctx, "INCR hits")
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, "800", db.HandleRequest(ctx, "GET hits"))
+	})
+}
+
+func TestConditionalSet(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "SET lock owner-1 NX EX 10", expected: "[OK]"},
+		{request: "SET lock owner-2 NX", expected: "[error] not set, the condition does not hold"},
+		{request: "GET lock", expected: "owner-1"},
+		{request: "TTL lock", expected: "10"},
+		{request: "SET missing value XX", expected: "[error] not set, the condition does not hold"},
+		{request: "GET missing", expected: "[error] not found"},
+		{request: "SET lock owner-3 XX", expected: "[OK]"},
+		{request: "TTL lock", expected: "-1"},
+		{request: "SETNX lock owner-4", expected: "0"},
+		{request: "SETNX other value", expected: "1"},
+		{request: "GETSET lock owner-5", expected: "owner-3"},
+		{request: "GETSET fresh value", expected: "[error] not found"},
+		{request: "GET fresh", expected: "value"},
+		{request: "CAS lock owner-1 owner-6", expected: "0"},
+		{request: "CAS lock owner-5 owner-6 EX 30", expected: "1"},
+		{request: "GET lock", expected: "owner-6"},
+		{request: "TTL lock", expected: "30"},
+		{request: "CAS absent owner-1 owner-2", expected: "0"},
+		{request: "GETDEL lock", expected: "owner-6"},
+		{request: "GETDEL lock", expected: "[error] not found"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	assert.Equal(t, nilReply().Kind, db.HandleCommand(ctx, []string{"SET", "other", "value", "NX"}).Kind)
+
+	t.Run("concurrent", func(t *testing.T) {
+		var (
+			wg      sync.WaitGroup
+			winners atomic.Int32
+		)
+
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if db.HandleRequest(ctx, "SET leader node-"+strconv.Itoa(i)+" NX") == "[OK]" {
+					winners.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), winners.Load())
+	})
+}
+
+func TestBatchCommands(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "MSET a 1 b 2 c 3", expected: "[OK]"},
+		{request: "MGET a missing c", expected: "1\n[error] not found\n3"},
+		{request: "MSET a 1 b", expected: `[error] invalid argument "MSET": keys and values must come in pairs, usage: MSET <key> <value> [key value ...]`},
+		{request: "MSETNX d 4 a 5", expected: "0"},
+		{request: "MGET a d", expected: "1\n[error] not found"},
+		{request: "MSETNX d 4 e 5", expected: "1"},
+		{request: "DEL a b missing", expected: "2"},
+		{request: "DEL a", expected: "0"},
+		{request: "MGET a b c d e", expected: "[error] not found\n[error] not found\n3\n4\n5"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		ctx := NewTransactionContext(ctx, NewTransaction())
+
+		assert.Equal(t, "[OK]", db.HandleRequest(ctx, "MULTI"))
+		assert.Equal(t, "QUEUED", db.HandleRequest(ctx, "MSET x 1 y 2"))
+		assert.Equal(t, "QUEUED", db.HandleRequest(ctx, "DEL x y"))
+		assert.Equal(t, "[OK]\n2", db.HandleRequest(ctx, "EXEC"))
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value := strconv.Itoa(i)
+				db.HandleRequest(ctx, "MSET p "+value+" q "+value)
+			}()
+		}
+
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				values := db.HandleCommand(ctx, []string{"MGET", "p", "q"}).Elements
+				assert.Equal(t, values[0], values[1])
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestHashes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	wrongType := "[error] WRONGTYPE Operation against a key holding the wrong kind of value"
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "HSET user:42 name ann age 30", expected: "2"},
+		{request: "HSET user:42 name bob city rome", expected: "1"},
+		{request: "HGET user:42 name", expected: "bob"},
+		{request: "HGET user:42 email", expected: "[error] not found"},
+		{request: "HMGET user:42 age email", expected: "30\n[error] not found"},
+		{request: "HGETALL user:42", expected: "age 30\ncity rome\nname bob"},
+		{request: "HKEYS user:42", expected: "age\ncity\nname"},
+		{request: "HLEN user:42", expected: "3"},
+		{request: "HEXISTS user:42 city", expected: "1"},
+		{request: "HINCRBY user:42 age 2", expected: "32"},
+		{request: "HINCRBY user:42 visits -1", expected: "-1"},
+		{request: "HINCRBY user:42 name 1", expected: "[error] value is not an integer or out of range"},
+		{request: "HDEL user:42 visits email", expected: "1"},
+		{request: "HGETALL missing", expected: "(empty array)"},
+		{request: "HLEN missing", expected: "0"},
+
+		{request: "GET user:42", expected: wrongType},
+		{request: "INCR user:42", expected: wrongType},
+		{request: "GETSET user:42 value", expected: wrongType},
+		{request: "MGET user:42", expected: "[error] not found"},
+		{request: "MSETNX user:42 value", expected: "0"},
+		{request: "SETNX user:42 value", expected: "0"},
+		{request: "EXPIRE user:42 60", expected: "1"},
+		{request: "TTL user:42", expected: "60"},
+
+		{request: "SET name ann", expected: "[OK]"},
+		{request: "HSET name first ann", expected: wrongType},
+		{request: "HGET name first", expected: wrongType},
+
+		{request: "HDEL user:42 name age city", expected: "3"},
+		{request: "HLEN user:42", expected: "0"},
+		{request: "TTL user:42", expected: "-2"},
+
+		{request: "HSET user:43 name ann", expected: "1"},
+		{request: "SET user:43 replaced", expected: "[OK]"},
+		{request: "GET user:43", expected: "replaced"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	reply := db.HandleCommand(ctx, []string{"GET", "name"})
+	assert.Equal(t, bulkReply("ann"), reply)
+
+	db.HandleRequest(ctx, "HSET user:44 name ann")
+	reply = db.HandleCommand(ctx, []string{"GET", "user:44"})
+	assert.ErrorIs(t, reply.Err, storage.ErrWrongType)
+}
+
+func TestLists(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{request: "RPUSH jobs b c", expected: "2"},
+		{request: "LPUSH jobs a z", expected: "4"},
+		{request: "LRANGE jobs 0 -1", expected: "z\na\nb\nc"},
+		{request: "LRANGE jobs -2 10", expected: "b\nc"},
+		{request: "LRANGE jobs 3 1", expected: "(empty array)"},
+		{request: "LINDEX jobs -1", expected: "c"},
+		{request: "LINDEX jobs 4", expected: "[error] not found"},
+		{request: "LPOP jobs", expected: "z"},
+		{request: "RPOP jobs", expected: "c"},
+		{request: "LLEN jobs", expected: "2"},
+		{request: "LTRIM jobs 1 -1", expected: "[OK]"},
+		{request: "LRANGE jobs 0 -1", expected: "b"},
+		{request: "BLPOP empty jobs 0", expected: "jobs\nb"},
+		{request: "LLEN jobs", expected: "0"},
+		{request: "LPOP jobs", expected: "[error] not found"},
+		{request: "BRPOP jobs 0.01", expected: "[error] timed out, no item was pushed"},
+
+		{request: "SET name ann", expected: "[OK]"},
+		{request: "RPUSH name bob", expected: "[error] WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{request: "BLPOP name 0", expected: "[error] WRONGTYPE Operation against a key holding the wrong kind of value"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(ctx, test.request), test.request)
+	}
+
+	t.Run("blocking pop woken by a push", func(t *testing.T) {
+		popped := make(chan string)
+		go func() {
+			popped <- db.HandleRequest(ctx, "BRPOP queue 5")
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, "2", db.HandleRequest(ctx, "RPUSH queue first second"))
+		assert.Equal(t, "queue\nsecond", <-popped)
+		assert.Equal(t, "first", db.HandleRequest(ctx, "LRANGE queue 0 -1"))
+	})
+
+	t.Run("blocking pops woken in order", func(t *testing.T) {
+		popped := make([]chan string, 3)
+		for i := range popped {
+			popped[i] = make(chan string, 1)
+			go func() {
+				popped[i] <- db.HandleRequest(ctx, "BLPOP fifo 5")
+			}()
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assert.Equal(t, "1", db.HandleRequest(ctx, "RPUSH fifo first"))
+		assert.Equal(t, "fifo\nfirst", <-popped[0])
+
+		// each item wakes up one of the remaining pops
+		assert.Equal(t, "2", db.HandleRequest(ctx, "RPUSH fifo second third"))
+		assert.ElementsMatch(t, []string{"fifo\nsecond", "fifo\nthird"}, []string{<-popped[1], <-popped[2]})
+	})
+
+	t.Run("blocking pop ends with the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		popped := make(chan string)
+		go func() {
+			popped <- db.HandleRequest(ctx, "BLPOP idle 0")
+		}()
+
+		cancel()
+		assert.Equal(t, "[error] timed out, no item was pushed", <-popped)
+	})
+
+	t.Run("blocking pop inside a transaction", func(t *testing.T) {
+		ctx := NewTransactionContext(ctx, NewTransaction())
+
+		db.HandleRequest(ctx, "MULTI")
+		db.HandleRequest(ctx, "BLPOP idle 0")
+		db.HandleRequest(ctx, "RPUSH idle job")
+		db.HandleRequest(ctx, "BLPOP idle 0")
+
+		assert.Equal(t, "[error] timed out, no item was pushed\n1\nidle\njob", db.HandleRequest(ctx, "EXEC"))
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	db := newTestDatabase(t)
+
+	ctx := NewTransactionContext(context.Background(), NewTransaction())
+	other := NewTransactionContext(context.Background(), NewTransaction())
+
+	tests := []struct {
+		ctx      context.Context
+		request  string
+		expected string
+	}{
+		{ctx: ctx, request: "EXEC", expected: "[error] EXEC without MULTI"},
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "MULTI", expected: "[error] MULTI calls can not be nested"},
+		{ctx: ctx, request: "SET a 1", expected: "QUEUED"},
+		{ctx: ctx, request: "INCR a", expected: "QUEUED"},
+		{ctx: ctx, request: "INCR b", expected: "QUEUED"},
+		{ctx: other, request: "GET a", expected: "[error] not found"},
+		{ctx: ctx, request: "EXEC", expected: "[OK]\n2\n1"},
+		{ctx: other, request: "GET a", expected: "2"},
+
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "SET a 3", expected: "QUEUED"},
+		{ctx: ctx, request: "DISCARD", expected: "[OK]"},
+		{ctx: ctx, request: "DISCARD", expected: "[error] DISCARD without MULTI"},
+		{ctx: ctx, request: "GET a", expected: "2"},
+
+		// errors of queued commands are replied by EXEC
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "SET text value", expected: "QUEUED"},
+		{ctx: ctx, request: "INCR text", expected: "QUEUED"},
+		{ctx: ctx, request: "EXEC", expected: "[OK]\n[error] value is not an integer or out of range"},
+
+		// commands that cannot be queued discard the transaction
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "SET a 4", expected: "QUEUED"},
+		{ctx: ctx, request: "GET", expected: `[error] wrong number of arguments "GET", usage: GET <key>`},
+		{ctx: ctx, request: "EXEC", expected: "[error] transaction discarded because of previous errors"},
+		{ctx: ctx, request: "GET a", expected: "2"},
+
+		{ctx: ctx, request: "WATCH a", expected: "[OK]"},
+		{ctx: other, request: "INCR a", expected: "3"},
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "WATCH b", expected: "[error] WATCH inside MULTI is not allowed"},
+		{ctx: ctx, request: "SET a 10", expected: "QUEUED"},
+		{ctx: ctx, request: "EXEC", expected: "[error] transaction aborted, a watched key changed"},
+		{ctx: ctx, request: "GET a", expected: "3"},
+
+		// EXEC unwatches keys
+		{ctx: other, request: "INCR a", expected: "4"},
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "EXEC", expected: "(empty array)"},
+
+		{ctx: ctx, request: "WATCH missing", expected: "[OK]"},
+		{ctx: other, request: "SET missing value", expected: "[OK]"},
+		{ctx: other, request: "DEL missing", expected: "1"},
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "EXEC", expected: "[error] transaction aborted, a watched key changed"},
+
+		{ctx: ctx, request: "WATCH a b", expected: "[OK]"},
+		{ctx: ctx, request: "UNWATCH", expected: "[OK]"},
+		{ctx: other, request: "INCR a", expected: "5"},
+		{ctx: ctx, request: "MULTI", expected: "[OK]"},
+		{ctx: ctx, request: "GET a", expected: "QUEUED"},
+		{ctx: ctx, request: "EXEC", expected: "5"},
+
+		{ctx: context.Background(), request: "MULTI", expected: "[error] transactions require a client connection"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(test.ctx, test.request), test.request)
+	}
+
+	t.Run("watch abort", func(t *testing.T) {
+		db.HandleRequest(ctx, "WATCH a")
+		db.HandleRequest(other, "INCR a")
+		db.HandleRequest(ctx, "MULTI")
+
+		// clients retry on a nil array rather than fail
+		reply := db.HandleCommand(ctx, []string{"EXEC"})
+		assert.Equal(t, NilArrayReply, reply.Kind)
+		assert.ErrorIs(t, reply.Err, errWatchedKeyChanged)
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			ctx := NewTransactionContext(context.Background(), NewTransaction())
+			for range 100 {
+				db.HandleRequest(ctx, "MULTI")
+				db.HandleRequest(ctx, "INCR x")
+				db.HandleRequest(ctx, "INCR y")
+				db.HandleRequest(ctx, "EXEC")
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			ctx := NewTransactionContext(context.Background(), NewTransaction())
+			for range 100 {
+				db.HandleRequest(ctx, "MULTI")
+				db.HandleRequest(ctx, "GET x")
+				db.HandleRequest(ctx, "GET y")
+
+				// both keys are read between the same transactions
+				values := strings.Split(db.HandleRequest(ctx, "EXEC"), "\n")
+				assert.Equal(t, values[0], values[1])
+			}
+		}()
+
+		wg.Wait()
+	})
+}
+
+func TestAuth(t *testing.T) {
+	db := newTestDatabase(t, WithAuthenticator(newTestAuthenticator(t,
+		auth.UserConfig{Name: "admin", PasswordHash: testPasswordHash},
+	)))
+
+	session := auth.NewSession("")
+	ctx := auth.NewContext(context.Background(), session)
+
+	assert.Equal(t, "[error] authentication required", db.HandleRequest(ctx, "SET key value"))
+	assert.Equal(t, "[error] invalid username-password pair", db.HandleRequest(ctx, "AUTH admin wrong"))
+	assert.False(t, session.Authenticated())
+
+	assert.Equal(t, "[OK]", db.HandleRequest(ctx, "AUTH admin secret"))
+	assert.Equal(t, "admin", session.User())
+	assert.Equal(t, "[OK]", db.HandleRequest(ctx, "SET key value"))
+
+	// a failed attempt keeps the user authenticated
+	assert.Equal(t, "[error] invalid username-password pair", db.HandleRequest(ctx, "AUTH admin wrong"))
+	assert.Equal(t, "value", db.HandleRequest(ctx, "GET key"))
+
+	t.Run("other session", func(t *testing.T) {
+		ctx := auth.NewContext(context.Background(), auth.NewSession(""))
+		assert.Equal(t, "[error] authentication required", db.HandleRequest(ctx, "GET key"))
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		assert.Equal(t, "[error] authentication required", db.HandleRequest(context.Background(), "GET key"))
+		assert.Equal(t, "[error] AUTH requires a client connection", db.HandleRequest(context.Background(), "AUTH admin secret"))
+	})
+
+	t.Run("auth disabled", func(t *testing.T) {
+		db := newTestDatabase(t)
+		assert.Equal(t, "[error] AUTH called without any users configured", db.HandleRequest(ctx, "AUTH admin secret"))
+	})
+}
+
+func TestACL(t *testing.T) {
+	db := newTestDatabase(t, WithAuthenticator(newTestAuthenticator(t,
+		auth.UserConfig{Name: "admin", PasswordHash: testPasswordHash},
+		auth.UserConfig{Name: "team-a", PasswordHash: testPasswordHash, Categories: []string{"read", "write"}, Keys: []string{"team-a:*"}},
+		auth.UserConfig{Name: "reader", PasswordHash: testPasswordHash, Categories: []string{"read"}, Keys: []string{"team-?:*"}},
+	)))
+
+	admin, teamA, reader := loggedIn("admin"), loggedIn("team-a"), loggedIn("reader")
+
+	tests := []struct {
+		ctx      context.Context
+		request  string
+		expected string
+	}{
+		{ctx: teamA, request: "SET team-a:1 value", expected: "[OK]"},
+		{ctx: teamA, request: "GET team-a:1", expected: "value"},
+		{ctx: teamA, request: "SET team-b:1 value", expected: `[error] no permission to access key "team-b:1"`},
+		{ctx: teamA, request: "SAVE", expected: "[error] no permission to run SAVE"},
+		{ctx: teamA, request: "KEYS team-a:*", expected: "[error] engine does not support ordered iteration"},
+		{ctx: teamA, request: "KEYS team-a:1*", expected: "[error] engine does not support ordered iteration"},
+		{ctx: teamA, request: "KEYS team-*", expected: `[error] no permission to access keys "team-*"`},
+		{ctx: teamA, request: "SCAN 0", expected: "[error] no permission to run SCAN on every key"},
+		{ctx: teamA, request: "PING", expected: "PONG"},
+		{ctx: teamA, request: "ACL WHOAMI", expected: "team-a"},
+		{ctx: teamA, request: "ACL LIST", expected: "[error] no permission to run ACL"},
+		{ctx: reader, request: "GET team-a:1", expected: "value"},
+		{ctx: reader, request: "DEL team-a:1", expected: "[error] no permission to run DEL"},
+		{ctx: reader, request: "GET other", expected: `[error] no permission to access key "other"`},
+		{ctx: reader, request: "KEYS team-b:*", expected: "[error] engine does not support ordered iteration"},
+		{ctx: reader, request: "KEYS team*", expected: `[error] no permission to access keys "team*"`},
+		{ctx: admin, request: "SET other value", expected: "[OK]"},
+		{
+			ctx:     admin,
+			request: "ACL LIST",
+			expected: "user admin #" + testPasswordHash + " ~* +@all\n" +
+				"user reader #" + testPasswordHash + " ~team-?:* +@read\n" +
+				"user team-a #" + testPasswordHash + " ~team-a:* +@read +@write",
+		},
+		{ctx: admin, request: "ACL SETUSER team-a -@write ~shared:*", expected: "[OK]"},
+		{ctx: teamA, request: "SET team-a:1 value", expected: "[error] no permission to run SET"},
+		{ctx: teamA, request: "GET shared:1", expected: "[error] not found"},
+		{ctx: admin, request: "ACL SETUSER team-a +@cache", expected: `[error] unknown command category "cache"`},
+		{ctx: admin, request: "ACL SETUSER team-a on", expected: `[error] invalid ACL rule "on"`},
+		{ctx: admin, request: "ACL DELUSER team-a nobody", expected: "1"},
+		{ctx: teamA, request: "GET team-a:1", expected: "[error] authentication required"},
+		{ctx: admin, request: "ACL SETUSER writer #" + testPasswordHash + " +@write allkeys", expected: "[OK]"},
+		{ctx: admin, request: "ACL FLUSH", expected: `[error] invalid argument "ACL": unknown subcommand "FLUSH", usage: ACL LIST | ACL WHOAMI | ACL SETUSER <user> [rule ...] | ACL DELUSER <user> [user ...]`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, db.HandleRequest(test.ctx, test.request), test.request)
+	}
+
+	// users created at runtime can authenticate
+	session := auth.NewSession("")
+	ctx := auth.NewContext(context.Background(), session)
+	assert.Equal(t, "[OK]", db.HandleRequest(ctx, "AUTH writer secret"))
+	assert.Equal(t, "[OK]", db.HandleRequest(ctx, "SET any value"))
+	assert.Equal(t, "[error] no permission to run GET", db.HandleRequest(ctx, "GET any"))
+}
+
+func TestReplyText(t *testing.T) {
+	tests := []struct {
+		reply    Reply
+		expected string
+	}{
+		{reply: okReply, expected: "[OK]"},
+		{reply: statusReply("PONG"), expected: "PONG"},
+		{reply: integerReply(1), expected: "1"},
+		{reply: nilReply(), expected: "[error] not found"},
+		{reply: arrayReply(), expected: "(empty array)"},
+		{reply: arrayReply(bulkReply("0"), bulkArrayReply(nil)), expected: "0"},
+		{reply: arrayReply(bulkReply("6b"), bulkArrayReply([]string{"a", "b"})), expected: "6b\na\nb"},
+		{reply: Reply{Kind: MapReply, Elements: []Reply{bulkReply("a"), bulkReply("1"), bulkReply("b"), bulkReply("2")}}, expected: "a 1\nb 2"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.reply.Text())
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+)
+
+// ReplyKind is the type of a reply. Kinds follow RESP types, so a reply can
+// be encoded by any protocol the server speaks.
+type ReplyKind int
+
+const (
+	StatusReply ReplyKind = iota
+	ErrorReply
+	IntegerReply
+	BulkReply
+	NilReply
+	ArrayReply
+	// MapReply holds keys and values interleaved in Elements.
+	MapReply
+	// NilArrayReply is the nil reply of commands replying with arrays, such
+	// as EXEC aborted by WATCH.
+	NilArrayReply
+)
+
+// Reply is the result of a command.
+type Reply struct {
+	Kind ReplyKind
+	// Str is the value of status and bulk replies.
+	Str string
+	Int int64
+	// Err is the error of error replies, or the reason of nil replies.
+	Err      error
+	Elements []Reply
+}
+
+var okReply = Reply{Kind: StatusReply, Str: "OK"}
+
+func statusReply(status string) Reply {
+	return Reply{Kind: StatusReply, Str: status}
+}
+
+func errorReply(err error) Reply {
+	return Reply{Kind: ErrorReply, Err: err}
+}
+
+func integerReply(value int64) Reply {
+	return Reply{Kind: IntegerReply, Int: value}
+}
+
+func boolReply(value bool) Reply {
+	if value {
+		return integerReply(1)
+	}
+
+	return integerReply(0)
+}
+
+func bulkReply(value string) Reply {
+	return Reply{Kind: BulkReply, Str: value}
+}
+
+func nilReply() Reply {
+	return Reply{Kind: NilReply}
+}
+
+// notSetReply is the nil reply of conditional writes whose condition does
+// not hold.
+func notSetReply() Reply {
+	return Reply{Kind: NilReply, Err: errNotSet}
+}
+
+// watchAbortReply is the nil reply of EXEC once a watched key changed,
+// which clients retry rather than handle as an error.
+func watchAbortReply() Reply {
+	return Reply{Kind: NilArrayReply, Err: errWatchedKeyChanged}
+}
+
+func arrayReply(elements ...Reply) Reply {
+	return Reply{Kind: ArrayReply, Elements: elements}
+}
+
+func bulkArrayReply(values []string) Reply {
+	elements := make([]Reply, 0, len(values))
+	for _, value := range values {
+		elements = append(elements, bulkReply(value))
+	}
+
+	return arrayReply(elements...)
+}
+
+// Text formats the reply for the plain text protocol.
+func (r Reply) Text() string {
+	switch r.Kind {
+	case StatusReply:
+		if r.Str == okReply.Str {
+			return "[OK]"
+		}
+		return r.Str
+	case ErrorReply:
+		return formatError(r.Err)
+	case IntegerReply:
+		return strconv.FormatInt(r.Int, 10)
+	case BulkReply:
+		return r.Str
+	case NilReply, NilArrayReply:
+		// nil replies may carry the reason there is no value
+		if r.Err != nil {
+			return formatError(r.Err)
+		}
+		return formatError(storage.ErrNotFound)
+	case ArrayReply, MapReply:
+		lines := r.appendLines(nil)
+		if len(lines) == 0 {
+			return emptyReply
+		}
+		return strings.Join(lines, "\n")
+	default:
+		return ""
+	}
+}
+
+// appendLines formats an array one element per line, nested arrays are
+// flattened, and a map one "key value" pair per line.
+func (r Reply) appendLines(lines []string) []string {
+	switch r.Kind {
+	case ArrayReply:
+		for _, element := range r.Elements {
+			lines = element.appendLines(lines)
+		}
+	case MapReply:
+		for i := 0; i+1 < len(r.Elements); i += 2 {
+			lines = append(lines, r.Elements[i].Text()+" "+r.Elements[i+1].Text())
+		}
+	default:
+		lines = append(lines, r.Text())
+	}
+
+	return lines
+}
@@ -0,0 +1,46 @@
+package bitcask
+
+import (
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"go.uber.org/zap"
+)
+
+const EngineType = "bitcask"
+
+// Config is the engine.bitcask configuration section
+type Config struct {
+	DataDirectory  string  `yaml:"data-directory" env:"KVDB_ENGINE_BITCASK_DATA_DIRECTORY" env-description:"Directory for data and hint files of the engine" env-default:"data/bitcask"`
+	SyncPolicy     string  `yaml:"sync-policy" env:"KVDB_ENGINE_BITCASK_SYNC_POLICY" env-description:"Data file fsync policy: always, batch or never" env-default:"batch"`
+	MaxFileSize    int64   `yaml:"max-file-size" env:"KVDB_ENGINE_BITCASK_MAX_FILE_SIZE" env-description:"Data file size in bytes that triggers rotation" env-default:"67108864"`
+	MergeInterval  int     `yaml:"merge-interval" env:"KVDB_ENGINE_BITCASK_MERGE_INTERVAL" env-description:"Interval in seconds between merge checks, 0 disables merges" env-default:"60"`
+	MergeThreshold float64 `yaml:"merge-threshold" env:"KVDB_ENGINE_BITCASK_MERGE_THRESHOLD" env-description:"Share of dead bytes that triggers a merge" env-default:"0.5"`
+}
+
+func init() {
+	engine.Register(EngineType, newFromConfig)
+}
+
+func newFromConfig(logger *zap.Logger, config engine.Config) (storage.Engine, error) {
+	var cfg Config
+	if err := config.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	syncPolicy, err := wal.ParseSyncPolicy(cfg.SyncPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEngine(
+		logger,
+		WithDataDirectory(cfg.DataDirectory),
+		WithSyncPolicy(syncPolicy),
+		WithMaxFileSize(cfg.MaxFileSize),
+		WithMergeInterval(time.Duration(cfg.MergeInterval)*time.Second),
+		WithMergeThreshold(cfg.MergeThreshold),
+	)
+}
@@ -0,0 +1,506 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"go.uber.org/zap"
+)
+
+const (
+	dataFileFormat  = "%020d.data"
+	hintFileFormat  = "%020d.hint"
+	tmpFileSuffix   = ".tmp"
+	mergeMarkerName = "merge.inputs"
+
+	// batchSyncInterval is how often the active file is synced with the batch sync policy.
+	batchSyncInterval = 10 * time.Millisecond
+)
+
+var (
+	errInvalidLogger    = errors.New("invalid logger")
+	errInvalidDirectory = errors.New("invalid data directory")
+)
+
+// location points to the latest record of a key.
+type location struct {
+	fileID uint64
+	offset int64
+	size   uint32
+	seq    uint64
+}
+
+type dataFile struct {
+	id   uint64
+	file *os.File
+	size int64
+	// dead is the number of bytes taken by overwritten values and tombstones.
+	dead int64
+}
+
+// Engine is a Bitcask-style log-structured hash table. Every write is appended
+// to the active data file, while the keydir kept in memory maps each key to
+// the location of its latest value, so a read takes a single disk access.
+// Merges rewrite live values of immutable data files to reclaim space taken
+// by overwritten and deleted keys.
+type Engine struct {
+	mutex  sync.RWMutex
+	keydir map[string]location
+	files  map[uint64]*dataFile
+	active *dataFile
+	seq    uint64
+
+	nextFileID uint64
+	dirty      atomic.Bool
+	// mergeMutex allows only one merge at a time.
+	mergeMutex sync.Mutex
+
+	directory      string
+	syncPolicy     wal.SyncPolicy
+	maxFileSize    int64
+	mergeInterval  time.Duration
+	mergeThreshold float64
+
+	logger    *zap.Logger
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewEngine(logger *zap.Logger, options ...EngineOption) (*Engine, error) {
+	if logger == nil {
+		return nil, errInvalidLogger
+	}
+
+	engine := &Engine{
+		keydir:         make(map[string]location),
+		files:          make(map[uint64]*dataFile),
+		nextFileID:     1,
+		syncPolicy:     wal.SyncBatch,
+		maxFileSize:    64 << 20,
+		mergeInterval:  time.Minute,
+		mergeThreshold: 0.5,
+		logger:         logger,
+		done:           make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(engine)
+	}
+
+	if engine.directory == "" {
+		return nil, errInvalidDirectory
+	}
+
+	if err := engine.open(); err != nil {
+		engine.closeFiles()
+		return nil, err
+	}
+
+	if engine.syncPolicy == wal.SyncBatch {
+		engine.wg.Add(1)
+		go engine.syncPeriodically()
+	}
+
+	if engine.mergeInterval > 0 {
+		engine.wg.Add(1)
+		go engine.mergePeriodically()
+	}
+
+	return engine, nil
+}
+
+func (e *Engine) Get(ctx context.Context, key string) (string, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.get(key)
+}
+
+// Update holds mutex while reading and writing the key, so that writes of the
+// key cannot interleave.
+func (e *Engine) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, err := fn(e.get(key))
+	if err != nil {
+		return err
+	}
+
+	e.writeLocked(record{key: key, value: value})
+
+	return nil
+}
+
+func (e *Engine) GetDel(ctx context.Context, key string) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, found := e.get(key)
+	if found {
+		e.writeLocked(record{key: key, deleted: true})
+	}
+
+	return value, found
+}
+
+// get must be called with mutex held.
+func (e *Engine) get(key string) (string, bool) {
+	loc, ok := e.keydir[key]
+	if !ok {
+		return "", false
+	}
+
+	r, err := readRecord(e.files[loc.fileID].file, loc.offset, loc.size)
+	if err != nil {
+		e.logger.Error("failed to read value", zap.String("file", e.dataFilePath(loc.fileID)), zap.Error(err))
+		return "", false
+	}
+
+	return r.value, true
+}
+
+func (e *Engine) Set(ctx context.Context, key, value string) {
+	e.write(record{key: key, value: value})
+}
+
+func (e *Engine) Del(ctx context.Context, key string) {
+	e.write(record{key: key, deleted: true})
+}
+
+// DataDirectory returns the directory the engine keeps its data in.
+func (e *Engine) DataDirectory() string {
+	return e.directory
+}
+
+// Close waits for a merge in progress, syncs the active file and closes the data files.
+func (e *Engine) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+
+		err = e.active.file.Sync()
+		if e.active.size == 0 {
+			delete(e.files, e.active.id)
+			err = errors.Join(err, e.active.file.Close(), os.Remove(e.dataFilePath(e.active.id)))
+		}
+
+		err = errors.Join(err, e.closeFiles())
+	})
+
+	return err
+}
+
+func (e *Engine) write(r record) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.writeLocked(r)
+}
+
+// writeLocked must be called with mutex held.
+func (e *Engine) writeLocked(r record) {
+	// a tombstone is needed only to shadow a value on disk
+	if _, ok := e.keydir[r.key]; r.deleted && !ok {
+		return
+	}
+
+	if e.active.size >= e.maxFileSize {
+		if err := e.rotate(); err != nil {
+			e.logger.Error("failed to rotate data file", zap.Error(err))
+		}
+	}
+
+	e.seq++
+	r.seq = e.seq
+
+	data := r.encode()
+
+	// the engine interface does not allow returning the error,
+	// the write is lost on failure
+	if _, err := e.active.file.Write(data); err != nil {
+		e.logger.Error("failed to append to data file", zap.Error(err))
+		return
+	}
+
+	switch e.syncPolicy {
+	case wal.SyncAlways:
+		if err := e.active.file.Sync(); err != nil {
+			e.logger.Error("failed to sync data file", zap.Error(err))
+		}
+	case wal.SyncBatch:
+		e.dirty.Store(true)
+	}
+
+	loc := location{
+		fileID: e.active.id,
+		offset: e.active.size,
+		size:   uint32(len(data)),
+		seq:    r.seq,
+	}
+	e.active.size += int64(len(data))
+
+	if previous, ok := e.keydir[r.key]; ok {
+		e.files[previous.fileID].dead += int64(previous.size)
+	}
+
+	if r.deleted {
+		delete(e.keydir, r.key)
+		e.active.dead += int64(len(data))
+	} else {
+		e.keydir[r.key] = loc
+	}
+}
+
+// rotate makes the active data file immutable and starts a new one.
+// It must be called with mutex held.
+func (e *Engine) rotate() error {
+	if e.active != nil {
+		if e.active.size == 0 {
+			return nil
+		}
+
+		if err := e.active.file.Sync(); err != nil {
+			return fmt.Errorf("sync data file: %w", err)
+		}
+	}
+
+	id := e.allocateFileID()
+
+	file, err := os.OpenFile(e.dataFilePath(id), os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("create data file: %w", err)
+	}
+
+	e.active = &dataFile{id: id, file: file}
+	e.files[id] = e.active
+
+	return nil
+}
+
+// allocateFileID must be called with mutex held.
+func (e *Engine) allocateFileID() uint64 {
+	id := e.nextFileID
+	e.nextFileID++
+	return id
+}
+
+func (e *Engine) syncPeriodically() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(batchSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			if !e.dirty.Swap(false) {
+				continue
+			}
+
+			e.mutex.RLock()
+			err := e.active.file.Sync()
+			e.mutex.RUnlock()
+
+			if err != nil {
+				e.logger.Error("failed to sync data file", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (e *Engine) mergePeriodically() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.mergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			if !e.mergeNeeded() {
+				continue
+			}
+
+			if err := e.merge(); err != nil {
+				e.logger.Error("failed to merge data files", zap.Error(err))
+			}
+		}
+	}
+}
+
+// mergeNeeded reports whether the share of dead bytes in data files reached
+// the merge threshold.
+func (e *Engine) mergeNeeded() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	var size, dead int64
+	for _, f := range e.files {
+		size += f.size
+		dead += f.dead
+	}
+
+	return size > 0 && float64(dead) >= float64(size)*e.mergeThreshold
+}
+
+// open finishes an interrupted merge, builds the keydir from data and hint
+// files and starts a new active data file.
+func (e *Engine) open() error {
+	if err := os.MkdirAll(e.directory, 0o755); err != nil {
+		return fmt.Errorf("create bitcask directory: %w", err)
+	}
+
+	if err := e.finishMerge(); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(e.directory)
+	if err != nil {
+		return fmt.Errorf("read bitcask directory: %w", err)
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// unfinished files of an interrupted merge
+		if strings.HasSuffix(name, tmpFileSuffix) {
+			if err := os.Remove(filepath.Join(e.directory, name)); err != nil {
+				return fmt.Errorf("remove unfinished file: %w", err)
+			}
+			continue
+		}
+
+		var id uint64
+		if _, err := fmt.Sscanf(name, dataFileFormat, &id); err == nil && name == fmt.Sprintf(dataFileFormat, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+
+	// tombstones holds sequence numbers of deleted keys while loading, since
+	// files of a merge may hold values older than tombstones of other files
+	tombstones := make(map[string]uint64)
+	for _, id := range ids {
+		if err := e.loadDataFile(id, tombstones); err != nil {
+			return err
+		}
+
+		e.nextFileID = max(e.nextFileID, id+1)
+	}
+
+	return e.rotate()
+}
+
+func (e *Engine) loadDataFile(id uint64, tombstones map[string]uint64) error {
+	path := e.dataFilePath(id)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open data file: %w", err)
+	}
+
+	f := &dataFile{id: id, file: file}
+	e.files[id] = f
+
+	if hints, err := readHints(e.hintFilePath(id)); err == nil {
+		for _, h := range hints {
+			e.restore(h.key, location{fileID: id, offset: h.offset, size: h.size, seq: h.seq}, false, tombstones)
+			f.size = max(f.size, h.offset+int64(h.size))
+		}
+
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		e.logger.Warn("ignoring damaged hint file", zap.String("file", e.hintFilePath(id)), zap.Error(err))
+	}
+
+	scanner := newRecordScanner(file)
+	for {
+		r, offset, size, err := scanner.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			// a record torn by a crash, everything after it is lost
+			e.logger.Warn("truncating damaged data file", zap.String("file", path), zap.Int64("offset", scanner.offset))
+			if err := os.Truncate(path, scanner.offset); err != nil {
+				return fmt.Errorf("truncate data file: %w", err)
+			}
+			break
+		}
+
+		e.restore(r.key, location{fileID: id, offset: offset, size: size, seq: r.seq}, r.deleted, tombstones)
+	}
+
+	f.size = scanner.offset
+
+	return nil
+}
+
+// restore applies a record found while loading data files in any order.
+func (e *Engine) restore(key string, loc location, deleted bool, tombstones map[string]uint64) {
+	e.seq = max(e.seq, loc.seq)
+
+	if seq, ok := tombstones[key]; ok && seq > loc.seq {
+		e.files[loc.fileID].dead += int64(loc.size)
+		return
+	}
+
+	if previous, ok := e.keydir[key]; ok {
+		if previous.seq > loc.seq {
+			e.files[loc.fileID].dead += int64(loc.size)
+			return
+		}
+
+		e.files[previous.fileID].dead += int64(previous.size)
+	}
+
+	if deleted {
+		delete(e.keydir, key)
+		tombstones[key] = loc.seq
+		e.files[loc.fileID].dead += int64(loc.size)
+		return
+	}
+
+	e.keydir[key] = loc
+}
+
+func (e *Engine) dataFilePath(id uint64) string {
+	return filepath.Join(e.directory, fmt.Sprintf(dataFileFormat, id))
+}
+
+func (e *Engine) hintFilePath(id uint64) string {
+	return filepath.Join(e.directory, fmt.Sprintf(hintFileFormat, id))
+}
+
+func (e *Engine) closeFiles() error {
+	var err error
+	for _, f := range e.files {
+		err = errors.Join(err, f.file.Close())
+	}
+
+	return err
+}
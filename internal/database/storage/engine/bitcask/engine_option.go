@@ -0,0 +1,45 @@
+package bitcask
+
+import (
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+)
+
+type EngineOption func(*Engine)
+
+// WithDataDirectory sets the directory keeping data and hint files.
+func WithDataDirectory(directory string) EngineOption {
+	return func(e *Engine) {
+		e.directory = directory
+	}
+}
+
+func WithSyncPolicy(policy wal.SyncPolicy) EngineOption {
+	return func(e *Engine) {
+		e.syncPolicy = policy
+	}
+}
+
+// WithMaxFileSize sets the size in bytes at which the active data file is rotated.
+func WithMaxFileSize(size int64) EngineOption {
+	return func(e *Engine) {
+		e.maxFileSize = size
+	}
+}
+
+// WithMergeInterval sets how often the need for a merge is checked,
+// zero disables merges.
+func WithMergeInterval(interval time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.mergeInterval = interval
+	}
+}
+
+// WithMergeThreshold sets the share of dead bytes in data files from 0 to 1
+// that triggers a merge.
+func WithMergeThreshold(threshold float64) EngineOption {
+	return func(e *Engine) {
+		e.mergeThreshold = threshold
+	}
+}
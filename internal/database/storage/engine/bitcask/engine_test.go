@@ -0,0 +1,205 @@
+package bitcask
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestEngine(t *testing.T, directory string) *Engine {
+	t.Helper()
+
+	engine, err := NewEngine(
+		zap.NewNop(),
+		WithDataDirectory(directory),
+		WithMaxFileSize(1024),
+		WithMergeInterval(0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return engine
+}
+
+func diskUsage(t *testing.T, directory string) int64 {
+	t.Helper()
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		size += info.Size()
+	}
+
+	return size
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	random := rand.New(rand.NewPCG(1, 2))
+
+	engine := newTestEngine(t, directory)
+
+	expected := make(map[string]string)
+	for i := range 5000 {
+		key := "key:" + strconv.Itoa(random.IntN(200))
+
+		switch random.IntN(4) {
+		case 0:
+			if random.IntN(2) == 0 {
+				engine.Del(ctx, key)
+			} else if value, ok := engine.GetDel(ctx, key); value != expected[key] || ok != (expected[key] != "") {
+				t.Errorf("expected %q for %v, got %q %v", expected[key], key, value, ok)
+			}
+			delete(expected, key)
+		case 1:
+			err := engine.Update(ctx, key, func(value string, found bool) (string, error) {
+				if expectedValue, exists := expected[key]; found != exists || value != expectedValue {
+					t.Errorf("expected %q %v for %v, got %q %v", expectedValue, exists, key, value, found)
+				}
+				return value + "+", nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected[key] += "+"
+		default:
+			engine.Set(ctx, key, strconv.Itoa(i))
+			expected[key] = strconv.Itoa(i)
+		}
+	}
+
+	check := func(engine *Engine) {
+		t.Helper()
+
+		for i := range 200 {
+			key := "key:" + strconv.Itoa(i)
+
+			value, ok := engine.Get(ctx, key)
+			if expectedValue, exists := expected[key]; ok != exists || value != expectedValue {
+				t.Errorf("expected %q %v for %v, got %q %v", expectedValue, exists, key, value, ok)
+			}
+		}
+	}
+
+	check(engine)
+
+	before := diskUsage(t, directory)
+	if err := engine.merge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if after := diskUsage(t, directory); after >= before/2 {
+		t.Errorf("expected merge to reclaim space, got %v bytes of %v", after, before)
+	}
+
+	check(engine)
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	engine = newTestEngine(t, directory)
+	defer engine.Close()
+
+	check(engine)
+}
+
+func TestEngineTornWrite(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	engine := newTestEngine(t, directory)
+	engine.Set(ctx, "key", "value")
+	engine.Set(ctx, "deleted", "value")
+	engine.Del(ctx, "deleted")
+	path := engine.dataFilePath(engine.active.id)
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Write(record{seq: 100, key: "torn", value: "value"}.encode()[:10])
+	file.Close()
+
+	engine = newTestEngine(t, directory)
+	defer engine.Close()
+
+	if value, ok := engine.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("expected value, got %v", value)
+	}
+
+	for _, key := range []string{"deleted", "torn"} {
+		if _, ok := engine.Get(ctx, key); ok {
+			t.Errorf("expected missing %v", key)
+		}
+	}
+
+	engine.Set(ctx, "after", "value")
+	if value, ok := engine.Get(ctx, "after"); !ok || value != "value" {
+		t.Errorf("expected value after recovery, got %v", value)
+	}
+}
+
+func TestEngineInterruptedMerge(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	engine := newTestEngine(t, directory)
+	engine.Set(ctx, "key", "old")
+	engine.Set(ctx, "key", "new")
+	engine.Set(ctx, "deleted", "value")
+
+	if err := engine.merge(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the merged copy of the value becomes dead right away, while the
+	// tombstone lives in the active file
+	engine.Del(ctx, "deleted")
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// an obsolete input left by a crash
+	if err := os.WriteFile(filepath.Join(directory, "00000000000000000001.data"), record{seq: 1, key: "key", value: "old"}.encode(), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, mergeMarkerName), []byte("1"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	engine = newTestEngine(t, directory)
+	defer engine.Close()
+
+	if value, _ := engine.Get(ctx, "key"); value != "new" {
+		t.Errorf("expected new, got %v", value)
+	}
+
+	if _, ok := engine.Get(ctx, "deleted"); ok {
+		t.Errorf("expected deleted key")
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, mergeMarkerName)); !os.IsNotExist(err) {
+		t.Errorf("expected merge marker to be removed")
+	}
+}
@@ -0,0 +1,105 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// hint is a keydir entry of a data file produced by a merge. Hint files
+// allow building the keydir without reading values from data files.
+type hint struct {
+	seq    uint64
+	key    string
+	offset int64
+	size   uint32
+}
+
+// writeHints writes hints to the path atomically.
+// Hint file layout: ([seq uint64][key length uint32][key][offset uint64][size uint32])*[crc32 uint32]
+func writeHints(path string, hints []hint) error {
+	file, err := os.OpenFile(path+tmpFileSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create hint file: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	checksum := crc32.New(crcTable)
+
+	var buffer []byte
+	for _, h := range hints {
+		buffer = binary.LittleEndian.AppendUint64(buffer[:0], h.seq)
+		buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(h.key)))
+		buffer = append(buffer, h.key...)
+		buffer = binary.LittleEndian.AppendUint64(buffer, uint64(h.offset))
+		buffer = binary.LittleEndian.AppendUint32(buffer, h.size)
+
+		checksum.Write(buffer)
+		if _, err := writer.Write(buffer); err != nil {
+			file.Close()
+			return fmt.Errorf("write hint file: %w", err)
+		}
+	}
+
+	err = binary.Write(writer, binary.LittleEndian, checksum.Sum32())
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("write hint file: %w", err)
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func readHints(path string) ([]hint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, errCorrupted
+	}
+
+	payload := data[:len(data)-4]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, errCorrupted
+	}
+
+	var hints []hint
+	for len(payload) > 0 {
+		if len(payload) < 12 {
+			return nil, errCorrupted
+		}
+
+		seq := binary.LittleEndian.Uint64(payload)
+		keyLength := binary.LittleEndian.Uint32(payload[8:])
+		payload = payload[12:]
+
+		if uint64(len(payload)) < uint64(keyLength)+12 {
+			return nil, errCorrupted
+		}
+
+		hints = append(hints, hint{
+			seq:    seq,
+			key:    string(payload[:keyLength]),
+			offset: int64(binary.LittleEndian.Uint64(payload[keyLength:])),
+			size:   binary.LittleEndian.Uint32(payload[keyLength+8:]),
+		})
+		payload = payload[keyLength+12:]
+	}
+
+	return hints, nil
+}
@@ -0,0 +1,252 @@
+package bitcask
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// mergedValue is a live value copied by a merge.
+type mergedValue struct {
+	key  string
+	from location
+	to   location
+}
+
+// mergeOutput is a data file written by a merge.
+type mergeOutput struct {
+	id    uint64
+	file  *os.File
+	size  int64
+	hints []hint
+}
+
+// merge rewrites live values of all data files but the active one, which is
+// rotated first, into new data files with hints, and removes the old files.
+// Tombstones are dropped, since values they shadow are dropped as well.
+func (e *Engine) merge() error {
+	e.mergeMutex.Lock()
+	defer e.mergeMutex.Unlock()
+
+	e.mutex.Lock()
+	if err := e.rotate(); err != nil {
+		e.mutex.Unlock()
+		return err
+	}
+
+	inputs := make(map[uint64]*dataFile)
+	for id, f := range e.files {
+		if f != e.active {
+			inputs[id] = f
+		}
+	}
+
+	var values []mergedValue
+	for key, loc := range e.keydir {
+		if _, ok := inputs[loc.fileID]; ok {
+			values = append(values, mergedValue{key: key, from: loc})
+		}
+	}
+	e.mutex.Unlock()
+
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	// read the input files sequentially
+	slices.SortFunc(values, func(a, b mergedValue) int {
+		return cmp.Or(cmp.Compare(a.from.fileID, b.from.fileID), cmp.Compare(a.from.offset, b.from.offset))
+	})
+
+	outputs, err := e.writeMergeOutputs(inputs, values)
+	if err != nil {
+		for _, output := range outputs {
+			output.file.Close()
+			os.Remove(e.dataFilePath(output.id))
+			os.Remove(e.hintFilePath(output.id))
+		}
+		return err
+	}
+
+	inputIDs := make([]uint64, 0, len(inputs))
+	for id := range inputs {
+		inputIDs = append(inputIDs, id)
+	}
+
+	// from now on the inputs are obsolete, the marker makes sure they are
+	// removed even if the process crashes while removing them
+	if err := e.writeMergeMarker(inputIDs); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	for _, output := range outputs {
+		e.files[output.id] = &dataFile{id: output.id, file: output.file, size: output.size}
+	}
+
+	for _, value := range values {
+		if e.keydir[value.key] == value.from {
+			e.keydir[value.key] = value.to
+		} else {
+			// overwritten or deleted during the merge
+			e.files[value.to.fileID].dead += int64(value.to.size)
+		}
+	}
+
+	for id := range inputs {
+		delete(e.files, id)
+	}
+	e.mutex.Unlock()
+
+	for _, f := range inputs {
+		f.file.Close()
+	}
+
+	if err := e.finishMerge(); err != nil {
+		return err
+	}
+
+	e.logger.Debug("data files merged", zap.Int("inputs", len(inputs)), zap.Int("outputs", len(outputs)), zap.Int("values", len(values)))
+
+	return nil
+}
+
+func (e *Engine) writeMergeOutputs(inputs map[uint64]*dataFile, values []mergedValue) ([]*mergeOutput, error) {
+	var outputs []*mergeOutput
+	var output *mergeOutput
+
+	for i := range values {
+		value := &values[i]
+
+		if output == nil || output.size >= e.maxFileSize {
+			if err := e.finishMergeOutput(output); err != nil {
+				return outputs, err
+			}
+
+			var err error
+			if output, err = e.createMergeOutput(); err != nil {
+				return outputs, err
+			}
+			outputs = append(outputs, output)
+		}
+
+		data := make([]byte, value.from.size)
+		if _, err := inputs[value.from.fileID].file.ReadAt(data, value.from.offset); err != nil {
+			return outputs, fmt.Errorf("read data file: %w", err)
+		}
+
+		if _, err := output.file.Write(data); err != nil {
+			return outputs, fmt.Errorf("write data file: %w", err)
+		}
+
+		value.to = location{
+			fileID: output.id,
+			offset: output.size,
+			size:   value.from.size,
+			seq:    value.from.seq,
+		}
+		output.size += int64(value.from.size)
+		output.hints = append(output.hints, hint{key: value.key, seq: value.to.seq, offset: value.to.offset, size: value.to.size})
+	}
+
+	return outputs, e.finishMergeOutput(output)
+}
+
+func (e *Engine) createMergeOutput() (*mergeOutput, error) {
+	e.mutex.Lock()
+	id := e.allocateFileID()
+	e.mutex.Unlock()
+
+	file, err := os.OpenFile(e.dataFilePath(id)+tmpFileSuffix, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create data file: %w", err)
+	}
+
+	return &mergeOutput{id: id, file: file}, nil
+}
+
+// finishMergeOutput syncs the data file and writes its hints before giving
+// it the final name.
+func (e *Engine) finishMergeOutput(output *mergeOutput) error {
+	if output == nil {
+		return nil
+	}
+
+	if err := output.file.Sync(); err != nil {
+		return fmt.Errorf("sync data file: %w", err)
+	}
+
+	if err := writeHints(e.hintFilePath(output.id), output.hints); err != nil {
+		return err
+	}
+	output.hints = nil
+
+	if err := os.Rename(output.file.Name(), e.dataFilePath(output.id)); err != nil {
+		return fmt.Errorf("rename data file: %w", err)
+	}
+
+	return syncDirectory(e.directory)
+}
+
+func (e *Engine) writeMergeMarker(ids []uint64) error {
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, strconv.FormatUint(id, 10))
+	}
+
+	path := filepath.Join(e.directory, mergeMarkerName)
+	if err := os.WriteFile(path+tmpFileSuffix, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		return fmt.Errorf("write merge marker: %w", err)
+	}
+
+	if err := os.Rename(path+tmpFileSuffix, path); err != nil {
+		return fmt.Errorf("write merge marker: %w", err)
+	}
+
+	return syncDirectory(e.directory)
+}
+
+// finishMerge removes data and hint files listed by the merge marker.
+func (e *Engine) finishMerge() error {
+	path := filepath.Join(e.directory, mergeMarkerName)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read merge marker: %w", err)
+	}
+
+	for _, line := range strings.Fields(string(data)) {
+		id, err := strconv.ParseUint(line, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse merge marker: %w", err)
+		}
+
+		for _, file := range []string{e.dataFilePath(id), e.hintFilePath(id)} {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove merged file: %w", err)
+			}
+		}
+	}
+
+	return os.Remove(path)
+}
+
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
@@ -0,0 +1,140 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// recordHeaderSize is the size of [crc32 uint32][seq uint64][kind uint8][key length uint32][value length uint32].
+const recordHeaderSize = 4 + 8 + 1 + 4 + 4
+
+const (
+	kindValue     = 1
+	kindTombstone = 2
+)
+
+var (
+	errCorrupted = errors.New("corrupted record")
+	crcTable     = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// record is an entry of a data file. Records carry a sequence number, so the
+// latest version of a key is known regardless of the file it is found in.
+type record struct {
+	seq     uint64
+	key     string
+	value   string
+	deleted bool
+}
+
+func (r record) encode() []byte {
+	kind := byte(kindValue)
+	if r.deleted {
+		kind = kindTombstone
+	}
+
+	data := make([]byte, 4, recordHeaderSize+len(r.key)+len(r.value))
+	data = binary.LittleEndian.AppendUint64(data, r.seq)
+	data = append(data, kind)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(r.key)))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(r.value)))
+	data = append(data, r.key...)
+	data = append(data, r.value...)
+
+	binary.LittleEndian.PutUint32(data, crc32.Checksum(data[4:], crcTable))
+
+	return data
+}
+
+// decodeRecord decodes a record encoded as a whole.
+func decodeRecord(data []byte) (record, error) {
+	if len(data) < recordHeaderSize {
+		return record{}, errCorrupted
+	}
+
+	keyLength := binary.LittleEndian.Uint32(data[13:])
+	valueLength := binary.LittleEndian.Uint32(data[17:])
+	if uint64(len(data)) != recordHeaderSize+uint64(keyLength)+uint64(valueLength) {
+		return record{}, errCorrupted
+	}
+
+	if crc32.Checksum(data[4:], crcTable) != binary.LittleEndian.Uint32(data) {
+		return record{}, errCorrupted
+	}
+
+	kind := data[12]
+	if kind != kindValue && kind != kindTombstone {
+		return record{}, errCorrupted
+	}
+
+	payload := data[recordHeaderSize:]
+
+	return record{
+		seq:     binary.LittleEndian.Uint64(data[4:]),
+		key:     string(payload[:keyLength]),
+		value:   string(payload[keyLength:]),
+		deleted: kind == kindTombstone,
+	}, nil
+}
+
+// readRecord reads the record of the given size at the offset of the file.
+func readRecord(file *os.File, offset int64, size uint32) (record, error) {
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return record{}, err
+	}
+
+	return decodeRecord(data)
+}
+
+// recordScanner reads records of a data file sequentially.
+type recordScanner struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func newRecordScanner(reader io.Reader) *recordScanner {
+	return &recordScanner{reader: bufio.NewReader(reader)}
+}
+
+// next returns the following record with its offset and size. It returns
+// io.EOF at the end of the file and errCorrupted for an incomplete or
+// damaged record, which is expected at the tail after a crash.
+func (s *recordScanner) next() (record, int64, uint32, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(s.reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return record{}, 0, 0, io.EOF
+		}
+
+		return record{}, 0, 0, errCorrupted
+	}
+
+	keyLength := binary.LittleEndian.Uint32(header[13:])
+	valueLength := binary.LittleEndian.Uint32(header[17:])
+
+	size := uint64(recordHeaderSize) + uint64(keyLength) + uint64(valueLength)
+	if size > 1<<32-1 {
+		return record{}, 0, 0, errCorrupted
+	}
+
+	data := make([]byte, size)
+	copy(data, header)
+	if _, err := io.ReadFull(s.reader, data[recordHeaderSize:]); err != nil {
+		return record{}, 0, 0, errCorrupted
+	}
+
+	r, err := decodeRecord(data)
+	if err != nil {
+		return record{}, 0, 0, err
+	}
+
+	offset := s.offset
+	s.offset += int64(size)
+
+	return r, offset, uint32(size), nil
+}
@@ -0,0 +1,345 @@
+package btree
+
+import (
+	"slices"
+	"strings"
+)
+
+// item is a key-value pair stored in the tree.
+type item struct {
+	key   string
+	value string
+}
+
+// copyOnWrite identifies the tree allowed to modify a node in place. Nodes
+// owned by another tree are copied before modification, which makes Clone
+// cheap: both trees keep sharing the nodes untouched since the clone.
+type copyOnWrite struct {
+	// id prevents distinct contexts from sharing an address.
+	id int
+}
+
+type node struct {
+	items    []item
+	children []*node
+	cow      *copyOnWrite
+}
+
+// BTree is a B-tree of string keys ordered lexicographically.
+// It is not safe for concurrent use.
+type BTree struct {
+	degree int
+	length int
+	root   *node
+	cow    *copyOnWrite
+}
+
+// New creates a tree whose nodes hold between degree-1 and 2*degree-1 items.
+func New(degree int) *BTree {
+	return &BTree{
+		degree: max(degree, 2),
+		cow:    &copyOnWrite{},
+	}
+}
+
+func (t *BTree) maxItems() int {
+	return 2*t.degree - 1
+}
+
+func (t *BTree) minItems() int {
+	return t.degree - 1
+}
+
+// Len returns the number of keys in the tree.
+func (t *BTree) Len() int {
+	return t.length
+}
+
+// Clone returns a copy of the tree. Both trees may be modified independently,
+// nodes are copied lazily on the first modification.
+func (t *BTree) Clone() *BTree {
+	first, second := *t.cow, *t.cow
+	clone := *t
+	t.cow = &first
+	clone.cow = &second
+
+	return &clone
+}
+
+func (t *BTree) Get(key string) (string, bool) {
+	for n := t.root; n != nil; {
+		i, found := n.find(key)
+		if found {
+			return n.items[i].value, true
+		}
+
+		if len(n.children) == 0 {
+			break
+		}
+
+		n = n.children[i]
+	}
+
+	return "", false
+}
+
+// Set inserts or replaces the value of the key.
+func (t *BTree) Set(key, value string) {
+	it := item{key: key, value: value}
+
+	if t.root == nil {
+		t.root = t.newNode()
+		t.root.items = append(t.root.items, it)
+		t.length++
+		return
+	}
+
+	t.root = t.root.mutableFor(t.cow)
+	if len(t.root.items) >= t.maxItems() {
+		middle, second := t.root.split(t.maxItems() / 2)
+		first := t.root
+
+		t.root = t.newNode()
+		t.root.items = append(t.root.items, middle)
+		t.root.children = append(t.root.children, first, second)
+	}
+
+	if !t.root.insert(it, t.maxItems()) {
+		t.length++
+	}
+}
+
+// Delete removes the key and reports whether it was present.
+func (t *BTree) Delete(key string) bool {
+	if t.root == nil || len(t.root.items) == 0 {
+		return false
+	}
+
+	t.root = t.root.mutableFor(t.cow)
+	removed := t.root.remove(key, t.minItems())
+
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+
+	if removed {
+		t.length--
+	}
+
+	return removed
+}
+
+// Ascend calls fn for pairs with keys in [start, end) in ascending order until
+// fn returns false. An empty end means no upper bound.
+func (t *BTree) Ascend(start, end string, fn func(key, value string) bool) {
+	if t.root != nil {
+		t.root.ascend(start, end, fn)
+	}
+}
+
+func (t *BTree) newNode() *node {
+	return &node{cow: t.cow}
+}
+
+// find returns the index of the key in the node or the index of the child
+// subtree holding it.
+func (n *node) find(key string) (int, bool) {
+	return slices.BinarySearchFunc(n.items, key, func(it item, key string) int {
+		return strings.Compare(it.key, key)
+	})
+}
+
+// mutableFor returns the node itself if it is owned by cow or its copy otherwise.
+func (n *node) mutableFor(cow *copyOnWrite) *node {
+	if n.cow == cow {
+		return n
+	}
+
+	return &node{
+		items:    slices.Clone(n.items),
+		children: slices.Clone(n.children),
+		cow:      cow,
+	}
+}
+
+func (n *node) mutableChild(i int) *node {
+	child := n.children[i].mutableFor(n.cow)
+	n.children[i] = child
+	return child
+}
+
+// split moves items after index i with their children into a new node and
+// returns the item at index i.
+func (n *node) split(i int) (item, *node) {
+	middle := n.items[i]
+
+	next := &node{cow: n.cow}
+	next.items = append(next.items, n.items[i+1:]...)
+	clear(n.items[i:])
+	n.items = n.items[:i]
+
+	if len(n.children) > 0 {
+		next.children = append(next.children, n.children[i+1:]...)
+		clear(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+
+	return middle, next
+}
+
+// maybeSplitChild splits the child at index i if it is full,
+// it reports whether the split happened.
+func (n *node) maybeSplitChild(i, maxItems int) bool {
+	if len(n.children[i].items) < maxItems {
+		return false
+	}
+
+	first := n.mutableChild(i)
+	middle, second := first.split(maxItems / 2)
+	n.items = slices.Insert(n.items, i, middle)
+	n.children = slices.Insert(n.children, i+1, second)
+
+	return true
+}
+
+// insert adds the item to the subtree, which root must not be full.
+// It reports whether an existing item was replaced.
+func (n *node) insert(it item, maxItems int) bool {
+	i, found := n.find(it.key)
+	if found {
+		n.items[i] = it
+		return true
+	}
+
+	if len(n.children) == 0 {
+		n.items = slices.Insert(n.items, i, it)
+		return false
+	}
+
+	if n.maybeSplitChild(i, maxItems) {
+		switch middle := n.items[i]; {
+		case it.key == middle.key:
+			n.items[i] = it
+			return true
+		case it.key > middle.key:
+			i++
+		}
+	}
+
+	return n.mutableChild(i).insert(it, maxItems)
+}
+
+// remove deletes the key from the subtree. Every visited child is ensured to
+// have more than minItems items before descending so that removal never
+// leaves a node underfull.
+func (n *node) remove(key string, minItems int) bool {
+	i, found := n.find(key)
+
+	if len(n.children) == 0 {
+		if found {
+			n.items = slices.Delete(n.items, i, i+1)
+		}
+
+		return found
+	}
+
+	if len(n.children[i].items) <= minItems {
+		n.growChild(i, minItems)
+		return n.remove(key, minItems)
+	}
+
+	child := n.mutableChild(i)
+	if found {
+		n.items[i] = child.removeMax(minItems)
+		return true
+	}
+
+	return child.remove(key, minItems)
+}
+
+// removeMax deletes and returns the greatest item of the subtree.
+func (n *node) removeMax(minItems int) item {
+	if len(n.children) == 0 {
+		last := n.items[len(n.items)-1]
+		n.items = slices.Delete(n.items, len(n.items)-1, len(n.items))
+		return last
+	}
+
+	i := len(n.children) - 1
+	if len(n.children[i].items) <= minItems {
+		n.growChild(i, minItems)
+		return n.removeMax(minItems)
+	}
+
+	return n.mutableChild(i).removeMax(minItems)
+}
+
+// growChild gives the child at index i an extra item by borrowing one from
+// a sibling or merging it with a sibling.
+func (n *node) growChild(i, minItems int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minItems:
+		child := n.mutableChild(i)
+		left := n.mutableChild(i - 1)
+
+		child.items = slices.Insert(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = slices.Delete(left.items, len(left.items)-1, len(left.items))
+
+		if len(left.children) > 0 {
+			child.children = slices.Insert(child.children, 0, left.children[len(left.children)-1])
+			left.children = slices.Delete(left.children, len(left.children)-1, len(left.children))
+		}
+	case i < len(n.items) && len(n.children[i+1].items) > minItems:
+		child := n.mutableChild(i)
+		right := n.mutableChild(i + 1)
+
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = slices.Delete(right.items, 0, 1)
+
+		if len(right.children) > 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = slices.Delete(right.children, 0, 1)
+		}
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+
+		child := n.mutableChild(i)
+		right := n.children[i+1]
+
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+
+		n.items = slices.Delete(n.items, i, i+1)
+		n.children = slices.Delete(n.children, i+1, i+2)
+	}
+}
+
+func (n *node) ascend(start, end string, fn func(key, value string) bool) bool {
+	i, _ := n.find(start)
+
+	for ; i < len(n.items); i++ {
+		if len(n.children) > 0 && !n.children[i].ascend(start, end, fn) {
+			return false
+		}
+
+		it := n.items[i]
+		if end != "" && it.key >= end {
+			return false
+		}
+
+		if !fn(it.key, it.value) {
+			return false
+		}
+	}
+
+	if len(n.children) > 0 {
+		return n.children[len(n.children)-1].ascend(start, end, fn)
+	}
+
+	return true
+}
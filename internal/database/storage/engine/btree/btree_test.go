@@ -0,0 +1,145 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func keys(tree *BTree, start, end string) []string {
+	var result []string
+	tree.Ascend(start, end, func(key, value string) bool {
+		result = append(result, key)
+		return true
+	})
+
+	return result
+}
+
+func TestBTreeRandomOperations(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+
+	for _, degree := range []int{2, 3, 32} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			tree := New(degree)
+			expected := make(map[string]string)
+
+			for i := range 20_000 {
+				key := strconv.Itoa(random.IntN(2_000))
+
+				if random.IntN(3) == 0 {
+					_, exists := expected[key]
+					if removed := tree.Delete(key); removed != exists {
+						t.Fatalf("expected removed %v for %v, got %v", exists, key, removed)
+					}
+					delete(expected, key)
+				} else {
+					tree.Set(key, strconv.Itoa(i))
+					expected[key] = strconv.Itoa(i)
+				}
+			}
+
+			if tree.Len() != len(expected) {
+				t.Errorf("expected %v keys, got %v", len(expected), tree.Len())
+			}
+
+			for key, value := range expected {
+				if actual, ok := tree.Get(key); !ok || actual != value {
+					t.Errorf("expected %v for %v, got %v", value, key, actual)
+				}
+			}
+
+			sorted := make([]string, 0, len(expected))
+			for key := range expected {
+				sorted = append(sorted, key)
+			}
+			slices.Sort(sorted)
+
+			if actual := keys(tree, "", ""); !slices.Equal(actual, sorted) {
+				t.Errorf("expected keys in order")
+			}
+		})
+	}
+}
+
+func TestBTreeAscend(t *testing.T) {
+	tree := New(2)
+	for _, key := range []string{"event:3", "event:1", "user:1", "event:2", "a"} {
+		tree.Set(key, key)
+	}
+
+	tests := map[string]struct {
+		start    string
+		end      string
+		expected []string
+	}{
+		"all keys": {
+			expected: []string{"a", "event:1", "event:2", "event:3", "user:1"},
+		},
+		"half-open range": {
+			start:    "event:1",
+			end:      "event:3",
+			expected: []string{"event:1", "event:2"},
+		},
+		"start between keys": {
+			start:    "event:15",
+			expected: []string{"event:2", "event:3", "user:1"},
+		},
+		"empty range": {
+			start: "b",
+			end:   "c",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := keys(tree, test.start, test.end); !slices.Equal(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("stop iteration", func(t *testing.T) {
+		visited := 0
+		tree.Ascend("", "", func(key, value string) bool {
+			visited++
+			return visited < 2
+		})
+
+		if visited != 2 {
+			t.Errorf("expected 2 visited keys, got %v", visited)
+		}
+	})
+}
+
+func TestBTreeClone(t *testing.T) {
+	tree := New(2)
+	for i := range 100 {
+		tree.Set(strconv.Itoa(i), "before")
+	}
+
+	clone := tree.Clone()
+
+	for i := range 50 {
+		tree.Set(strconv.Itoa(i), "after")
+		tree.Delete(strconv.Itoa(i + 50))
+	}
+	clone.Set("100", "clone")
+
+	if tree.Len() != 50 || clone.Len() != 101 {
+		t.Fatalf("unexpected lengths %v and %v", tree.Len(), clone.Len())
+	}
+
+	clone.Ascend("", "", func(key, value string) bool {
+		if key != "100" && value != "before" {
+			t.Errorf("expected clone unaffected, got %v for %v", value, key)
+		}
+		return true
+	})
+
+	if _, ok := tree.Get("100"); ok {
+		t.Errorf("expected tree unaffected by clone writes")
+	}
+}
@@ -0,0 +1,27 @@
+package btree
+
+import (
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"go.uber.org/zap"
+)
+
+const EngineType = "btree"
+
+// Config is the engine.btree configuration section
+type Config struct {
+	Degree int `yaml:"degree" env:"KVDB_ENGINE_BTREE_DEGREE" env-description:"Minimum degree of the B-tree nodes" env-default:"32"`
+}
+
+func init() {
+	engine.Register(EngineType, newFromConfig)
+}
+
+func newFromConfig(logger *zap.Logger, config engine.Config) (storage.Engine, error) {
+	var cfg Config
+	if err := config.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	return NewEngine(logger, WithDegree(cfg.Degree)), nil
+}
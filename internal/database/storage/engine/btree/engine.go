@@ -0,0 +1,121 @@
+package btree
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"go.uber.org/zap"
+)
+
+// Engine keeps keys in a B-tree, which allows iterating them in lexicographical
+// order. Snapshots are taken by cloning the tree, so they cost nothing until
+// the keys captured by them are modified.
+type Engine struct {
+	mutex  sync.RWMutex
+	tree   *BTree
+	logger *zap.Logger
+
+	degree int
+}
+
+func NewEngine(logger *zap.Logger, options ...EngineOption) *Engine {
+	engine := &Engine{
+		logger: logger,
+		degree: 32,
+	}
+
+	for _, opt := range options {
+		opt(engine)
+	}
+
+	engine.tree = New(engine.degree)
+
+	return engine
+}
+
+func (e *Engine) Get(ctx context.Context, key string) (string, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.tree.Get(key)
+}
+
+func (e *Engine) Set(ctx context.Context, key, value string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.tree.Set(key, value)
+}
+
+func (e *Engine) Del(ctx context.Context, key string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.tree.Delete(key)
+}
+
+func (e *Engine) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, err := fn(e.tree.Get(key))
+	if err != nil {
+		return err
+	}
+
+	e.tree.Set(key, value)
+
+	return nil
+}
+
+func (e *Engine) GetDel(ctx context.Context, key string) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, found := e.tree.Get(key)
+	if found {
+		e.tree.Delete(key)
+	}
+
+	return value, found
+}
+
+// Ascend calls fn for pairs with keys in [start, end) in ascending order until
+// fn returns false. An empty end means no upper bound. Writes are blocked
+// while fn is being called.
+func (e *Engine) Ascend(ctx context.Context, start, end string, fn func(key, value string) bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	e.tree.Ascend(start, end, fn)
+}
+
+// Snapshot captures the current state of the engine.
+func (e *Engine) Snapshot(ctx context.Context) (storage.EngineSnapshot, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return &Snapshot{tree: e.tree.Clone()}, nil
+}
+
+// Snapshot is a point-in-time copy of the engine, unaffected by later writes.
+type Snapshot struct {
+	tree *BTree
+}
+
+// ForEach calls fn for every pair of the snapshot in key order.
+func (s *Snapshot) ForEach(fn func(key string, value storage.Value, expiresAt int64) error) error {
+	var err error
+	s.tree.Ascend("", "", func(key, value string) bool {
+		err = fn(key, storage.NewString(value), 0)
+		return err == nil
+	})
+
+	return err
+}
+
+// Release drops the nodes retained by the snapshot.
+func (s *Snapshot) Release() {
+	s.tree = New(s.tree.degree)
+}
@@ -0,0 +1,11 @@
+package btree
+
+type EngineOption func(*Engine)
+
+// WithDegree sets the minimum degree of the tree, its nodes hold between
+// degree-1 and 2*degree-1 keys.
+func WithDegree(degree int) EngineOption {
+	return func(e *Engine) {
+		e.degree = degree
+	}
+}
@@ -0,0 +1,39 @@
+package inmemory
+
+import (
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"go.uber.org/zap"
+)
+
+const EngineType = "in_memory"
+
+// Config is the engine.in_memory configuration section
+type Config struct {
+	Shards         int    `yaml:"shards" env:"KVDB_ENGINE_SHARDS" env-description:"Number of partitions of the in-memory keyspace" env-default:"16"`
+	MaxMemory      int64  `yaml:"max-memory" env:"KVDB_ENGINE_MAX_MEMORY" env-description:"Memory limit in bytes, 0 means no limit" env-default:"0"`
+	EvictionPolicy string `yaml:"eviction-policy" env:"KVDB_ENGINE_EVICTION_POLICY" env-description:"Eviction policy: noeviction, allkeys-lru, allkeys-lfu, volatile-ttl or allkeys-random" env-default:"noeviction"`
+}
+
+func init() {
+	engine.Register(EngineType, newFromConfig)
+}
+
+func newFromConfig(logger *zap.Logger, config engine.Config) (storage.Engine, error) {
+	var cfg Config
+	if err := config.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	evictionPolicy, err := ParseEvictionPolicy(cfg.EvictionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEngine(
+		logger,
+		WithShards(cfg.Shards),
+		WithMaxMemory(cfg.MaxMemory),
+		WithEvictionPolicy(evictionPolicy),
+	), nil
+}
@@ -2,30 +2,281 @@ package inmemory
 
 import (
 	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
 	"go.uber.org/zap"
 )
 
+const (
+	// sweepSampleSize is the number of keys with an expiration examined per sweep batch.
+	sweepSampleSize = 20
+	// sweepTimeBudget bounds the time a single sweep cycle may take.
+	sweepTimeBudget = 5 * time.Millisecond
+)
+
+// Engine partitions the keyspace between shards selected by a key hash,
+// each shard being a HashTable guarded by its own lock.
 type Engine struct {
-	hashTable *HashTable
-	logger    *zap.Logger
+	shards []*HashTable
+	logger *zap.Logger
+
+	shardsNumber   int
+	maxMemory      int64
+	evictionPolicy EvictionPolicy
+	sweepInterval  time.Duration
+	sweepCursor    int
+	done           chan struct{}
+	closeOnce      sync.Once
+	wg             sync.WaitGroup
 }
 
-func NewEngine(logger *zap.Logger) *Engine {
-	return &Engine{
-		hashTable: NewHashTable(),
-		logger:    logger,
+func NewEngine(logger *zap.Logger, options ...EngineOption) *Engine {
+	engine := &Engine{
+		logger:         logger,
+		shardsNumber:   1,
+		evictionPolicy: EvictionNoEviction,
+		sweepInterval:  100 * time.Millisecond,
+		done:           make(chan struct{}),
 	}
+
+	for _, opt := range options {
+		opt(engine)
+	}
+
+	engine.shards = make([]*HashTable, max(engine.shardsNumber, 1))
+	for i := range engine.shards {
+		engine.shards[i] = NewHashTable()
+	}
+
+	if engine.sweepInterval > 0 {
+		engine.wg.Add(1)
+		go engine.sweepPeriodically()
+	}
+
+	return engine
 }
 
 func (e *Engine) Get(ctx context.Context, key string) (string, bool) {
-	return e.hashTable.Get(key)
+	return e.shard(key).Get(key)
 }
 
 func (e *Engine) Set(ctx context.Context, key, value string) {
-	e.hashTable.Set(key, value)
+	e.shard(key).Set(key, value)
 }
 
 func (e *Engine) Del(ctx context.Context, key string) {
-	e.hashTable.Del(key)
+	e.shard(key).Del(key)
+}
+
+func (e *Engine) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	return e.shard(key).Update(key, fn)
+}
+
+func (e *Engine) GetDel(ctx context.Context, key string) (string, bool) {
+	return e.shard(key).GetDel(key)
+}
+
+func (e *Engine) View(ctx context.Context, key string, fn func(value *storage.Value)) bool {
+	return e.shard(key).View(key, fn)
+}
+
+func (e *Engine) Modify(ctx context.Context, key string, fn func(value *storage.Value, found bool) error) error {
+	return e.shard(key).Modify(key, fn)
+}
+
+func (e *Engine) SetValue(ctx context.Context, key string, value storage.Value, expiresAt time.Time) {
+	e.shard(key).SetValue(key, value, unixMilli(expiresAt))
+}
+
+func (e *Engine) SetWithExpiration(ctx context.Context, key, value string, expiresAt time.Time) {
+	e.shard(key).SetWithExpiration(key, value, unixMilli(expiresAt))
+}
+
+func (e *Engine) Expire(ctx context.Context, key string, expiresAt time.Time) bool {
+	return e.shard(key).Expire(key, unixMilli(expiresAt))
+}
+
+func (e *Engine) Persist(ctx context.Context, key string) bool {
+	return e.shard(key).Persist(key)
+}
+
+func (e *Engine) Version(ctx context.Context, key string) uint64 {
+	return e.shard(key).Version(key)
+}
+
+func (e *Engine) TTL(ctx context.Context, key string) (time.Duration, bool) {
+	expiresAt, ok := e.shard(key).Expiration(key)
+	if !ok {
+		return 0, false
+	}
+
+	if expiresAt == 0 {
+		return storage.NoExpiration, true
+	}
+
+	return time.Until(time.UnixMilli(expiresAt)), true
+}
+
+// UsedMemory returns the estimated memory occupied by keys and values in bytes.
+func (e *Engine) UsedMemory() int64 {
+	var used int64
+	for _, shard := range e.shards {
+		used += shard.UsedMemory()
+	}
+
+	return used
+}
+
+// MemoryExceeded reports whether the memory limit is set and reached.
+func (e *Engine) MemoryExceeded() bool {
+	return e.maxMemory > 0 && e.UsedMemory() >= e.maxMemory
+}
+
+// EvictionCandidate returns a key to evict according to the eviction policy
+// by sampling a few keys of a random shard, so the cost does not depend on
+// the number of stored keys.
+func (e *Engine) EvictionCandidate(ctx context.Context) (string, bool) {
+	if e.evictionPolicy == EvictionNoEviction {
+		return "", false
+	}
+
+	start := rand.IntN(len(e.shards))
+	for i := range e.shards {
+		shard := e.shards[(start+i)%len(e.shards)]
+		if key, ok := shard.EvictionCandidate(e.evictionPolicy, evictionSampleSize); ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// Snapshot starts snapshots of all shards while holding all of their locks,
+// so together they observe the same point in time.
+func (e *Engine) Snapshot(ctx context.Context) (storage.EngineSnapshot, error) {
+	for _, shard := range e.shards {
+		shard.mutex.Lock()
+	}
+	defer func() {
+		for _, shard := range e.shards {
+			shard.mutex.Unlock()
+		}
+	}()
+
+	snapshot := make(shardedSnapshot, 0, len(e.shards))
+	for _, shard := range e.shards {
+		shardSnapshot, err := shard.snapshotLocked()
+		if err != nil {
+			for _, started := range snapshot {
+				started.releaseLocked()
+			}
+			return nil, err
+		}
+
+		snapshot = append(snapshot, shardSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+// Close stops the background expiration sweeper.
+func (e *Engine) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.done)
+	})
+	e.wg.Wait()
+
+	return nil
+}
+
+func (e *Engine) sweepPeriodically() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			e.sweepExpired()
+		}
+	}
+}
+
+// sweepExpired deletes expired keys in small batches. Like an adaptive
+// sampler it keeps going through a shard while more than a quarter of the
+// sampled keys turn out to be expired, but never longer than the time budget.
+// Each cycle starts from the shard following the last visited one, so shards
+// are swept evenly even when the budget runs out early.
+func (e *Engine) sweepExpired() {
+	var (
+		started = time.Now()
+		total   int
+	)
+
+	for visited := 0; visited < len(e.shards) && time.Since(started) < sweepTimeBudget; visited++ {
+		shard := e.shards[e.sweepCursor]
+		e.sweepCursor = (e.sweepCursor + 1) % len(e.shards)
+
+		for time.Since(started) < sweepTimeBudget {
+			examined, deleted := shard.DeleteExpired(sweepSampleSize)
+			total += deleted
+
+			if examined == 0 || deleted*4 < examined {
+				break
+			}
+		}
+	}
+
+	if total > 0 {
+		e.logger.Debug("expired keys swept", zap.Int("deleted", total), zap.Duration("took", time.Since(started)))
+	}
+}
+
+// unixMilli converts the deadline keeping zero time as "no expiration".
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+// shard selects the shard of the key by its FNV-1a hash.
+func (e *Engine) shard(key string) *HashTable {
+	if len(e.shards) == 1 {
+		return e.shards[0]
+	}
+
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return e.shards[hash%uint32(len(e.shards))]
+}
+
+// shardedSnapshot combines simultaneously started snapshots of all shards.
+type shardedSnapshot []*Snapshot
+
+func (s shardedSnapshot) ForEach(fn func(key string, value storage.Value, expiresAt int64) error) error {
+	for _, snapshot := range s {
+		if err := snapshot.ForEach(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s shardedSnapshot) Release() {
+	for _, snapshot := range s {
+		snapshot.Release()
+	}
 }
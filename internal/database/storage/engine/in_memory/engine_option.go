@@ -0,0 +1,34 @@
+package inmemory
+
+import "time"
+
+type EngineOption func(*Engine)
+
+// WithSweepInterval sets how often expired keys are actively reclaimed,
+// zero disables the background sweeper leaving only lazy expiration.
+func WithSweepInterval(interval time.Duration) EngineOption {
+	return func(e *Engine) {
+		e.sweepInterval = interval
+	}
+}
+
+// WithShards sets the number of partitions the keyspace is split into.
+func WithShards(number int) EngineOption {
+	return func(e *Engine) {
+		e.shardsNumber = number
+	}
+}
+
+// WithMaxMemory limits the estimated memory used by keys and values in bytes,
+// zero means no limit.
+func WithMaxMemory(bytes int64) EngineOption {
+	return func(e *Engine) {
+		e.maxMemory = bytes
+	}
+}
+
+func WithEvictionPolicy(policy EvictionPolicy) EngineOption {
+	return func(e *Engine) {
+		e.evictionPolicy = policy
+	}
+}
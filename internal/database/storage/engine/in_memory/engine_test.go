@@ -0,0 +1,216 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"go.uber.org/zap"
+)
+
+func TestEngineShards(t *testing.T) {
+	ctx := context.Background()
+
+	engine := NewEngine(zap.NewNop(), WithShards(8), WithSweepInterval(0))
+	defer engine.Close()
+
+	for i := range 1000 {
+		engine.Set(ctx, strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	for _, shard := range engine.shards {
+		if len(shard.data) == 0 {
+			t.Errorf("expected keys to be spread across all shards")
+		}
+	}
+
+	for i := range 1000 {
+		value, ok := engine.Get(ctx, strconv.Itoa(i))
+		if !ok || value != strconv.Itoa(i) {
+			t.Errorf("expected %v, got %v", i, value)
+		}
+	}
+
+	engine.Del(ctx, "42")
+	if _, ok := engine.Get(ctx, "42"); ok {
+		t.Errorf("expected deleted key")
+	}
+}
+
+func TestEngineSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	engine := NewEngine(zap.NewNop(), WithShards(4), WithSweepInterval(0))
+	defer engine.Close()
+
+	for i := range 100 {
+		engine.Set(ctx, strconv.Itoa(i), "before")
+	}
+
+	snapshot, err := engine.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := engine.Snapshot(ctx); err != errSnapshotInProgress {
+		t.Errorf("expected %v, got %v", errSnapshotInProgress, err)
+	}
+
+	for i := range 200 {
+		engine.Set(ctx, strconv.Itoa(i), "after")
+	}
+
+	count := 0
+	err = snapshot.ForEach(func(key string, value storage.Value, expiresAt int64) error {
+		if value.Str() != "before" {
+			t.Errorf("expected value as of snapshot start, got %v for %v", value, key)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if count != 100 {
+		t.Errorf("expected 100 pairs, got %v", count)
+	}
+
+	snapshot.Release()
+
+	if _, err := engine.Snapshot(ctx); err != nil {
+		t.Errorf("expected snapshot after release, got %v", err)
+	}
+}
+
+// BenchmarkEngineParallel compares throughput under parallel mixed load.
+// A single shard is equivalent to one HashTable guarded by a global lock.
+func BenchmarkEngineParallel(b *testing.B) {
+	const keysNumber = 100_000
+
+	keys := make([]string, keysNumber)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+
+	for _, writePercent := range []int{10, 50} {
+		for _, shards := range []int{1, 16, 64} {
+			name := fmt.Sprintf("writes=%d%%/shards=%d", writePercent, shards)
+			b.Run(name, func(b *testing.B) {
+				ctx := context.Background()
+
+				engine := NewEngine(zap.NewNop(), WithShards(shards), WithSweepInterval(0))
+				defer engine.Close()
+
+				for _, key := range keys {
+					engine.Set(ctx, key, "value")
+				}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+
+					for pb.Next() {
+						key := keys[random.IntN(keysNumber)]
+						if random.IntN(100) < writePercent {
+							engine.Set(ctx, key, "value")
+						} else {
+							engine.Get(ctx, key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestEngineEviction(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("noeviction", func(t *testing.T) {
+		engine := NewEngine(zap.NewNop(), WithMaxMemory(1), WithSweepInterval(0))
+		defer engine.Close()
+
+		if engine.MemoryExceeded() {
+			t.Errorf("expected empty engine to fit the limit")
+		}
+
+		engine.Set(ctx, "key", "value")
+
+		if !engine.MemoryExceeded() {
+			t.Errorf("expected memory limit to be exceeded")
+		}
+
+		if key, ok := engine.EvictionCandidate(ctx); ok {
+			t.Errorf("expected no eviction candidate, got %v", key)
+		}
+	})
+
+	t.Run("allkeys-lru", func(t *testing.T) {
+		engine := NewEngine(zap.NewNop(), WithEvictionPolicy(EvictionAllKeysLRU), WithSweepInterval(0))
+		defer engine.Close()
+
+		now := time.UnixMilli(1_000_000)
+		engine.shards[0].clock = func() time.Time { return now }
+
+		for _, key := range []string{"a", "b", "c"} {
+			engine.Set(ctx, key, "value")
+			now = now.Add(time.Second)
+		}
+		engine.Get(ctx, "a")
+
+		if key, _ := engine.EvictionCandidate(ctx); key != "b" {
+			t.Errorf("expected b, got %v", key)
+		}
+	})
+
+	t.Run("allkeys-lfu", func(t *testing.T) {
+		engine := NewEngine(zap.NewNop(), WithEvictionPolicy(EvictionAllKeysLFU), WithSweepInterval(0))
+		defer engine.Close()
+
+		engine.Set(ctx, "hot", "value")
+		engine.Set(ctx, "cold", "value")
+		for range 100 {
+			engine.Get(ctx, "hot")
+		}
+
+		if key, _ := engine.EvictionCandidate(ctx); key != "cold" {
+			t.Errorf("expected cold, got %v", key)
+		}
+	})
+
+	t.Run("volatile-ttl", func(t *testing.T) {
+		engine := NewEngine(zap.NewNop(), WithEvictionPolicy(EvictionVolatileTTL), WithSweepInterval(0))
+		defer engine.Close()
+
+		engine.Set(ctx, "persistent", "value")
+		if key, ok := engine.EvictionCandidate(ctx); ok {
+			t.Errorf("expected no candidate without volatile keys, got %v", key)
+		}
+
+		engine.SetWithExpiration(ctx, "later", "value", time.Now().Add(time.Hour))
+		engine.SetWithExpiration(ctx, "sooner", "value", time.Now().Add(time.Minute))
+
+		if key, _ := engine.EvictionCandidate(ctx); key != "sooner" {
+			t.Errorf("expected sooner, got %v", key)
+		}
+	})
+
+	t.Run("memory accounting", func(t *testing.T) {
+		engine := NewEngine(zap.NewNop(), WithShards(4), WithSweepInterval(0))
+		defer engine.Close()
+
+		engine.Set(ctx, "key", "value")
+		engine.Set(ctx, "key", "longer value")
+		engine.Set(ctx, "other", "value")
+		engine.Del(ctx, "other")
+
+		if used := engine.UsedMemory(); used != entryOverhead+int64(len("key")+len("longer value")) {
+			t.Errorf("unexpected used memory %v", used)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package inmemory
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+)
+
+const (
+	// entryOverhead approximates the memory taken by the map slot and the entry itself.
+	entryOverhead = 96
+
+	// lfuInitialFrequency gives new keys a chance to collect hits before eviction.
+	lfuInitialFrequency = 5
+	lfuMaxFrequency     = 255
+	// lfuLogFactor slows down counter growth so that 255 stands for about a million hits.
+	lfuLogFactor = 10
+	// lfuDecayPeriod is the idle time after which the frequency counter is halved.
+	lfuDecayPeriod = time.Minute
+)
+
+// entry is a stored value along with access statistics used by the eviction
+// policies. Statistics are updated atomically under the read lock and are
+// approximate by design.
+type entry struct {
+	// value is never modified in place, except for the data of collections
+	// which is only accessed with the table locked.
+	value storage.Value
+	// version is the version of the table when the key was last modified.
+	version uint64
+
+	accessedAt atomic.Int64
+	counter    atomic.Uint32
+}
+
+func newEntry(value storage.Value, now time.Time) *entry {
+	e := &entry{value: value}
+	e.accessedAt.Store(now.UnixMilli())
+	e.counter.Store(lfuInitialFrequency)
+
+	return e
+}
+
+func (e *entry) size(key string) int64 {
+	return int64(entryOverhead+len(key)) + e.value.Size()
+}
+
+// touch records an access to the entry.
+func (e *entry) touch(now time.Time) {
+	counter := e.frequency(now)
+	if counter < lfuMaxFrequency {
+		// logarithmic counter: the higher it is, the less likely it grows
+		base := float64(counter - min(counter, lfuInitialFrequency))
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+
+	e.counter.Store(counter)
+	e.accessedAt.Store(now.UnixMilli())
+}
+
+// lastAccess returns the time of the last access in unix milliseconds.
+func (e *entry) lastAccess() int64 {
+	return e.accessedAt.Load()
+}
+
+// frequency returns the access frequency counter decayed by the idle time.
+func (e *entry) frequency(now time.Time) uint32 {
+	counter := e.counter.Load()
+
+	idle := now.Sub(time.UnixMilli(e.accessedAt.Load()))
+	for periods := idle / lfuDecayPeriod; periods > 0 && counter > 0; periods-- {
+		counter /= 2
+	}
+
+	return counter
+}
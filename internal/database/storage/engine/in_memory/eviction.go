@@ -0,0 +1,37 @@
+package inmemory
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EvictionPolicy decides which keys are removed once the memory limit is reached.
+type EvictionPolicy string
+
+const (
+	// EvictionNoEviction rejects writes when the memory limit is reached.
+	EvictionNoEviction EvictionPolicy = "noeviction"
+	// EvictionAllKeysLRU evicts the least recently used keys.
+	EvictionAllKeysLRU EvictionPolicy = "allkeys-lru"
+	// EvictionAllKeysLFU evicts the least frequently used keys.
+	EvictionAllKeysLFU EvictionPolicy = "allkeys-lfu"
+	// EvictionVolatileTTL evicts keys with the nearest expiration among keys having one.
+	EvictionVolatileTTL EvictionPolicy = "volatile-ttl"
+	// EvictionAllKeysRandom evicts random keys.
+	EvictionAllKeysRandom EvictionPolicy = "allkeys-random"
+)
+
+// evictionSampleSize is the number of keys examined to pick an eviction candidate.
+// Larger samples approximate the policies better at a higher CPU cost.
+const evictionSampleSize = 5
+
+var errInvalidEvictionPolicy = errors.New("invalid eviction policy")
+
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch EvictionPolicy(policy) {
+	case EvictionNoEviction, EvictionAllKeysLRU, EvictionAllKeysLFU, EvictionVolatileTTL, EvictionAllKeysRandom:
+		return EvictionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w: %q", errInvalidEvictionPolicy, policy)
+	}
+}
@@ -1,36 +1,542 @@
 package inmemory
 
-import "sync"
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+)
+
+// snapshotBatchSize bounds the number of keys visited under a single read lock
+// while a snapshot is being iterated.
+const snapshotBatchSize = 1024
+
+var errSnapshotInProgress = errors.New("snapshot already in progress")
 
 type HashTable struct {
 	mutex sync.RWMutex
-	data  map[string]string
+	data  map[string]*entry
+	// expires holds expiration deadlines in unix milliseconds of keys that have one.
+	expires  map[string]int64
+	snapshot *Snapshot
+	clock    func() time.Time
+	// usedMemory is an estimation of memory occupied by the stored keys and values.
+	usedMemory atomic.Int64
+	// version is incremented by every modification, see Version.
+	version uint64
+	// deletedVersion is the version of the last deletion of any key.
+	deletedVersion uint64
 }
 
 func NewHashTable() *HashTable {
 	return &HashTable{
-		data: make(map[string]string),
+		data:    make(map[string]*entry),
+		expires: make(map[string]int64),
+		clock:   time.Now,
 	}
 }
 
+// Set stores the value and clears any expiration of the key.
 func (h *HashTable) Set(key, value string) {
+	h.SetWithExpiration(key, value, 0)
+}
+
+// SetWithExpiration stores the value that expires at the deadline given in
+// unix milliseconds, zero deadline means the value never expires.
+func (h *HashTable) SetWithExpiration(key, value string, expiresAt int64) {
+	h.SetValue(key, storage.NewString(value), expiresAt)
+}
+
+// SetValue stores the value of any type that expires at the deadline given in
+// unix milliseconds, zero deadline means the value never expires.
+func (h *HashTable) SetValue(key string, value storage.Value, expiresAt int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.set(key, value, expiresAt)
+}
+
+// GetDel deletes the key and returns the value it had.
+func (h *HashTable) GetDel(key string) (string, bool) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	h.data[key] = value
+	if !h.exists(key) {
+		return "", false
+	}
+
+	value := h.data[key].value.Str()
+	h.delete(key)
+
+	return value, true
+}
+
+// Update replaces the string value of the key by the result of fn, keeping
+// its expiration. Missing and expired keys are passed as not found, keys of
+// other types fail with storage.ErrWrongType.
+func (h *HashTable) Update(key string, fn func(value string, found bool) (string, error)) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var current string
+	found := h.exists(key)
+	if found {
+		if h.data[key].value.Type() != storage.TypeString {
+			return storage.ErrWrongType
+		}
+		current = h.data[key].value.Str()
+	}
+
+	value, err := fn(current, found)
+	if err != nil {
+		return err
+	}
+
+	h.preserve(key)
+	h.store(key, newEntry(storage.NewString(value), h.clock()))
+
+	// an expired key not deleted yet is created again without expiration
+	if !found {
+		delete(h.expires, key)
+	}
+
+	return nil
 }
 
 func (h *HashTable) Get(key string) (string, bool) {
+	now := h.clock()
+
 	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+	e, ok := h.data[key]
+	expired := ok && h.expired(key, now.UnixMilli())
+	h.mutex.RUnlock()
+
+	if expired {
+		h.deleteIfExpired(key)
+		return "", false
+	}
+
+	if !ok || e.value.Type() != storage.TypeString {
+		return "", false
+	}
 
-	value, ok := h.data[key]
-	return value, ok
+	e.touch(now)
+
+	return e.value.Str(), true
+}
+
+// View calls fn with a copy of the value of the key sharing the data of
+// collections, which does not change until fn returns.
+func (h *HashTable) View(key string, fn func(value *storage.Value)) bool {
+	now := h.clock()
+
+	h.mutex.RLock()
+	e, ok := h.data[key]
+	expired := ok && h.expired(key, now.UnixMilli())
+	if ok && !expired {
+		value := e.value
+		fn(&value)
+	}
+	h.mutex.RUnlock()
+
+	if expired {
+		h.deleteIfExpired(key)
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	e.touch(now)
+
+	return true
+}
+
+// Modify changes the value of the key in place by fn, keeping its expiration.
+// Missing and expired keys are passed as not found empty strings, keys left
+// with an empty value are deleted.
+func (h *HashTable) Modify(key string, fn func(value *storage.Value, found bool) error) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var value storage.Value
+	found := h.exists(key)
+	if found {
+		value = h.data[key].value
+	}
+
+	// the data of collections is shared with the entry, it must be copied
+	// for a snapshot in progress before fn changes it
+	h.preserve(key)
+
+	if err := fn(&value, found); err != nil {
+		return err
+	}
+
+	if value.Empty() {
+		h.delete(key)
+		return nil
+	}
+
+	h.store(key, newEntry(value, h.clock()))
+
+	// an expired key not deleted yet is created again without expiration
+	if !found {
+		delete(h.expires, key)
+	}
+
+	return nil
+}
+
+// UsedMemory returns the estimated memory occupied by the table in bytes.
+func (h *HashTable) UsedMemory() int64 {
+	return h.usedMemory.Load()
 }
 
 func (h *HashTable) Del(key string) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	h.delete(key)
+}
+
+// Expire sets the expiration deadline of an existing key in unix milliseconds.
+// A deadline in the past deletes the key right away.
+func (h *HashTable) Expire(key string, expiresAt int64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.exists(key) {
+		return false
+	}
+
+	if expiresAt <= h.clock().UnixMilli() {
+		h.delete(key)
+		return true
+	}
+
+	h.preserve(key)
+	h.expires[key] = expiresAt
+	h.modified(key)
+
+	return true
+}
+
+// Persist removes the expiration of the key, it reports whether the key had one.
+func (h *HashTable) Persist(key string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.exists(key) {
+		return false
+	}
+
+	if _, ok := h.expires[key]; !ok {
+		return false
+	}
+
+	h.preserve(key)
+	delete(h.expires, key)
+	h.modified(key)
+
+	return true
+}
+
+// Version returns a number that changes whenever the key is modified, deleted
+// or expires. Missing keys share the version of the last deletion in the
+// table, so deleting any key changes it.
+func (h *HashTable) Version(key string) uint64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if e, ok := h.data[key]; ok && !h.expired(key, h.clock().UnixMilli()) {
+		return e.version
+	}
+
+	return h.deletedVersion
+}
+
+// Expiration returns the deadline of the key in unix milliseconds, zero when
+// the key never expires, and whether the key exists.
+func (h *HashTable) Expiration(key string) (int64, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if _, ok := h.data[key]; !ok || h.expired(key, h.clock().UnixMilli()) {
+		return 0, false
+	}
+
+	return h.expires[key], true
+}
+
+// DeleteExpired examines up to limit keys having an expiration and deletes the
+// expired ones. It returns the number of examined and deleted keys.
+func (h *HashTable) DeleteExpired(limit int) (int, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var (
+		now      = h.clock().UnixMilli()
+		examined int
+		deleted  int
+	)
+
+	// map iteration order is random, so every call samples different keys
+	for key, expiresAt := range h.expires {
+		if examined == limit {
+			break
+		}
+		examined++
+
+		if expiresAt <= now {
+			h.delete(key)
+			deleted++
+		}
+	}
+
+	return examined, deleted
+}
+
+// EvictionCandidate samples up to sampleSize keys and returns the one the
+// policy would evict first. It reports false when no key qualifies.
+func (h *HashTable) EvictionCandidate(policy EvictionPolicy, sampleSize int) (string, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var (
+		now       = h.clock()
+		candidate string
+		best      int64
+		found     bool
+		sampled   int
+	)
+
+	consider := func(key string, score int64) bool {
+		if !found || score < best {
+			candidate, best, found = key, score, true
+		}
+
+		sampled++
+		return sampled < sampleSize
+	}
+
+	switch policy {
+	case EvictionVolatileTTL:
+		for key, expiresAt := range h.expires {
+			if !consider(key, expiresAt) {
+				break
+			}
+		}
+	case EvictionAllKeysRandom:
+		for key := range h.data {
+			return key, true
+		}
+	case EvictionAllKeysLRU, EvictionAllKeysLFU:
+		for key, e := range h.data {
+			score := e.lastAccess()
+			if policy == EvictionAllKeysLFU {
+				score = int64(e.frequency(now))
+			}
+
+			if !consider(key, score) {
+				break
+			}
+		}
+	}
+
+	return candidate, found
+}
+
+// Snapshot captures the current key set and starts tracking the previous
+// values of keys modified afterwards, so the returned snapshot observes the
+// table as of this call while writers keep running.
+func (h *HashTable) Snapshot() (*Snapshot, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.snapshotLocked()
+}
+
+// snapshotLocked must be called with the write lock held.
+func (h *HashTable) snapshotLocked() (*Snapshot, error) {
+	if h.snapshot != nil {
+		return nil, errSnapshotInProgress
+	}
+
+	keys := make([]string, 0, len(h.data))
+	for key := range h.data {
+		keys = append(keys, key)
+	}
+
+	h.snapshot = &Snapshot{
+		table:     h,
+		keys:      keys,
+		preimages: make(map[string]preimage),
+	}
+
+	return h.snapshot, nil
+}
+
+func (h *HashTable) deleteIfExpired(key string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.expired(key, h.clock().UnixMilli()) {
+		h.delete(key)
+	}
+}
+
+// set must be called with the write lock held.
+func (h *HashTable) set(key string, value storage.Value, expiresAt int64) {
+	h.preserve(key)
+	h.store(key, newEntry(value, h.clock()))
+
+	if expiresAt == 0 {
+		delete(h.expires, key)
+	} else {
+		h.expires[key] = expiresAt
+	}
+}
+
+// store must be called with the write lock held.
+func (h *HashTable) store(key string, e *entry) {
+	if previous, ok := h.data[key]; ok {
+		h.usedMemory.Add(-previous.size(key))
+	}
+
+	h.version++
+	e.version = h.version
+
+	h.data[key] = e
+	h.usedMemory.Add(e.size(key))
+}
+
+// modified must be called with the write lock held after the expiration of
+// an existing key changes.
+func (h *HashTable) modified(key string) {
+	h.version++
+	h.data[key].version = h.version
+}
+
+// exists must be called with the write lock held, it deletes the key when it has expired.
+func (h *HashTable) exists(key string) bool {
+	if _, ok := h.data[key]; !ok {
+		return false
+	}
+
+	if h.expired(key, h.clock().UnixMilli()) {
+		h.delete(key)
+		return false
+	}
+
+	return true
+}
+
+// expired must be called with the lock held.
+func (h *HashTable) expired(key string, now int64) bool {
+	expiresAt, ok := h.expires[key]
+	return ok && expiresAt <= now
+}
+
+// delete must be called with the write lock held.
+func (h *HashTable) delete(key string) {
+	previous, ok := h.data[key]
+	if !ok {
+		return
+	}
+
+	h.preserve(key)
 	delete(h.data, key)
+	delete(h.expires, key)
+	h.usedMemory.Add(-previous.size(key))
+
+	h.version++
+	h.deletedVersion = h.version
+}
+
+// preserve must be called with the write lock held before the key is modified.
+func (h *HashTable) preserve(key string) {
+	if h.snapshot == nil {
+		return
+	}
+
+	if _, ok := h.snapshot.preimages[key]; ok {
+		return
+	}
+
+	p := preimage{expiresAt: h.expires[key]}
+	if e, ok := h.data[key]; ok {
+		p.value, p.exists = e.value.Clone(), true
+	}
+
+	h.snapshot.preimages[key] = p
+}
+
+type preimage struct {
+	value     storage.Value
+	expiresAt int64
+	exists    bool
+}
+
+// Snapshot is a point-in-time view of a HashTable.
+type Snapshot struct {
+	table     *HashTable
+	keys      []string
+	preimages map[string]preimage
+}
+
+// ForEach visits every key-value pair of the snapshot along with its
+// expiration deadline in unix milliseconds. The table is read locked only
+// while a batch of pairs is copied, never while fn runs.
+func (s *Snapshot) ForEach(fn func(key string, value storage.Value, expiresAt int64) error) error {
+	batch := make([]preimage, 0, snapshotBatchSize)
+	keys := make([]string, 0, snapshotBatchSize)
+
+	for start := 0; start < len(s.keys); start += snapshotBatchSize {
+		end := min(start+snapshotBatchSize, len(s.keys))
+		batch, keys = batch[:0], keys[:0]
+
+		s.table.mutex.RLock()
+		for _, key := range s.keys[start:end] {
+			if p, ok := s.preimages[key]; ok {
+				if p.exists {
+					batch, keys = append(batch, p), append(keys, key)
+				}
+				continue
+			}
+
+			if e, ok := s.table.data[key]; ok {
+				// collections keep changing after the lock is released
+				batch = append(batch, preimage{value: e.value.Clone(), expiresAt: s.table.expires[key]})
+				keys = append(keys, key)
+			}
+		}
+		s.table.mutex.RUnlock()
+
+		for i, entry := range batch {
+			if err := fn(keys[i], entry.value, entry.expiresAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Release stops tracking modifications for the snapshot.
+func (s *Snapshot) Release() {
+	s.table.mutex.Lock()
+	defer s.table.mutex.Unlock()
+
+	s.releaseLocked()
+}
+
+// releaseLocked must be called with the write lock of the table held.
+func (s *Snapshot) releaseLocked() {
+	if s.table.snapshot == s {
+		s.table.snapshot = nil
+	}
 }
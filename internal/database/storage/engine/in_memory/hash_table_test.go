@@ -1,7 +1,13 @@
 package inmemory
 
 import (
+	"errors"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
 )
 
 func TestHashTableSet(t *testing.T) {
@@ -32,3 +38,327 @@ func TestHashTableDelete(t *testing.T) {
 		t.Errorf("expected empty string, got %v", value)
 	}
 }
+
+func TestHashTableSnapshot(t *testing.T) {
+	ht := NewHashTable()
+	ht.Set("key1", "value1")
+	ht.Set("key2", "value2")
+
+	snapshot, err := ht.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer snapshot.Release()
+
+	if _, err := ht.Snapshot(); err != errSnapshotInProgress {
+		t.Errorf("expected %v, got %v", errSnapshotInProgress, err)
+	}
+
+	ht.Set("key1", "changed")
+	ht.Del("key2")
+	ht.Set("key3", "value3")
+
+	pairs := make(map[string]string)
+	err = snapshot.ForEach(func(key string, value storage.Value, expiresAt int64) error {
+		pairs[key] = value.Str()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{"key1": "value1", "key2": "value2"}
+	if len(pairs) != len(expected) || pairs["key1"] != "value1" || pairs["key2"] != "value2" {
+		t.Errorf("expected %v, got %v", expected, pairs)
+	}
+
+	if value, _ := ht.Get("key1"); value != "changed" {
+		t.Errorf("expected changed, got %v", value)
+	}
+}
+
+func TestHashTableExpiration(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+
+	ht := NewHashTable()
+	ht.clock = func() time.Time { return now }
+
+	ht.SetWithExpiration("session", "token", now.Add(time.Second).UnixMilli())
+	ht.Set("persistent", "value")
+
+	if value, ok := ht.Get("session"); !ok || value != "token" {
+		t.Errorf("expected token, got %v", value)
+	}
+
+	if expiresAt, ok := ht.Expiration("persistent"); !ok || expiresAt != 0 {
+		t.Errorf("expected no expiration, got %v", expiresAt)
+	}
+
+	now = now.Add(time.Second)
+
+	if value, ok := ht.Get("session"); ok {
+		t.Errorf("expected expired key, got %v", value)
+	}
+
+	if _, ok := ht.data["session"]; ok {
+		t.Errorf("expected expired key to be deleted lazily")
+	}
+
+	if ht.Expire("session", now.Add(time.Second).UnixMilli()) {
+		t.Errorf("expected expire of a missing key to fail")
+	}
+
+	if !ht.Expire("persistent", now.Add(time.Second).UnixMilli()) {
+		t.Errorf("expected expire to succeed")
+	}
+
+	if !ht.Persist("persistent") {
+		t.Errorf("expected persist to succeed")
+	}
+
+	if ht.Persist("persistent") {
+		t.Errorf("expected persist of a key without expiration to fail")
+	}
+}
+
+func TestHashTableUpdate(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+
+	ht := NewHashTable()
+	ht.clock = func() time.Time { return now }
+
+	increment := func(value string, found bool) (string, error) {
+		if !found {
+			return "1", nil
+		}
+		number, err := strconv.Atoi(value)
+		return strconv.Itoa(number + 1), err
+	}
+
+	ht.SetWithExpiration("counter", "1", now.Add(time.Second).UnixMilli())
+
+	if err := ht.Update("counter", increment); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value, _ := ht.Get("counter"); value != "2" {
+		t.Errorf("expected 2, got %v", value)
+	}
+
+	if expiresAt, _ := ht.Expiration("counter"); expiresAt != now.Add(time.Second).UnixMilli() {
+		t.Errorf("expected expiration to be kept, got %v", expiresAt)
+	}
+
+	ht.Set("text", "value")
+	if err := ht.Update("text", increment); err == nil {
+		t.Errorf("expected error for a non-numeric value")
+	}
+
+	if value, _ := ht.Get("text"); value != "value" {
+		t.Errorf("expected value to be unchanged, got %v", value)
+	}
+
+	now = now.Add(time.Second)
+
+	if err := ht.Update("counter", increment); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value, _ := ht.Get("counter"); value != "1" {
+		t.Errorf("expected expired key to be created again, got %v", value)
+	}
+
+	if expiresAt, _ := ht.Expiration("counter"); expiresAt != 0 {
+		t.Errorf("expected no expiration, got %v", expiresAt)
+	}
+}
+
+func TestHashTableVersion(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+
+	ht := NewHashTable()
+	ht.clock = func() time.Time { return now }
+
+	missing := ht.Version("key")
+
+	ht.Set("key", "value")
+	set := ht.Version("key")
+	if set == missing {
+		t.Errorf("expected version to change on set")
+	}
+
+	if ht.Version("key") != set {
+		t.Errorf("expected version to stay without changes")
+	}
+
+	ht.Expire("key", now.Add(time.Second).UnixMilli())
+	expire := ht.Version("key")
+	if expire == set {
+		t.Errorf("expected version to change on expire")
+	}
+
+	now = now.Add(time.Second)
+	if ht.Version("key") == expire {
+		t.Errorf("expected version to change once the key expired")
+	}
+
+	ht.Set("other", "value")
+	ht.Del("other")
+	if ht.Version("missing") == missing {
+		t.Errorf("expected version of missing keys to change on delete")
+	}
+}
+
+func TestHashTableDeleteExpired(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+
+	ht := NewHashTable()
+	ht.clock = func() time.Time { return now }
+
+	for i := range 100 {
+		ht.SetWithExpiration(strconv.Itoa(i), "value", now.Add(time.Duration(i%2+1)*time.Second).UnixMilli())
+	}
+
+	now = now.Add(time.Second)
+
+	examined, deleted := ht.DeleteExpired(20)
+	if examined != 20 {
+		t.Errorf("expected 20 examined keys, got %v", examined)
+	}
+
+	_, count := ht.DeleteExpired(len(ht.expires))
+	deleted += count
+
+	if deleted != 50 {
+		t.Errorf("expected 50 deleted keys, got %v", deleted)
+	}
+
+	if len(ht.data) != 50 || len(ht.expires) != 50 {
+		t.Errorf("expected 50 keys left, got %v", len(ht.data))
+	}
+}
+
+func TestHashTableModify(t *testing.T) {
+	ht := NewHashTable()
+
+	setField := func(field, value string) func(*storage.Value, bool) error {
+		return func(hash *storage.Value, found bool) error {
+			if !found {
+				*hash = storage.NewHash()
+			}
+			hash.HSet(field, value)
+			return nil
+		}
+	}
+
+	if err := ht.Modify("user", setField("name", "ann")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, found := ht.Get("user"); found {
+		t.Errorf("expected hash to be hidden from Get")
+	}
+
+	if err := ht.Update("user", func(string, bool) (string, error) { return "1", nil }); !errors.Is(err, storage.ErrWrongType) {
+		t.Errorf("expected %v, got %v", storage.ErrWrongType, err)
+	}
+
+	snapshot, err := ht.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := ht.Modify("user", setField("name", "bob")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var name string
+	ht.View("user", func(hash *storage.Value) {
+		name, _ = hash.HGet("name")
+	})
+	if name != "bob" {
+		t.Errorf("expected bob, got %v", name)
+	}
+
+	err = snapshot.ForEach(func(key string, value storage.Value, expiresAt int64) error {
+		if name, _ := value.HGet("name"); name != "ann" {
+			t.Errorf("expected value as of snapshot start, got %v", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot.Release()
+
+	err = ht.Modify("user", func(hash *storage.Value, found bool) error {
+		hash.HDel("name")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ht.View("user", func(*storage.Value) {}) {
+		t.Errorf("expected hash without fields to be deleted")
+	}
+
+	if used := ht.UsedMemory(); used != 0 {
+		t.Errorf("expected no memory used, got %v", used)
+	}
+}
+
+func TestHashTableModifyList(t *testing.T) {
+	ht := NewHashTable()
+
+	push := func(list *storage.Value, found bool) error {
+		if !found {
+			*list = storage.NewList()
+		}
+		list.RPush("a", "b", "c")
+		return nil
+	}
+
+	if err := ht.Modify("jobs", push); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot, err := ht.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 3 {
+		err := ht.Modify("jobs", func(list *storage.Value, found bool) error {
+			list.LPop()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if ht.View("jobs", func(*storage.Value) {}) {
+		t.Errorf("expected list without items to be deleted")
+	}
+
+	if used := ht.UsedMemory(); used != 0 {
+		t.Errorf("expected no memory used, got %v", used)
+	}
+
+	err = snapshot.ForEach(func(key string, value storage.Value, expiresAt int64) error {
+		decoded, err := storage.DecodeValue(value.Type(), value.Encode())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if items := decoded.LRange(0, -1); !slices.Equal(items, []string{"a", "b", "c"}) {
+			t.Errorf("expected items as of snapshot start, got %v", items)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot.Release()
+}
@@ -0,0 +1,64 @@
+package lsm
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+const (
+	// bloomBitsPerKey gives about 1% false positive rate.
+	bloomBitsPerKey = 10
+	// bloomHashes is the optimal number of hash functions for bloomBitsPerKey.
+	bloomHashes = 7
+)
+
+// bloomFilter tells that a key is definitely absent from a table,
+// which saves a disk read for most lookups of missing keys.
+type bloomFilter []byte
+
+func newBloomFilter(keysNumber int) bloomFilter {
+	bits := max(keysNumber*bloomBitsPerKey, 64)
+	return make(bloomFilter, (bits+7)/8)
+}
+
+func (f bloomFilter) add(keyHash uint64) {
+	bits := uint64(len(f)) * 8
+	for _, hash := range bloomHashesOf(keyHash) {
+		bit := hash % bits
+		f[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (f bloomFilter) mayContain(keyHash uint64) bool {
+	bits := uint64(len(f)) * 8
+	if bits == 0 {
+		return true
+	}
+
+	for _, hash := range bloomHashesOf(keyHash) {
+		bit := hash % bits
+		if f[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func bloomKeyHash(key string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	return hasher.Sum64()
+}
+
+// bloomHashesOf derives the hashes from two halves of the key hash by double hashing.
+func bloomHashesOf(keyHash uint64) [bloomHashes]uint64 {
+	first, second := keyHash&math.MaxUint32, keyHash>>32|1
+
+	var hashes [bloomHashes]uint64
+	for i := range hashes {
+		hashes[i] = first + uint64(i)*second
+	}
+
+	return hashes
+}
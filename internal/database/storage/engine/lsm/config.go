@@ -0,0 +1,44 @@
+package lsm
+
+import (
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"go.uber.org/zap"
+)
+
+const EngineType = "lsm"
+
+// Config is the engine.lsm configuration section
+type Config struct {
+	DataDirectory       string `yaml:"data-directory" env:"KVDB_ENGINE_LSM_DATA_DIRECTORY" env-description:"Directory for tables and the log of the engine" env-default:"data/lsm"`
+	SyncPolicy          string `yaml:"sync-policy" env:"KVDB_ENGINE_LSM_SYNC_POLICY" env-description:"Log fsync policy: always, batch or never" env-default:"batch"`
+	MemtableSize        int    `yaml:"memtable-size" env:"KVDB_ENGINE_LSM_MEMTABLE_SIZE" env-description:"Memtable size in bytes that triggers a flush to disk" env-default:"4194304"`
+	BlockSize           int    `yaml:"block-size" env:"KVDB_ENGINE_LSM_BLOCK_SIZE" env-description:"Table block size in bytes" env-default:"4096"`
+	CompactionThreshold int    `yaml:"compaction-threshold" env:"KVDB_ENGINE_LSM_COMPACTION_THRESHOLD" env-description:"Number of tables of a tier merged by a compaction" env-default:"4"`
+}
+
+func init() {
+	engine.Register(EngineType, newFromConfig)
+}
+
+func newFromConfig(logger *zap.Logger, config engine.Config) (storage.Engine, error) {
+	var cfg Config
+	if err := config.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	syncPolicy, err := wal.ParseSyncPolicy(cfg.SyncPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEngine(
+		logger,
+		WithDataDirectory(cfg.DataDirectory),
+		WithSyncPolicy(syncPolicy),
+		WithMemtableSize(cfg.MemtableSize),
+		WithBlockSize(cfg.BlockSize),
+		WithCompactionThreshold(cfg.CompactionThreshold),
+	)
+}
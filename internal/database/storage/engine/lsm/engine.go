@@ -0,0 +1,539 @@
+package lsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"go.uber.org/zap"
+)
+
+const (
+	logDirectory = "log"
+	// flushRetryInterval is the delay before a failed memtable flush is retried.
+	flushRetryInterval = time.Second
+)
+
+var (
+	errInvalidLogger    = errors.New("invalid logger")
+	errInvalidDirectory = errors.New("invalid data directory")
+)
+
+// Engine is a log-structured merge tree keeping data on disk. Writes go to
+// the log and the memtable, a full memtable is flushed to a new table by
+// a background worker, which also merges tables of the same tier once there
+// are compactionThreshold of them (size-tiered compaction).
+type Engine struct {
+	// mutex guards the memtables, it is held for writing while appending to
+	// the log so the log order matches the order of writes.
+	mutex     sync.RWMutex
+	memtable  *memtable
+	immutable *memtable
+	// immutableSegment is the first log segment not covered by immutable.
+	immutableSegment uint64
+	// flushed is signaled once the immutable memtable is written to a table.
+	flushed *sync.Cond
+
+	// tablesMutex guards tables ordered from the newest to the oldest one.
+	tablesMutex sync.RWMutex
+	tables      []*table
+	nextTableID uint64
+
+	log    *wal.WAL
+	logger *zap.Logger
+
+	directory           string
+	syncPolicy          wal.SyncPolicy
+	memtableSize        int
+	blockSize           int
+	compactionThreshold int
+
+	flushRequests chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+	wg            sync.WaitGroup
+}
+
+func NewEngine(logger *zap.Logger, options ...EngineOption) (*Engine, error) {
+	if logger == nil {
+		return nil, errInvalidLogger
+	}
+
+	engine := &Engine{
+		memtable:            newMemtable(),
+		nextTableID:         1,
+		logger:              logger,
+		syncPolicy:          wal.SyncBatch,
+		memtableSize:        4 << 20,
+		blockSize:           4 << 10,
+		compactionThreshold: 4,
+		flushRequests:       make(chan struct{}, 1),
+		done:                make(chan struct{}),
+	}
+	engine.flushed = sync.NewCond(&engine.mutex)
+
+	for _, opt := range options {
+		opt(engine)
+	}
+
+	if engine.directory == "" {
+		return nil, errInvalidDirectory
+	}
+
+	if err := engine.openTables(); err != nil {
+		return nil, err
+	}
+
+	if err := engine.recover(); err != nil {
+		engine.closeTables()
+		return nil, err
+	}
+
+	engine.wg.Add(1)
+	go engine.work()
+
+	return engine, nil
+}
+
+func (e *Engine) Get(ctx context.Context, key string) (string, bool) {
+	e.mutex.RLock()
+	found, ok := e.getFromMemtables(key)
+	e.mutex.RUnlock()
+
+	if ok {
+		return found.value, !found.deleted
+	}
+
+	return e.getFromTables(key)
+}
+
+// Update holds mutex while reading and writing the key, so that writes of the
+// key cannot interleave.
+func (e *Engine) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, err := fn(e.getLocked(key))
+	if err != nil {
+		return err
+	}
+
+	e.writeLocked(entry{key: key, value: value}, wal.NewRecord(wal.OperationSet, key, value))
+
+	return nil
+}
+
+func (e *Engine) GetDel(ctx context.Context, key string) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	value, found := e.getLocked(key)
+	if found {
+		e.writeLocked(entry{key: key, deleted: true}, wal.NewRecord(wal.OperationDel, key))
+	}
+
+	return value, found
+}
+
+// getLocked must be called with mutex held for writing, so that the key
+// cannot change until it is written.
+func (e *Engine) getLocked(key string) (string, bool) {
+	if found, ok := e.getFromMemtables(key); ok {
+		return found.value, !found.deleted
+	}
+
+	return e.getFromTables(key)
+}
+
+// getFromMemtables must be called with mutex held.
+func (e *Engine) getFromMemtables(key string) (entry, bool) {
+	found, ok := e.memtable.get(key)
+	if !ok && e.immutable != nil {
+		found, ok = e.immutable.get(key)
+	}
+
+	return found, ok
+}
+
+func (e *Engine) getFromTables(key string) (string, bool) {
+	e.tablesMutex.RLock()
+	defer e.tablesMutex.RUnlock()
+
+	keyHash := bloomKeyHash(key)
+	for _, t := range e.tables {
+		found, ok, err := t.get(key, keyHash)
+		if err != nil {
+			e.logger.Error("failed to read table", zap.String("table", filepath.Base(t.path)), zap.Error(err))
+			return "", false
+		}
+
+		if ok {
+			return found.value, !found.deleted
+		}
+	}
+
+	return "", false
+}
+
+func (e *Engine) Set(ctx context.Context, key, value string) {
+	e.write(entry{key: key, value: value}, wal.NewRecord(wal.OperationSet, key, value))
+}
+
+func (e *Engine) Del(ctx context.Context, key string) {
+	e.write(entry{key: key, deleted: true}, wal.NewRecord(wal.OperationDel, key))
+}
+
+// DataDirectory returns the directory the engine keeps its data in.
+func (e *Engine) DataDirectory() string {
+	return e.directory
+}
+
+// Close stops the background worker and closes the log and the tables.
+// Writes not flushed to tables yet are recovered from the log on the next start.
+func (e *Engine) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+
+		err = errors.Join(e.log.Close(), e.closeTables())
+	})
+
+	return err
+}
+
+func (e *Engine) write(en entry, record wal.Record) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.writeLocked(en, record)
+}
+
+// writeLocked must be called with mutex held.
+func (e *Engine) writeLocked(en entry, record wal.Record) {
+	// the engine interface does not allow returning the error,
+	// the write is kept in memory at least
+	if err := e.log.Append(record); err != nil {
+		e.logger.Error("failed to append to lsm log", zap.Error(err))
+	}
+
+	e.memtable.put(en)
+
+	if e.memtable.size >= e.memtableSize {
+		e.rotateMemtable()
+	}
+}
+
+// rotateMemtable hands the memtable over to the background worker to be
+// flushed, waiting for the previous one to be flushed first.
+// It must be called with mutex held.
+func (e *Engine) rotateMemtable() {
+	for e.immutable != nil {
+		e.flushed.Wait()
+	}
+
+	// another writer could rotate the memtable while waiting
+	if e.memtable.size < e.memtableSize {
+		return
+	}
+
+	segment, err := e.log.Rotate()
+	if err != nil {
+		e.logger.Error("failed to rotate lsm log", zap.Error(err))
+		return
+	}
+
+	e.immutable = e.memtable
+	e.immutableSegment = segment
+	e.memtable = newMemtable()
+
+	e.requestFlush()
+}
+
+func (e *Engine) requestFlush() {
+	select {
+	case e.flushRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Engine) work() {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-e.flushRequests:
+			if err := e.flush(); err != nil {
+				e.logger.Error("failed to flush memtable", zap.Error(err))
+				time.AfterFunc(flushRetryInterval, e.requestFlush)
+				continue
+			}
+
+			if err := e.compact(); err != nil {
+				e.logger.Error("failed to compact tables", zap.Error(err))
+			}
+		}
+	}
+}
+
+// flush writes the immutable memtable to a new table and removes log
+// segments covered by it.
+func (e *Engine) flush() error {
+	e.mutex.RLock()
+	immutable, segment := e.immutable, e.immutableSegment
+	e.mutex.RUnlock()
+
+	if immutable == nil {
+		return nil
+	}
+
+	t, err := e.writeTable(0, immutable.iterator(), false)
+	if err != nil {
+		return err
+	}
+
+	if t != nil {
+		e.tablesMutex.Lock()
+		e.tables = append(e.tables, t)
+		e.sortTables()
+		e.tablesMutex.Unlock()
+	}
+
+	e.mutex.Lock()
+	e.immutable = nil
+	e.flushed.Broadcast()
+	e.mutex.Unlock()
+
+	if err := e.log.RemoveSegmentsBefore(segment); err != nil {
+		e.logger.Warn("failed to remove lsm log segments covered by tables", zap.Error(err))
+	}
+
+	e.logger.Debug("memtable flushed", zap.Int("entries", len(immutable.entries)))
+
+	return nil
+}
+
+// compact merges tables of the lowest tier holding at least compactionThreshold
+// of them into a single table of the next tier until no tier qualifies.
+func (e *Engine) compact() error {
+	for {
+		e.tablesMutex.RLock()
+		inputs, olderExist := e.pickCompaction()
+		e.tablesMutex.RUnlock()
+
+		if len(inputs) == 0 {
+			return nil
+		}
+
+		sources := make([]iterator, 0, len(inputs))
+		iterators := make([]*tableIterator, 0, len(inputs))
+		for _, t := range inputs {
+			it := t.iterator()
+			sources = append(sources, it)
+			iterators = append(iterators, it)
+		}
+
+		// tombstones are needed only while older versions of keys may exist
+		output, err := e.writeTable(inputs[0].tier+1, newMergeIterator(sources...), !olderExist)
+		for _, it := range iterators {
+			err = errors.Join(err, it.err)
+		}
+
+		if err != nil {
+			if output != nil {
+				output.remove()
+			}
+			return err
+		}
+
+		e.tablesMutex.Lock()
+		e.tables = slices.DeleteFunc(e.tables, func(t *table) bool {
+			return slices.Contains(inputs, t)
+		})
+		if output != nil {
+			e.tables = append(e.tables, output)
+		}
+		e.sortTables()
+		e.tablesMutex.Unlock()
+
+		for _, t := range inputs {
+			if err := t.remove(); err != nil {
+				e.logger.Warn("failed to remove compacted table", zap.String("table", filepath.Base(t.path)), zap.Error(err))
+			}
+		}
+
+		e.logger.Debug("tables compacted", zap.Int("tables", len(inputs)), zap.Int("tier", inputs[0].tier))
+	}
+}
+
+// pickCompaction returns tables of the lowest tier to compact and whether
+// tables of higher tiers, which hold older data, exist.
+// It must be called with tablesMutex held.
+func (e *Engine) pickCompaction() ([]*table, bool) {
+	tiers := make(map[int][]*table)
+	for _, t := range e.tables {
+		tiers[t.tier] = append(tiers[t.tier], t)
+	}
+
+	for _, tier := range slices.Sorted(maps.Keys(tiers)) {
+		if len(tiers[tier]) < e.compactionThreshold {
+			continue
+		}
+
+		olderExist := slices.ContainsFunc(e.tables, func(t *table) bool {
+			return t.tier > tier
+		})
+
+		return tiers[tier], olderExist
+	}
+
+	return nil, false
+}
+
+// writeTable writes entries of the iterator to a new table of the tier.
+// It returns a nil table if there is nothing to write.
+func (e *Engine) writeTable(tier int, entries iterator, dropTombstones bool) (*table, error) {
+	id := e.nextTableID
+	e.nextTableID++
+
+	writer, err := createTable(filepath.Join(e.directory, tableFileName(id, tier)), e.blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	written := 0
+	for en, ok := entries.next(); ok; en, ok = entries.next() {
+		if en.deleted && dropTombstones {
+			continue
+		}
+
+		if err := writer.add(en); err != nil {
+			writer.abort()
+			return nil, err
+		}
+		written++
+	}
+
+	if written == 0 {
+		writer.abort()
+		return nil, nil
+	}
+
+	if err := writer.finish(); err != nil {
+		return nil, err
+	}
+
+	return openTable(e.directory, id, tier)
+}
+
+// sortTables orders tables from the newest to the oldest one. Tables of a lower
+// tier are newer, since a tier is emptied entirely by a compaction.
+// It must be called with tablesMutex held.
+func (e *Engine) sortTables() {
+	slices.SortFunc(e.tables, func(a, b *table) int {
+		if a.tier != b.tier {
+			return a.tier - b.tier
+		}
+
+		switch {
+		case a.id > b.id:
+			return -1
+		case a.id < b.id:
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+func (e *Engine) openTables() error {
+	if err := os.MkdirAll(e.directory, 0o755); err != nil {
+		return fmt.Errorf("create lsm directory: %w", err)
+	}
+
+	files, err := os.ReadDir(e.directory)
+	if err != nil {
+		return fmt.Errorf("read lsm directory: %w", err)
+	}
+
+	for _, file := range files {
+		// unfinished tables of an interrupted flush or compaction
+		if strings.HasSuffix(file.Name(), tmpFileSuffix) {
+			if err := os.Remove(filepath.Join(e.directory, file.Name())); err != nil {
+				return fmt.Errorf("remove unfinished table: %w", err)
+			}
+			continue
+		}
+
+		id, tier, ok := parseTableFileName(file.Name())
+		if !ok {
+			continue
+		}
+
+		t, err := openTable(e.directory, id, tier)
+		if err != nil {
+			e.closeTables()
+			return err
+		}
+
+		e.tables = append(e.tables, t)
+		e.nextTableID = max(e.nextTableID, id+1)
+	}
+
+	e.sortTables()
+
+	return nil
+}
+
+// recover opens the log and restores the memtable from it.
+func (e *Engine) recover() error {
+	log, err := wal.New(
+		e.logger,
+		wal.WithDataDirectory(filepath.Join(e.directory, logDirectory)),
+		wal.WithSyncPolicy(e.syncPolicy),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = log.Recover(0, func(record wal.Record) error {
+		switch {
+		case record.Operation == wal.OperationSet && len(record.Args) == 2:
+			e.memtable.put(entry{key: record.Args[0], value: record.Args[1]})
+		case record.Operation == wal.OperationDel && len(record.Args) == 1:
+			e.memtable.put(entry{key: record.Args[0], deleted: true})
+		default:
+			return fmt.Errorf("unexpected lsm log record %v", record.Operation)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Close()
+		return fmt.Errorf("recover lsm log: %w", err)
+	}
+
+	e.log = log
+
+	return nil
+}
+
+func (e *Engine) closeTables() error {
+	var err error
+	for _, t := range e.tables {
+		err = errors.Join(err, t.close())
+	}
+
+	return err
+}
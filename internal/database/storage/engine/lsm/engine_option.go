@@ -0,0 +1,40 @@
+package lsm
+
+import "github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+
+type EngineOption func(*Engine)
+
+// WithDataDirectory sets the directory keeping tables and the log.
+func WithDataDirectory(directory string) EngineOption {
+	return func(e *Engine) {
+		e.directory = directory
+	}
+}
+
+func WithSyncPolicy(policy wal.SyncPolicy) EngineOption {
+	return func(e *Engine) {
+		e.syncPolicy = policy
+	}
+}
+
+// WithMemtableSize sets the size in bytes at which the memtable is flushed to a table.
+func WithMemtableSize(size int) EngineOption {
+	return func(e *Engine) {
+		e.memtableSize = size
+	}
+}
+
+// WithBlockSize sets the size in bytes of table blocks read from disk by a lookup.
+func WithBlockSize(size int) EngineOption {
+	return func(e *Engine) {
+		e.blockSize = size
+	}
+}
+
+// WithCompactionThreshold sets the number of tables of the same tier
+// merged into a single table of the next tier.
+func WithCompactionThreshold(threshold int) EngineOption {
+	return func(e *Engine) {
+		e.compactionThreshold = max(threshold, 2)
+	}
+}
@@ -0,0 +1,126 @@
+package lsm
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestEngine(t *testing.T, directory string) *Engine {
+	t.Helper()
+
+	engine, err := NewEngine(
+		zap.NewNop(),
+		WithDataDirectory(directory),
+		WithMemtableSize(512),
+		WithBlockSize(128),
+		WithCompactionThreshold(2),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return engine
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	random := rand.New(rand.NewPCG(1, 2))
+
+	engine := newTestEngine(t, directory)
+
+	expected := make(map[string]string)
+	for i := range 5000 {
+		key := "key:" + strconv.Itoa(random.IntN(300))
+
+		switch random.IntN(4) {
+		case 0:
+			if random.IntN(2) == 0 {
+				engine.Del(ctx, key)
+			} else if value, ok := engine.GetDel(ctx, key); value != expected[key] || ok != (expected[key] != "") {
+				t.Errorf("expected %q for %v, got %q %v", expected[key], key, value, ok)
+			}
+			delete(expected, key)
+		case 1:
+			err := engine.Update(ctx, key, func(value string, found bool) (string, error) {
+				if expectedValue, exists := expected[key]; found != exists || value != expectedValue {
+					t.Errorf("expected %q %v for %v, got %q %v", expectedValue, exists, key, value, found)
+				}
+				return value + "+", nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected[key] += "+"
+		default:
+			engine.Set(ctx, key, strconv.Itoa(i))
+			expected[key] = strconv.Itoa(i)
+		}
+	}
+
+	check := func(engine *Engine) {
+		t.Helper()
+
+		for i := range 300 {
+			key := "key:" + strconv.Itoa(i)
+
+			value, ok := engine.Get(ctx, key)
+			if expectedValue, exists := expected[key]; ok != exists || value != expectedValue {
+				t.Errorf("expected %q %v for %v, got %q %v", expectedValue, exists, key, value, ok)
+			}
+		}
+	}
+
+	check(engine)
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	engine = newTestEngine(t, directory)
+	defer engine.Close()
+
+	compacted := false
+	for _, table := range engine.tables {
+		compacted = compacted || table.tier > 0
+	}
+
+	if !compacted {
+		t.Errorf("expected tables to be compacted")
+	}
+
+	check(engine)
+}
+
+func TestEngineRecoverFromLog(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	engine := newTestEngine(t, directory)
+	engine.Set(ctx, "key", "value")
+	engine.Set(ctx, "deleted", "value")
+	engine.Del(ctx, "deleted")
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	engine = newTestEngine(t, directory)
+	defer engine.Close()
+
+	if len(engine.tables) != 0 {
+		t.Errorf("expected writes to stay in the memtable")
+	}
+
+	if value, ok := engine.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("expected value, got %v", value)
+	}
+
+	if _, ok := engine.Get(ctx, "deleted"); ok {
+		t.Errorf("expected deleted key")
+	}
+}
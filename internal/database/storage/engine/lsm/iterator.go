@@ -0,0 +1,69 @@
+package lsm
+
+// iterator visits entries in ascending key order.
+type iterator interface {
+	next() (entry, bool)
+}
+
+type sliceIterator struct {
+	entries []entry
+}
+
+func (it *sliceIterator) next() (entry, bool) {
+	if len(it.entries) == 0 {
+		return entry{}, false
+	}
+
+	e := it.entries[0]
+	it.entries = it.entries[1:]
+
+	return e, true
+}
+
+// mergeIterator visits the newest entry of every key found in its sources,
+// which are ordered from the newest to the oldest one.
+type mergeIterator struct {
+	sources []iterator
+	heads   []entry
+	valid   []bool
+}
+
+func newMergeIterator(sources ...iterator) *mergeIterator {
+	it := &mergeIterator{
+		sources: sources,
+		heads:   make([]entry, len(sources)),
+		valid:   make([]bool, len(sources)),
+	}
+
+	for i := range sources {
+		it.advance(i)
+	}
+
+	return it
+}
+
+func (it *mergeIterator) next() (entry, bool) {
+	newest := -1
+	for i := range it.sources {
+		if it.valid[i] && (newest == -1 || it.heads[i].key < it.heads[newest].key) {
+			newest = i
+		}
+	}
+
+	if newest == -1 {
+		return entry{}, false
+	}
+
+	e := it.heads[newest]
+	for i := range it.sources {
+		if it.valid[i] && it.heads[i].key == e.key {
+			it.advance(i)
+		}
+	}
+
+	return e, true
+}
+
+func (it *mergeIterator) advance(i int) {
+	it.heads[i], it.valid[i] = it.sources[i].next()
+}
@@ -0,0 +1,47 @@
+package lsm
+
+import (
+	"slices"
+	"strings"
+)
+
+// memtable buffers the latest writes in memory until they are flushed to a table.
+type memtable struct {
+	entries map[string]entry
+	// size is an estimation of bytes the entries take in a table.
+	size int
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		entries: make(map[string]entry),
+	}
+}
+
+func (m *memtable) put(e entry) {
+	if previous, ok := m.entries[e.key]; ok {
+		m.size -= len(previous.key) + len(previous.value)
+	}
+
+	m.entries[e.key] = e
+	m.size += len(e.key) + len(e.value)
+}
+
+func (m *memtable) get(key string) (entry, bool) {
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+// iterator returns the entries in ascending key order.
+func (m *memtable) iterator() *sliceIterator {
+	entries := make([]entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	return &sliceIterator{entries: entries}
+}
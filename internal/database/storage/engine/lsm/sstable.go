@@ -0,0 +1,446 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	tableFileFormat = "sst_%020d_%d.sst"
+	tmpFileSuffix   = ".tmp"
+
+	tableMagic = 0x4b564442 // "KVDB"
+	footerSize = 4*8 + 4 + 4
+
+	kindValue     = 1
+	kindTombstone = 2
+)
+
+var (
+	errCorrupted = errors.New("corrupted table")
+	crcTable     = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// entry is a version of a key, deleted entries are tombstones shadowing
+// older versions of the key.
+type entry struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// blockHandle locates a data block and holds its last key for lookups.
+type blockHandle struct {
+	lastKey  string
+	offset   uint64
+	size     uint64
+	checksum uint32
+}
+
+func tableFileName(id uint64, tier int) string {
+	return fmt.Sprintf(tableFileFormat, id, tier)
+}
+
+func parseTableFileName(name string) (uint64, int, bool) {
+	var id uint64
+	var tier int
+	if _, err := fmt.Sscanf(name, tableFileFormat, &id, &tier); err != nil {
+		return 0, 0, false
+	}
+
+	return id, tier, name == tableFileName(id, tier)
+}
+
+// tableWriter writes entries given in ascending key order to a new table.
+// The table appears under its final name only once it is completely on disk.
+type tableWriter struct {
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	blockSize int
+
+	offset    uint64
+	block     []byte
+	lastKey   string
+	index     []blockHandle
+	keyHashes []uint64
+}
+
+func createTable(path string, blockSize int) (*tableWriter, error) {
+	file, err := os.OpenFile(path+tmpFileSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create table file: %w", err)
+	}
+
+	return &tableWriter{
+		path:      path,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		blockSize: blockSize,
+	}, nil
+}
+
+func (w *tableWriter) add(e entry) error {
+	kind := byte(kindValue)
+	if e.deleted {
+		kind = kindTombstone
+	}
+
+	w.block = append(w.block, kind)
+	w.block = binary.AppendUvarint(w.block, uint64(len(e.key)))
+	w.block = binary.AppendUvarint(w.block, uint64(len(e.value)))
+	w.block = append(w.block, e.key...)
+	w.block = append(w.block, e.value...)
+
+	w.lastKey = e.key
+	w.keyHashes = append(w.keyHashes, bloomKeyHash(e.key))
+
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+
+	return nil
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+
+	if _, err := w.writer.Write(w.block); err != nil {
+		return fmt.Errorf("write table block: %w", err)
+	}
+
+	w.index = append(w.index, blockHandle{
+		lastKey:  w.lastKey,
+		offset:   w.offset,
+		size:     uint64(len(w.block)),
+		checksum: crc32.Checksum(w.block, crcTable),
+	})
+
+	w.offset += uint64(len(w.block))
+	w.block = w.block[:0]
+
+	return nil
+}
+
+// finish writes the index and the bloom filter, syncs the file and renames it
+// to its final name.
+func (w *tableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		w.abort()
+		return err
+	}
+
+	var index []byte
+	for _, handle := range w.index {
+		index = binary.AppendUvarint(index, uint64(len(handle.lastKey)))
+		index = append(index, handle.lastKey...)
+		index = binary.AppendUvarint(index, handle.offset)
+		index = binary.AppendUvarint(index, handle.size)
+		index = binary.LittleEndian.AppendUint32(index, handle.checksum)
+	}
+
+	bloom := newBloomFilter(len(w.keyHashes))
+	for _, hash := range w.keyHashes {
+		bloom.add(hash)
+	}
+
+	checksum := crc32.Update(crc32.Checksum(index, crcTable), crcTable, bloom)
+
+	footer := make([]byte, 0, footerSize)
+	footer = binary.LittleEndian.AppendUint64(footer, w.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, w.offset+uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(bloom)))
+	footer = binary.LittleEndian.AppendUint32(footer, checksum)
+	footer = binary.LittleEndian.AppendUint32(footer, tableMagic)
+
+	for _, data := range [][]byte{index, bloom, footer} {
+		if _, err := w.writer.Write(data); err != nil {
+			w.abort()
+			return fmt.Errorf("write table: %w", err)
+		}
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		w.abort()
+		return fmt.Errorf("write table: %w", err)
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.abort()
+		return fmt.Errorf("sync table: %w", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return fmt.Errorf("close table: %w", err)
+	}
+
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		os.Remove(w.file.Name())
+		return fmt.Errorf("rename table: %w", err)
+	}
+
+	return syncDirectory(filepath.Dir(w.path))
+}
+
+// abort discards the unfinished table.
+func (w *tableWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// table is an immutable sorted file of entries. Its index and bloom filter
+// are kept in memory while data blocks are read on demand.
+//
+// File layout:
+// [data block]*[index block][bloom filter][footer]
+// data block:   ([kind uint8][key length uvarint][value length uvarint][key][value])*
+// index block:  ([last key length uvarint][last key][offset uvarint][size uvarint][crc32 uint32])*
+// footer:       [index offset uint64][index size uint64][bloom offset uint64][bloom size uint64][crc32 uint32][magic uint32]
+// where the footer checksum covers the index block and the bloom filter.
+type table struct {
+	id   uint64
+	tier int
+	path string
+	size int64
+
+	file  *os.File
+	index []blockHandle
+	bloom bloomFilter
+}
+
+func openTable(directory string, id uint64, tier int) (*table, error) {
+	path := filepath.Join(directory, tableFileName(id, tier))
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open table: %w", err)
+	}
+
+	t := &table{
+		id:   id,
+		tier: tier,
+		path: path,
+		file: file,
+	}
+
+	if err := t.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("load table %s: %w", filepath.Base(path), err)
+	}
+
+	return t, nil
+}
+
+func (t *table) load() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	t.size = info.Size()
+	if t.size < footerSize {
+		return errCorrupted
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := t.file.ReadAt(footer, t.size-footerSize); err != nil {
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(footer[36:]) != tableMagic {
+		return errCorrupted
+	}
+
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexSize := binary.LittleEndian.Uint64(footer[8:])
+	bloomOffset := binary.LittleEndian.Uint64(footer[16:])
+	bloomSize := binary.LittleEndian.Uint64(footer[24:])
+
+	if indexOffset+indexSize != bloomOffset || bloomOffset+bloomSize != uint64(t.size-footerSize) {
+		return errCorrupted
+	}
+
+	meta := make([]byte, indexSize+bloomSize)
+	if _, err := t.file.ReadAt(meta, int64(indexOffset)); err != nil {
+		return err
+	}
+
+	if crc32.Checksum(meta, crcTable) != binary.LittleEndian.Uint32(footer[32:]) {
+		return errCorrupted
+	}
+
+	index := meta[:indexSize]
+	for len(index) > 0 {
+		var handle blockHandle
+
+		keyLength, n := binary.Uvarint(index)
+		if n <= 0 || uint64(len(index)-n) < keyLength {
+			return errCorrupted
+		}
+		handle.lastKey = string(index[n : n+int(keyLength)])
+		index = index[n+int(keyLength):]
+
+		if handle.offset, n = binary.Uvarint(index); n <= 0 {
+			return errCorrupted
+		}
+		index = index[n:]
+
+		if handle.size, n = binary.Uvarint(index); n <= 0 || len(index)-n < 4 {
+			return errCorrupted
+		}
+		handle.checksum = binary.LittleEndian.Uint32(index[n:])
+		index = index[n+4:]
+
+		t.index = append(t.index, handle)
+	}
+
+	t.bloom = bloomFilter(meta[indexSize:])
+
+	return nil
+}
+
+// get returns the newest entry of the key stored in the table.
+func (t *table) get(key string, keyHash uint64) (entry, bool, error) {
+	if !t.bloom.mayContain(keyHash) {
+		return entry{}, false, nil
+	}
+
+	i := sort.Search(len(t.index), func(i int) bool {
+		return t.index[i].lastKey >= key
+	})
+	if i == len(t.index) {
+		return entry{}, false, nil
+	}
+
+	block, err := t.readBlock(i)
+	if err != nil {
+		return entry{}, false, err
+	}
+
+	for len(block) > 0 {
+		var e entry
+		e, block, err = decodeEntry(block)
+		if err != nil {
+			return entry{}, false, err
+		}
+
+		if e.key == key {
+			return e, true, nil
+		}
+
+		if e.key > key {
+			break
+		}
+	}
+
+	return entry{}, false, nil
+}
+
+func (t *table) readBlock(i int) ([]byte, error) {
+	handle := t.index[i]
+
+	block := make([]byte, handle.size)
+	if _, err := t.file.ReadAt(block, int64(handle.offset)); err != nil {
+		return nil, fmt.Errorf("read table block: %w", err)
+	}
+
+	if crc32.Checksum(block, crcTable) != handle.checksum {
+		return nil, fmt.Errorf("%w: %s block %d", errCorrupted, filepath.Base(t.path), i)
+	}
+
+	return block, nil
+}
+
+func (t *table) iterator() *tableIterator {
+	return &tableIterator{table: t}
+}
+
+func (t *table) close() error {
+	return t.file.Close()
+}
+
+// remove closes and deletes the table file.
+func (t *table) remove() error {
+	t.close()
+	return os.Remove(t.path)
+}
+
+// tableIterator visits entries of a table in ascending key order.
+type tableIterator struct {
+	table     *table
+	nextBlock int
+	block     []byte
+	err       error
+}
+
+func (it *tableIterator) next() (entry, bool) {
+	for len(it.block) == 0 {
+		if it.err != nil || it.nextBlock == len(it.table.index) {
+			return entry{}, false
+		}
+
+		it.block, it.err = it.table.readBlock(it.nextBlock)
+		it.nextBlock++
+	}
+
+	var e entry
+	e, it.block, it.err = decodeEntry(it.block)
+	if it.err != nil {
+		return entry{}, false
+	}
+
+	return e, true
+}
+
+func decodeEntry(data []byte) (entry, []byte, error) {
+	if len(data) == 0 {
+		return entry{}, nil, errCorrupted
+	}
+
+	kind := data[0]
+	data = data[1:]
+
+	keyLength, n := binary.Uvarint(data)
+	if n <= 0 {
+		return entry{}, nil, errCorrupted
+	}
+	data = data[n:]
+
+	valueLength, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < keyLength+valueLength {
+		return entry{}, nil, errCorrupted
+	}
+	data = data[n:]
+
+	e := entry{
+		key:     string(data[:keyLength]),
+		value:   string(data[keyLength : keyLength+valueLength]),
+		deleted: kind == kindTombstone,
+	}
+
+	if kind != kindValue && kind != kindTombstone {
+		return entry{}, nil, errCorrupted
+	}
+
+	return e, data[keyLength+valueLength:], nil
+}
+
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
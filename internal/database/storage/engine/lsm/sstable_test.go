@@ -0,0 +1,116 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTable(t *testing.T, directory string, entries []entry) *table {
+	t.Helper()
+
+	writer, err := createTable(filepath.Join(directory, tableFileName(1, 0)), 64)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, e := range entries {
+		if err := writer.add(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := writer.finish(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	table, err := openTable(directory, 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return table
+}
+
+func TestTable(t *testing.T) {
+	var entries []entry
+	for i := range 100 {
+		entries = append(entries, entry{key: fmt.Sprintf("key:%03d", i), value: fmt.Sprint(i), deleted: i%10 == 0})
+	}
+
+	table := writeTestTable(t, t.TempDir(), entries)
+	defer table.close()
+
+	if len(table.index) < 2 {
+		t.Fatalf("expected several blocks, got %v", len(table.index))
+	}
+
+	t.Run("get", func(t *testing.T) {
+		for _, expected := range entries {
+			actual, ok, err := table.get(expected.key, bloomKeyHash(expected.key))
+			if err != nil || !ok || actual != expected {
+				t.Errorf("expected %v, got %v %v %v", expected, actual, ok, err)
+			}
+		}
+
+		for _, key := range []string{"a", "key:0005", "key:100", "z"} {
+			if _, ok, err := table.get(key, bloomKeyHash(key)); ok || err != nil {
+				t.Errorf("expected missing %v, got %v %v", key, ok, err)
+			}
+		}
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		it := table.iterator()
+
+		var actual []entry
+		for e, ok := it.next(); ok; e, ok = it.next() {
+			actual = append(actual, e)
+		}
+
+		if it.err != nil || len(actual) != len(entries) {
+			t.Fatalf("expected %v entries, got %v %v", len(entries), len(actual), it.err)
+		}
+
+		for i := range entries {
+			if actual[i] != entries[i] {
+				t.Errorf("expected %v, got %v", entries[i], actual[i])
+			}
+		}
+	})
+}
+
+func TestTableCorruption(t *testing.T) {
+	directory := t.TempDir()
+
+	table := writeTestTable(t, directory, []entry{{key: "key", value: "value"}})
+	table.close()
+
+	data, err := os.ReadFile(table.path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data[len(data)-footerSize-1] ^= 0xff
+	if err := os.WriteFile(table.path, data, 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := openTable(directory, 1, 0); err == nil {
+		t.Errorf("expected corrupted table error")
+	}
+}
+
+func TestTableFileName(t *testing.T) {
+	id, tier, ok := parseTableFileName(tableFileName(42, 3))
+	if !ok || id != 42 || tier != 3 {
+		t.Errorf("unexpected %v %v %v", id, tier, ok)
+	}
+
+	for _, name := range []string{"sst_1_0.sst", tableFileName(1, 0) + tmpFileSuffix, "log"} {
+		if _, _, ok := parseTableFileName(name); ok {
+			t.Errorf("expected %v not to be a table", name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"go.uber.org/zap"
+)
+
+var errUnknownEngine = errors.New("unknown engine type")
+
+// Config gives an engine access to its own configuration section.
+type Config interface {
+	// Decode fills out with the section of the engine, environment variables
+	// and defaults declared by the cleanenv tags of out.
+	Decode(out any) error
+}
+
+// Factory creates an engine from its configuration section.
+type Factory func(logger *zap.Logger, config Config) (storage.Engine, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes an engine available by the name used as engine.type in the
+// configuration. It is meant to be called from init functions of engine
+// packages and panics if the name is registered twice.
+func Register(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if factory == nil {
+		panic("engine: register nil factory for " + name)
+	}
+
+	if _, duplicate := factories[name]; duplicate {
+		panic("engine: register called twice for " + name)
+	}
+
+	factories[name] = factory
+}
+
+// New creates the engine registered under the name.
+func New(name string, logger *zap.Logger, config Config) (storage.Engine, error) {
+	mutex.RLock()
+	factory, ok := factories[name]
+	mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q, available engines: %s", errUnknownEngine, name, strings.Join(Names(), ", "))
+	}
+
+	engine, err := factory(logger, config)
+	if err != nil {
+		return nil, fmt.Errorf("create %s engine: %w", name, err)
+	}
+
+	return engine, nil
+}
+
+// Names returns sorted names of the registered engines.
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
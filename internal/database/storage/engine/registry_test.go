@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type stubEngine struct {
+	storage.Engine
+	name string
+}
+
+type stubConfig struct {
+	name string
+}
+
+func (c stubConfig) Decode(out any) error {
+	*out.(*string) = c.name
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("stub", func(logger *zap.Logger, config Config) (storage.Engine, error) {
+		var name string
+		if err := config.Decode(&name); err != nil {
+			return nil, err
+		}
+
+		return stubEngine{name: name}, nil
+	})
+
+	t.Run("registered engine", func(t *testing.T) {
+		engine, err := New("stub", zap.NewNop(), stubConfig{name: "section"})
+		require.NoError(t, err)
+
+		assert.Equal(t, stubEngine{name: "section"}, engine)
+		assert.Contains(t, Names(), "stub")
+	})
+
+	t.Run("unknown engine", func(t *testing.T) {
+		_, err := New("unknown", zap.NewNop(), stubConfig{})
+		assert.ErrorIs(t, err, errUnknownEngine)
+		assert.ErrorContains(t, err, "available engines: stub")
+	})
+
+	t.Run("duplicate registration", func(t *testing.T) {
+		assert.Panics(t, func() {
+			Register("stub", func(*zap.Logger, Config) (storage.Engine, error) { return nil, nil })
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package syncmap
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"go.uber.org/zap"
+)
+
+const EngineType = "sync_map"
+
+func init() {
+	engine.Register(EngineType, func(logger *zap.Logger, config engine.Config) (storage.Engine, error) {
+		return NewEngine(logger), nil
+	})
+}
+
+// Engine keeps values in a sync.Map, which serves reads without locking.
+// It suits read-mostly workloads where keys are written once and read many
+// times, but supports neither expiration nor snapshots.
+type Engine struct {
+	data   sync.Map
+	logger *zap.Logger
+}
+
+func NewEngine(logger *zap.Logger) *Engine {
+	return &Engine{
+		logger: logger,
+	}
+}
+
+func (e *Engine) Get(ctx context.Context, key string) (string, bool) {
+	value, ok := e.data.Load(key)
+	if !ok {
+		return "", false
+	}
+
+	return value.(string), true
+}
+
+func (e *Engine) Set(ctx context.Context, key, value string) {
+	e.data.Store(key, value)
+}
+
+func (e *Engine) Del(ctx context.Context, key string) {
+	e.data.Delete(key)
+}
+
+// Update swaps the value only if the key did not change since it was loaded,
+// calling fn again otherwise, as sync.Map has no locks to hold.
+func (e *Engine) Update(ctx context.Context, key string, fn storage.UpdateFunc) error {
+	for {
+		current, found := e.data.Load(key)
+
+		var currentValue string
+		if found {
+			currentValue = current.(string)
+		}
+
+		value, err := fn(currentValue, found)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			if e.data.CompareAndSwap(key, current, value) {
+				return nil
+			}
+		} else if _, loaded := e.data.LoadOrStore(key, value); !loaded {
+			return nil
+		}
+	}
+}
+
+func (e *Engine) GetDel(ctx context.Context, key string) (string, bool) {
+	value, found := e.data.LoadAndDelete(key)
+	if !found {
+		return "", false
+	}
+
+	return value.(string), true
+}
@@ -0,0 +1,29 @@
+package syncmap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	"go.uber.org/zap"
+)
+
+func TestEngineRegistered(t *testing.T) {
+	ctx := context.Background()
+
+	// the engine takes no configuration section
+	registered, err := engine.New(EngineType, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("expected %v engine, got %v", EngineType, err)
+	}
+
+	registered.Set(ctx, "key", "value")
+	if value, ok := registered.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("expected value, got %v", value)
+	}
+
+	registered.Del(ctx, "key")
+	if _, ok := registered.Get(ctx, "key"); ok {
+		t.Errorf("expected deleted key")
+	}
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+)
+
+// HGet returns the values of the fields of the hash stored at the key, found
+// reports which of them exist. Missing keys are empty hashes.
+func (s *Storage) HGet(ctx context.Context, key string, fields []string) (values []string, found []bool, err error) {
+	defer s.isolate(ctx)()
+
+	values, found = make([]string, len(fields)), make([]bool, len(fields))
+	err = s.viewHash(ctx, key, func(hash *Value) {
+		for i, field := range fields {
+			values[i], found[i] = hash.HGet(field)
+		}
+	})
+
+	return values, found, err
+}
+
+// HGetAll returns the fields of the hash stored at the key with their values
+// ordered by field.
+func (s *Storage) HGetAll(ctx context.Context, key string) ([]KeyValue, error) {
+	defer s.isolate(ctx)()
+
+	var pairs []KeyValue
+	err := s.viewHash(ctx, key, func(hash *Value) {
+		pairs = hash.HGetAll()
+	})
+
+	return pairs, err
+}
+
+// HLen returns the number of fields of the hash stored at the key.
+func (s *Storage) HLen(ctx context.Context, key string) (int, error) {
+	defer s.isolate(ctx)()
+
+	var length int
+	err := s.viewHash(ctx, key, func(hash *Value) {
+		length = hash.HLen()
+	})
+
+	return length, err
+}
+
+// HSet sets the fields of the hash stored at the key, creating the hash if
+// missing, and returns the number of fields that are new.
+func (s *Storage) HSet(ctx context.Context, key string, pairs []KeyValue) (int, error) {
+	defer s.isolate(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	var added int
+	err := s.modifyHash(ctx, key, true, func(hash *Value, found bool) error {
+		if err := s.log(ctx, hashSetRecord(key, found, pairs)); err != nil {
+			return err
+		}
+
+		added = setFields(hash, pairs)
+		return nil
+	})
+
+	return added, err
+}
+
+// HUpdate replaces the value of the field of the hash stored at the key by
+// the value fn returns for the current one, like Update does for strings.
+func (s *Storage) HUpdate(ctx context.Context, key, field string, fn UpdateFunc) error {
+	defer s.isolate(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	return s.modifyHash(ctx, key, true, func(hash *Value, found bool) error {
+		current, exists := hash.HGet(field)
+
+		value, err := fn(current, exists)
+		if err != nil {
+			return err
+		}
+
+		pairs := []KeyValue{{Key: field, Value: value}}
+		if err := s.log(ctx, hashSetRecord(key, found, pairs)); err != nil {
+			return err
+		}
+
+		setFields(hash, pairs)
+		return nil
+	})
+}
+
+// HDel deletes the fields of the hash stored at the key and returns the
+// number of fields that existed. A hash left without fields is deleted.
+func (s *Storage) HDel(ctx context.Context, key string, fields []string) (int, error) {
+	defer s.isolate(ctx)()
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	var deleted int
+	err := s.modifyHash(ctx, key, false, func(hash *Value, _ bool) error {
+		if err := s.log(ctx, wal.NewRecord(wal.OperationHDel, append([]string{key}, fields...)...)); err != nil {
+			return err
+		}
+
+		deleted = deleteFields(hash, fields)
+		return nil
+	})
+	if errors.Is(err, errKeyMissing) {
+		return 0, nil
+	}
+
+	return deleted, err
+}
+
+// viewHash calls fn with the hash stored at the key, an empty one for missing
+// keys, or returns ErrWrongType if the key holds another type.
+func (s *Storage) viewHash(ctx context.Context, key string, fn func(hash *Value)) error {
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		return errTypesUnsupported
+	}
+
+	var wrong bool
+	found := typed.View(ctx, key, func(value *Value) {
+		if wrong = value.Type() != TypeHash; !wrong {
+			fn(value)
+		}
+	})
+
+	if wrong {
+		return ErrWrongType
+	}
+
+	if !found {
+		empty := NewHash()
+		fn(&empty)
+	}
+
+	return nil
+}
+
+// modifyHash calls fn with the hash stored at the key, which is created if
+// missing when create is set, otherwise errKeyMissing is returned. Keys
+// holding another type fail with ErrWrongType.
+func (s *Storage) modifyHash(ctx context.Context, key string, create bool, fn func(hash *Value, found bool) error) error {
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		return errTypesUnsupported
+	}
+
+	return typed.Modify(ctx, key, func(value *Value, found bool) error {
+		switch {
+		case found && value.Type() != TypeHash:
+			return ErrWrongType
+		case !found && !create:
+			return errKeyMissing
+		case !found:
+			*value = NewHash()
+		}
+
+		return fn(value, found)
+	})
+}
+
+// applyHash replays a hash record of the write-ahead log.
+func (s *Storage) applyHash(ctx context.Context, record wal.Record) error {
+	key, args := record.Args[0], record.Args[1:]
+
+	var err error
+	switch record.Operation {
+	case wal.OperationHSet, wal.OperationHUpdate:
+		// an updated hash may have expired since, it must not be brought back
+		create := record.Operation == wal.OperationHSet
+		err = s.modifyHash(ctx, key, create, func(hash *Value, _ bool) error {
+			setFields(hash, pairsOf(args))
+			return nil
+		})
+	case wal.OperationHDel:
+		err = s.modifyHash(ctx, key, false, func(hash *Value, _ bool) error {
+			deleteFields(hash, args)
+			return nil
+		})
+	}
+
+	if errors.Is(err, errKeyMissing) {
+		return nil
+	}
+
+	return err
+}
+
+// hashSetRecord returns the record of fields set in a hash, which existed if
+// found.
+func hashSetRecord(key string, found bool, pairs []KeyValue) wal.Record {
+	operation := wal.OperationHSet
+	if found {
+		operation = wal.OperationHUpdate
+	}
+
+	args := make([]string, 0, 1+2*len(pairs))
+	args = append(args, key)
+	for _, pair := range pairs {
+		args = append(args, pair.Key, pair.Value)
+	}
+
+	return wal.NewRecord(operation, args...)
+}
+
+func setFields(hash *Value, pairs []KeyValue) int {
+	added := 0
+	for _, pair := range pairs {
+		if hash.HSet(pair.Key, pair.Value) {
+			added++
+		}
+	}
+
+	return added
+}
+
+func deleteFields(hash *Value, fields []string) int {
+	deleted := 0
+	for _, field := range fields {
+		if hash.HDel(field) {
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// pairsOf pairs interleaved keys and values.
+func pairsOf(args []string) []KeyValue {
+	pairs := make([]KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, KeyValue{Key: args[i], Value: args[i+1]})
+	}
+
+	return pairs
+}
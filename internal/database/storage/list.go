@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+)
+
+// LPush inserts the items at the head of the list stored at the key,
+// creating the list if missing, and returns the length of the list.
+func (s *Storage) LPush(ctx context.Context, key string, items []string) (int, error) {
+	return s.push(ctx, key, true, items)
+}
+
+// RPush appends the items to the tail of the list stored at the key,
+// creating the list if missing, and returns the length of the list.
+func (s *Storage) RPush(ctx context.Context, key string, items []string) (int, error) {
+	return s.push(ctx, key, false, items)
+}
+
+// LPop removes and returns the first item of the list stored at the key.
+// A list left without items is deleted.
+func (s *Storage) LPop(ctx context.Context, key string) (string, bool, error) {
+	return s.pop(ctx, key, true)
+}
+
+// RPop removes and returns the last item of the list stored at the key.
+func (s *Storage) RPop(ctx context.Context, key string) (string, bool, error) {
+	return s.pop(ctx, key, false)
+}
+
+// LLen returns the number of items of the list stored at the key.
+func (s *Storage) LLen(ctx context.Context, key string) (int, error) {
+	defer s.isolate(ctx)()
+
+	var length int
+	err := s.viewList(ctx, key, func(list *Value) {
+		length = list.LLen()
+	})
+
+	return length, err
+}
+
+// LIndex returns the item of the list stored at the key at the index,
+// negative indexes count from the tail.
+func (s *Storage) LIndex(ctx context.Context, key string, index int) (item string, found bool, err error) {
+	defer s.isolate(ctx)()
+
+	err = s.viewList(ctx, key, func(list *Value) {
+		item, found = list.LIndex(index)
+	})
+
+	return item, found, err
+}
+
+// LRange returns the items of the list stored at the key from start to stop
+// inclusive, negative indexes count from the tail.
+func (s *Storage) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	defer s.isolate(ctx)()
+
+	var items []string
+	err := s.viewList(ctx, key, func(list *Value) {
+		items = list.LRange(start, stop)
+	})
+
+	return items, err
+}
+
+// LTrim keeps only the items of the list stored at the key from start to
+// stop inclusive. A list left without items is deleted.
+func (s *Storage) LTrim(ctx context.Context, key string, start, stop int) error {
+	defer s.isolate(ctx)()
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	err := s.modifyList(ctx, key, false, func(list *Value, _ bool) error {
+		record := wal.NewRecord(wal.OperationLTrim, key, strconv.Itoa(start), strconv.Itoa(stop))
+		if err := s.log(ctx, record); err != nil {
+			return err
+		}
+
+		list.LTrim(start, stop)
+		return nil
+	})
+	if errors.Is(err, errKeyMissing) {
+		return nil
+	}
+
+	return err
+}
+
+// WaitPush returns a channel receiving a signal once items are pushed to a
+// list stored at one of the keys. Pushes made before the channel is read are
+// not missed, so a caller checks the lists after WaitPush and waits only if
+// they are empty. Callers waiting for a key are signaled in the order they
+// called WaitPush, one for each item pushed. stop must be called once the
+// channel is no longer read.
+func (s *Storage) WaitPush(keys []string) (pushed <-chan struct{}, stop func()) {
+	pushed, stopWaiting := s.pushes.wait(keys)
+
+	return pushed, func() {
+		if !stopWaiting() {
+			return
+		}
+
+		// a caller may stop without popping the items it was signaled for,
+		// which are left to the next callers
+		for _, key := range keys {
+			if length := s.listLength(key); length > 0 {
+				s.pushes.signal(key, length)
+			}
+		}
+	}
+}
+
+func (s *Storage) push(ctx context.Context, key string, head bool, items []string) (int, error) {
+	defer s.isolate(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	var length int
+	err := s.modifyList(ctx, key, true, func(list *Value, found bool) error {
+		if err := s.log(ctx, listPushRecord(key, head, found, items)); err != nil {
+			return err
+		}
+
+		length = pushItems(list, head, items)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.pushes.signal(key, len(items))
+
+	return length, nil
+}
+
+func (s *Storage) pop(ctx context.Context, key string, head bool) (string, bool, error) {
+	defer s.isolate(ctx)()
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	operation := wal.OperationRPop
+	if head {
+		operation = wal.OperationLPop
+	}
+
+	var item string
+	err := s.modifyList(ctx, key, false, func(list *Value, _ bool) error {
+		if err := s.log(ctx, wal.NewRecord(operation, key)); err != nil {
+			return err
+		}
+
+		item, _ = popItem(list, head)
+		return nil
+	})
+	if errors.Is(err, errKeyMissing) {
+		return "", false, nil
+	}
+
+	return item, err == nil, err
+}
+
+// listLength returns the number of items of the list stored at the key
+// without taking the locks of the storage, zero for keys of other types.
+func (s *Storage) listLength(key string) int {
+	var length int
+	_ = s.viewList(context.Background(), key, func(list *Value) {
+		length = list.LLen()
+	})
+
+	return length
+}
+
+// viewList calls fn with the list stored at the key, an empty one for missing
+// keys, or returns ErrWrongType if the key holds another type.
+func (s *Storage) viewList(ctx context.Context, key string, fn func(list *Value)) error {
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		return errTypesUnsupported
+	}
+
+	var wrong bool
+	found := typed.View(ctx, key, func(value *Value) {
+		if wrong = value.Type() != TypeList; !wrong {
+			fn(value)
+		}
+	})
+
+	if wrong {
+		return ErrWrongType
+	}
+
+	if !found {
+		empty := NewList()
+		fn(&empty)
+	}
+
+	return nil
+}
+
+// modifyList calls fn with the list stored at the key like modifyHash does
+// with hashes.
+func (s *Storage) modifyList(ctx context.Context, key string, create bool, fn func(list *Value, found bool) error) error {
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		return errTypesUnsupported
+	}
+
+	return typed.Modify(ctx, key, func(value *Value, found bool) error {
+		switch {
+		case found && value.Type() != TypeList:
+			return ErrWrongType
+		case !found && !create:
+			return errKeyMissing
+		case !found:
+			*value = NewList()
+		}
+
+		return fn(value, found)
+	})
+}
+
+// applyList replays a list record of the write-ahead log.
+func (s *Storage) applyList(ctx context.Context, record wal.Record) error {
+	key, args := record.Args[0], record.Args[1:]
+
+	var err error
+	switch record.Operation {
+	case wal.OperationLPush, wal.OperationRPush, wal.OperationLPushX, wal.OperationRPushX:
+		// a list pushed to may have expired since, it must not be brought back
+		create := record.Operation == wal.OperationLPush || record.Operation == wal.OperationRPush
+		head := record.Operation == wal.OperationLPush || record.Operation == wal.OperationLPushX
+		err = s.modifyList(ctx, key, create, func(list *Value, _ bool) error {
+			pushItems(list, head, args)
+			return nil
+		})
+	case wal.OperationLPop, wal.OperationRPop:
+		err = s.modifyList(ctx, key, false, func(list *Value, _ bool) error {
+			popItem(list, record.Operation == wal.OperationLPop)
+			return nil
+		})
+	case wal.OperationLTrim:
+		start, parseErr := strconv.Atoi(args[0])
+		if parseErr != nil {
+			return parseErr
+		}
+
+		stop, parseErr := strconv.Atoi(args[1])
+		if parseErr != nil {
+			return parseErr
+		}
+
+		err = s.modifyList(ctx, key, false, func(list *Value, _ bool) error {
+			list.LTrim(start, stop)
+			return nil
+		})
+	}
+
+	if errors.Is(err, errKeyMissing) {
+		return nil
+	}
+
+	return err
+}
+
+// listPushRecord returns the record of items pushed to a list, which existed
+// if found.
+func listPushRecord(key string, head, found bool, items []string) wal.Record {
+	var operation wal.Operation
+	switch {
+	case head && found:
+		operation = wal.OperationLPushX
+	case head:
+		operation = wal.OperationLPush
+	case found:
+		operation = wal.OperationRPushX
+	default:
+		operation = wal.OperationRPush
+	}
+
+	return wal.NewRecord(operation, append([]string{key}, items...)...)
+}
+
+func pushItems(list *Value, head bool, items []string) int {
+	if head {
+		return list.LPush(items...)
+	}
+
+	return list.RPush(items...)
+}
+
+func popItem(list *Value, head bool) (string, bool) {
+	if head {
+		return list.LPop()
+	}
+
+	return list.RPop()
+}
+
+// pushWaiters tracks callers waiting for items pushed to lists.
+type pushWaiters struct {
+	mutex sync.Mutex
+	// queues holds the callers waiting for each key, oldest first.
+	queues map[string][]*pushWaiter
+}
+
+type pushWaiter struct {
+	// a single buffered signal is enough to make the caller check the lists
+	pushed chan struct{}
+	// signaled is set once the caller is signaled for a push.
+	signaled bool
+}
+
+// wait queues a caller for the keys. stop removes it from the queues and
+// reports whether it was ever signaled.
+func (p *pushWaiters) wait(keys []string) (<-chan struct{}, func() bool) {
+	waiter := &pushWaiter{pushed: make(chan struct{}, 1)}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.queues == nil {
+		p.queues = make(map[string][]*pushWaiter)
+	}
+
+	for _, key := range keys {
+		if !slices.Contains(p.queues[key], waiter) {
+			p.queues[key] = append(p.queues[key], waiter)
+		}
+	}
+
+	stop := func() bool {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		for _, key := range keys {
+			queue := slices.DeleteFunc(p.queues[key], func(queued *pushWaiter) bool {
+				return queued == waiter
+			})
+
+			if len(queue) == 0 {
+				delete(p.queues, key)
+			} else {
+				p.queues[key] = queue
+			}
+		}
+
+		return waiter.signaled
+	}
+
+	return waiter.pushed, stop
+}
+
+// signal wakes up to count callers waiting for the key, the oldest first.
+// Callers with a signal they have not received yet are skipped, so that a
+// push does not wake up every caller of the key.
+func (p *pushWaiters) signal(key string, count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, waiter := range p.queues[key] {
+		if count == 0 {
+			return
+		}
+
+		select {
+		case waiter.pushed <- struct{}{}:
+			waiter.signaled = true
+			count--
+		default:
+		}
+	}
+}
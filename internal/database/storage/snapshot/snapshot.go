@@ -0,0 +1,302 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+const (
+	fileName    = "dump.kvdb"
+	tmpFileName = "dump.kvdb.tmp"
+
+	formatVersion = 2
+	// stringsFormatVersion snapshots hold only strings, they are read the
+	// same way.
+	stringsFormatVersion = 1
+)
+
+var (
+	magic = [8]byte{'K', 'V', 'D', 'B', 'S', 'N', 'A', 'P'}
+
+	errInvalidLogger    = errors.New("invalid logger")
+	errInvalidDirectory = errors.New("invalid data directory")
+	errCorrupted        = errors.New("corrupted snapshot")
+	crcTable            = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Header describes the state a snapshot was taken at.
+type Header struct {
+	// WALSegment is the first write-ahead log segment that is not covered by the snapshot.
+	WALSegment uint64
+}
+
+// WriteFunc is passed each pair of a snapshot. The value is encoded according
+// to its type, the storage.ValueType it has, see storage.Value.Encode.
+type WriteFunc func(key string, valueType uint8, value string, expiresAt int64) error
+
+// Store keeps the latest snapshot of the database in a directory.
+// Snapshot file layout:
+// [magic][version uint8][wal segment uint64]([value type + 1 uint8][key length uvarint][key][value length uvarint][value][expires at varint])*[0x00][crc32 uint32]
+// where expires at is a deadline in unix milliseconds or zero.
+type Store struct {
+	directory string
+	logger    *zap.Logger
+}
+
+func NewStore(logger *zap.Logger, directory string) (*Store, error) {
+	if logger == nil {
+		return nil, errInvalidLogger
+	}
+
+	if directory == "" {
+		return nil, errInvalidDirectory
+	}
+
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+
+	return &Store{
+		directory: directory,
+		logger:    logger,
+	}, nil
+}
+
+// Save writes a new snapshot produced by fill. The previous snapshot is
+// replaced atomically only once the new one is completely on disk.
+func (s *Store) Save(header Header, fill func(write WriteFunc) error) error {
+	tmpPath := filepath.Join(s.directory, tmpFileName)
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+
+	writer := newWriter(file)
+	if err := writer.writeHeader(header); err == nil {
+		err = fill(writer.writePair)
+	}
+	if err == nil {
+		err = writer.finish()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(s.directory, fileName)); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+
+	s.logger.Info("snapshot saved", zap.Int("keys", writer.count), zap.Uint64("wal_segment", header.WALSegment))
+
+	return nil
+}
+
+// Load reads the latest snapshot passing each pair to apply. It returns
+// a zero header when no snapshot has been saved yet.
+func (s *Store) Load(apply WriteFunc) (Header, error) {
+	file, err := os.Open(filepath.Join(s.directory, fileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return Header{}, nil
+	}
+	if err != nil {
+		return Header{}, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	reader := newReader(file)
+
+	header, err := reader.readHeader()
+	if err != nil {
+		return Header{}, err
+	}
+
+	count := 0
+	for {
+		key, valueType, value, expiresAt, ok, err := reader.readPair()
+		if err != nil {
+			return Header{}, err
+		}
+		if !ok {
+			break
+		}
+
+		if err := apply(key, valueType, value, expiresAt); err != nil {
+			return Header{}, fmt.Errorf("apply snapshot pair: %w", err)
+		}
+		count++
+	}
+
+	if err := reader.verify(); err != nil {
+		return Header{}, err
+	}
+
+	s.logger.Info("snapshot loaded", zap.Int("keys", count), zap.Uint64("wal_segment", header.WALSegment))
+
+	return header, nil
+}
+
+type writer struct {
+	buffer *bufio.Writer
+	crc    hash.Hash32
+	out    io.Writer
+	count  int
+}
+
+func newWriter(w io.Writer) *writer {
+	buffer := bufio.NewWriter(w)
+	crc := crc32.New(crcTable)
+
+	return &writer{
+		buffer: buffer,
+		crc:    crc,
+		out:    io.MultiWriter(buffer, crc),
+	}
+}
+
+func (w *writer) writeHeader(header Header) error {
+	data := make([]byte, 0, len(magic)+9)
+	data = append(data, magic[:]...)
+	data = append(data, formatVersion)
+	data = binary.LittleEndian.AppendUint64(data, header.WALSegment)
+
+	_, err := w.out.Write(data)
+	return err
+}
+
+func (w *writer) writePair(key string, valueType uint8, value string, expiresAt int64) error {
+	data := make([]byte, 0, 1+3*binary.MaxVarintLen64+len(key)+len(value))
+	data = append(data, valueType+1)
+	data = binary.AppendUvarint(data, uint64(len(key)))
+	data = append(data, key...)
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	data = append(data, value...)
+	data = binary.AppendVarint(data, expiresAt)
+
+	w.count++
+	_, err := w.out.Write(data)
+	return err
+}
+
+func (w *writer) finish() error {
+	if _, err := w.out.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	if _, err := w.buffer.Write(binary.LittleEndian.AppendUint32(nil, w.crc.Sum32())); err != nil {
+		return err
+	}
+
+	return w.buffer.Flush()
+}
+
+type reader struct {
+	buffer *bufio.Reader
+	crc    hash.Hash32
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{
+		buffer: bufio.NewReader(r),
+		crc:    crc32.New(crcTable),
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.buffer.Read(p)
+	r.crc.Write(p[:n])
+	return n, err
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	b, err := r.buffer.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *reader) readHeader() (Header, error) {
+	data := make([]byte, len(magic)+9)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Header{}, fmt.Errorf("%w: header: %w", errCorrupted, err)
+	}
+
+	if [8]byte(data[:8]) != magic || (data[8] != formatVersion && data[8] != stringsFormatVersion) {
+		return Header{}, fmt.Errorf("%w: unknown format", errCorrupted)
+	}
+
+	return Header{WALSegment: binary.LittleEndian.Uint64(data[9:])}, nil
+}
+
+func (r *reader) readPair() (key string, valueType uint8, value string, expiresAt int64, ok bool, err error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return "", 0, "", 0, false, fmt.Errorf("%w: %w", errCorrupted, err)
+	}
+
+	if marker == 0 {
+		return "", 0, "", 0, false, nil
+	}
+
+	if key, err = r.readString(); err != nil {
+		return "", 0, "", 0, false, err
+	}
+
+	if value, err = r.readString(); err != nil {
+		return "", 0, "", 0, false, err
+	}
+
+	if expiresAt, err = binary.ReadVarint(r); err != nil {
+		return "", 0, "", 0, false, fmt.Errorf("%w: %w", errCorrupted, err)
+	}
+
+	return key, marker - 1, value, expiresAt, true, nil
+}
+
+func (r *reader) readString() (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errCorrupted, err)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", fmt.Errorf("%w: %w", errCorrupted, err)
+	}
+
+	return string(data), nil
+}
+
+func (r *reader) verify() error {
+	expected := r.crc.Sum32()
+
+	checksum := make([]byte, 4)
+	if _, err := io.ReadFull(r.buffer, checksum); err != nil {
+		return fmt.Errorf("%w: checksum: %w", errCorrupted, err)
+	}
+
+	if binary.LittleEndian.Uint32(checksum) != expected {
+		return fmt.Errorf("%w: checksum mismatch", errCorrupted)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	store, err := NewStore(zap.NewNop(), t.TempDir())
+	require.NoError(t, err)
+
+	t.Run("no snapshot", func(t *testing.T) {
+		header, err := store.Load(func(key string, valueType uint8, value string, expiresAt int64) error {
+			t.Errorf("unexpected pair %q=%q", key, value)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, Header{}, header)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		err := store.Save(Header{WALSegment: 42}, func(write WriteFunc) error {
+			require.NoError(t, write("key1", 0, "value1", 0))
+			require.NoError(t, write("key2", 0, "", 1792308512374))
+			require.NoError(t, write("key3", 1, "\x00", 0))
+			return nil
+		})
+		require.NoError(t, err)
+
+		pairs := make(map[string]string)
+		types := make(map[string]uint8)
+		expirations := make(map[string]int64)
+		header, err := store.Load(func(key string, valueType uint8, value string, expiresAt int64) error {
+			pairs[key] = value
+			types[key] = valueType
+			expirations[key] = expiresAt
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, Header{WALSegment: 42}, header)
+		assert.Equal(t, map[string]string{"key1": "value1", "key2": "", "key3": "\x00"}, pairs)
+		assert.Equal(t, map[string]uint8{"key1": 0, "key2": 0, "key3": 1}, types)
+		assert.Equal(t, map[string]int64{"key1": 0, "key2": 1792308512374, "key3": 0}, expirations)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		path := filepath.Join(store.directory, fileName)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		data[len(data)-6] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+
+		_, err = store.Load(func(key string, valueType uint8, value string, expiresAt int64) error { return nil })
+		assert.ErrorIs(t, err, errCorrupted)
+	})
+}
@@ -3,49 +3,1077 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/snapshot"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
 	"go.uber.org/zap"
 )
 
+const (
+	keyLocksNumber = 64
+	// isolationStripes is the number of locks commands are spread over, so
+	// that they do not contend on a single lock, see isolate.
+	isolationStripes = 64
+	// maxEvictionsPerWrite bounds the work a single write spends on freeing memory.
+	maxEvictionsPerWrite = 128
+)
+
+// NoExpiration is the TTL reported for keys that never expire.
+const NoExpiration = time.Duration(-1)
+
+// ErrNotFound is returned by Get for a missing key.
+var ErrNotFound = errors.New("not found")
+
 var (
-	errNotFound = errors.New("not found")
+	errExpirationUnsupported = errors.New("engine does not support key expiration")
+	errSnapshotsDisabled     = errors.New("snapshots are disabled")
+	errSnapshotsUnsupported  = errors.New("engine does not support snapshots")
+	errDurabilityUnsupported = errors.New("wal and snapshots require an engine supporting snapshots")
+	errSnapshotInProgress    = errors.New("snapshot already in progress")
+	errSnapshotInTransaction = errors.New("snapshots can not be saved inside a transaction")
+	errOutOfMemory           = errors.New("OOM command not allowed when used memory > 'max-memory'")
+	errOrderUnsupported      = errors.New("engine does not support ordered iteration")
+	errKeyMissing            = errors.New("key does not exist")
+	errVersionsUnsupported   = errors.New("engine does not support WATCH")
+	errTypesUnsupported      = errors.New("engine does not support hashes and lists")
 )
 
 type Engine interface {
 	Get(ctx context.Context, key string) (string, bool)
 	Set(ctx context.Context, key, value string)
 	Del(ctx context.Context, key string)
+	// Update atomically replaces the value of the key by the value fn returns
+	// for the current one, keeping the expiration of the key. Missing keys are
+	// passed as not found and created without expiration. Nothing changes when
+	// fn returns an error, which Update returns. fn may be called again if the
+	// key changes meanwhile, only the value of the last call is stored.
+	Update(ctx context.Context, key string, fn UpdateFunc) error
+	// GetDel deletes the key and returns the value it had.
+	GetDel(ctx context.Context, key string) (string, bool)
+}
+
+// ConditionKind is the kind of a Condition.
+type ConditionKind uint8
+
+const (
+	// ConditionNone always holds.
+	ConditionNone ConditionKind = iota
+	// ConditionMissing holds for missing keys.
+	ConditionMissing
+	// ConditionExists holds for existing keys.
+	ConditionExists
+	// ConditionEqual holds for keys whose value is Condition.Value.
+	ConditionEqual
+)
+
+// Condition is checked by conditional writes against the current value of a key.
+type Condition struct {
+	Kind ConditionKind
+	// Value is the expected value of ConditionEqual.
+	Value string
+}
+
+// Holds reports whether the condition holds for the value of a key.
+func (c Condition) Holds(value string, found bool) bool {
+	switch c.Kind {
+	case ConditionMissing:
+		return !found
+	case ConditionExists:
+		return found
+	case ConditionEqual:
+		return found && value == c.Value
+	default:
+		return true
+	}
+}
+
+// SetResult is the outcome of a conditional write.
+type SetResult struct {
+	// Previous is the value of the key before the write, if Found.
+	Previous string
+	Found    bool
+	// Stored reports whether the condition held and the value was stored.
+	Stored bool
+}
+
+// UpdateFunc returns the new value of a key given the current one.
+type UpdateFunc func(value string, found bool) (string, error)
+
+// Expirer is implemented by engines supporting keys with a time to live.
+// Expired keys must never be returned by Engine.Get.
+type Expirer interface {
+	SetWithExpiration(ctx context.Context, key, value string, expiresAt time.Time)
+	Expire(ctx context.Context, key string, expiresAt time.Time) bool
+	Persist(ctx context.Context, key string) bool
+	TTL(ctx context.Context, key string) (time.Duration, bool)
+}
+
+// MemoryLimiter is implemented by engines bounding the memory they use.
+type MemoryLimiter interface {
+	MemoryExceeded() bool
+	// EvictionCandidate picks a key to evict according to the eviction policy,
+	// it reports false when no key may be evicted.
+	EvictionCandidate(ctx context.Context) (string, bool)
+}
+
+// Versioner is implemented by engines tracking versions of keys, which WATCH
+// compares to detect changes.
+type Versioner interface {
+	// Version returns a number that changes whenever the key is modified,
+	// deleted or expires. It may also change when other keys are deleted.
+	Version(ctx context.Context, key string) uint64
+}
+
+// TypedEngine is implemented by engines storing values of other types than
+// strings, such as hashes. Engine methods do not return values of other types
+// and Engine.Update fails with ErrWrongType for them.
+type TypedEngine interface {
+	// View calls fn with the value of the key, which does not change while fn
+	// runs, and reports whether the key exists. fn must not modify the value
+	// or retain it.
+	View(ctx context.Context, key string, fn func(value *Value)) bool
+	// Modify atomically changes the value of the key in place by fn, keeping
+	// the expiration of the key. Missing keys are passed as not found empty
+	// strings and created without expiration. fn returns an error before
+	// changing the value if it fails, nothing changes then and Modify returns
+	// the error. Keys whose value is left empty are deleted.
+	Modify(ctx context.Context, key string, fn func(value *Value, found bool) error) error
+	// SetValue stores the value that expires at the given time, zero meaning
+	// never.
+	SetValue(ctx context.Context, key string, value Value, expiresAt time.Time)
+}
+
+// Snapshotter is implemented by engines able to produce point-in-time snapshots.
+type Snapshotter interface {
+	Snapshot(ctx context.Context) (EngineSnapshot, error)
+}
+
+// PersistentEngine is implemented by engines keeping their own data on disk,
+// which need neither the WAL nor snapshots to survive restarts.
+type PersistentEngine interface {
+	DataDirectory() string
+}
+
+// EngineSnapshot is a consistent view of an engine taken by Snapshotter.
+// Expiration deadlines are passed in unix milliseconds, zero means none.
+type EngineSnapshot interface {
+	ForEach(fn func(key string, value Value, expiresAt int64) error) error
+	Release()
+}
+
+// OrderedEngine is implemented by engines keeping keys in lexicographical order.
+type OrderedEngine interface {
+	// Ascend calls fn for pairs with keys in [start, end) in ascending order
+	// until fn returns false. An empty end means no upper bound.
+	Ascend(ctx context.Context, start, end string, fn func(key, value string) bool)
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type WAL interface {
+	Append(record wal.Record) error
+	Recover(from uint64, apply func(wal.Record) error) error
+	Rotate() (uint64, error)
+	RemoveSegmentsBefore(id uint64) error
+	Close() error
+}
+
+type SnapshotStore interface {
+	Save(header snapshot.Header, fill func(write snapshot.WriteFunc) error) error
+	Load(apply snapshot.WriteFunc) (snapshot.Header, error)
 }
 
 type Storage struct {
-	engine Engine
-	logger *zap.Logger
+	engine    Engine
+	wal       WAL
+	snapshots SnapshotStore
+	logger    *zap.Logger
+
+	// keyLocks keep the order of records in the WAL consistent with
+	// the order in which mutations of the same key reach the engine.
+	keyLocks [keyLocksNumber]sync.Mutex
+	// mutations is held for reading by every mutation and for writing while
+	// a snapshot is started, so the snapshot and the WAL rotation happen at
+	// the same point of the history.
+	mutations sync.RWMutex
+	// snapshotMutex allows only one snapshot at a time.
+	snapshotMutex sync.Mutex
+	// isolation holds locks of which every command holds one for reading,
+	// all of them are held for writing while a transaction runs, see
+	// Atomically.
+	isolation [isolationStripes]isolationStripe
+	// pushes wakes up callers waiting for items pushed to lists.
+	pushes pushWaiters
 }
 
-func New(logger *zap.Logger, engine Engine) (*Storage, error) {
+func New(logger *zap.Logger, engine Engine, options ...Option) (*Storage, error) {
 	if logger == nil {
 		return nil, errors.New("invalid logger")
 	}
 
-	return &Storage{
+	s := &Storage{
 		engine: engine,
 		logger: logger,
-	}, nil
+	}
+
+	for _, opt := range options {
+		opt(s)
+	}
+
+	// only snapshots truncate the WAL, without them it would grow forever
+	// and be replayed in full on every start
+	if _, ok := engine.(Snapshotter); !ok && (s.wal != nil || s.snapshots != nil) {
+		return nil, errDurabilityUnsupported
+	}
+
+	return s, nil
 }
 
 func (s *Storage) Get(ctx context.Context, key string) (string, error) {
+	defer s.isolate(ctx)()
+
 	value, found := s.engine.Get(ctx, key)
 	if found {
 		return value, nil
 	}
 
-	return "", errNotFound
+	if err := s.checkType(ctx, key, TypeString); err != nil {
+		return "", err
+	}
+
+	return "", ErrNotFound
 }
 
-func (s *Storage) Set(ctx context.Context, key, value string) {
+func (s *Storage) Set(ctx context.Context, key, value string) error {
+	defer s.isolate(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	if err := s.log(ctx, wal.NewRecord(wal.OperationSet, key, value)); err != nil {
+		return err
+	}
+
 	s.engine.Set(ctx, key, value)
+
+	return nil
+}
+
+// Update atomically replaces the value of the key by the value fn returns for
+// the current one, see Engine.Update. The new value is logged before it is
+// stored, nothing is logged when fn fails.
+func (s *Storage) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	defer s.isolate(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	return s.engine.Update(ctx, key, func(current string, found bool) (string, error) {
+		value, err := fn(current, found)
+		if err != nil {
+			return "", err
+		}
+
+		// a missing key is created without expiration like by SET, an
+		// existing one keeps its expiration
+		operation := wal.OperationSet
+		if found {
+			operation = wal.OperationUpdate
+		}
+
+		if err := s.log(ctx, wal.NewRecord(operation, key, value)); err != nil {
+			return "", err
+		}
+
+		return value, nil
+	})
 }
 
-func (s *Storage) Del(ctx context.Context, key string) {
-	s.engine.Del(ctx, key)
+// CompareAndSet stores the value and clears the expiration of the key if the
+// condition holds for the current value of the key. The value expires at
+// expiresAt unless it is zero. The condition is checked under the lock of the
+// key, so that only stored values are logged, as plain writes replayed
+// whatever the key holds then.
+func (s *Storage) CompareAndSet(ctx context.Context, key, value string, expiresAt time.Time, condition Condition) (SetResult, error) {
+	defer s.isolate(ctx)()
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok && !expiresAt.IsZero() {
+		return SetResult{}, errExpirationUnsupported
+	}
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return SetResult{}, err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	// writes comparing or returning the previous value require a string
+	if condition.Kind == ConditionNone || condition.Kind == ConditionEqual {
+		if err := s.checkType(ctx, key, TypeString); err != nil {
+			return SetResult{}, err
+		}
+	}
+
+	result := s.lookup(ctx, key)
+	if !condition.Holds(result.Previous, result.Found) {
+		return result, nil
+	}
+
+	if expiresAt.IsZero() {
+		if err := s.log(ctx, wal.NewRecord(wal.OperationSet, key, value)); err != nil {
+			return SetResult{}, err
+		}
+
+		s.engine.Set(ctx, key, value)
+	} else {
+		deadline := strconv.FormatInt(expiresAt.UnixMilli(), 10)
+		if err := s.log(ctx, wal.NewRecord(wal.OperationSetWithExpiration, key, value, deadline)); err != nil {
+			return SetResult{}, err
+		}
+
+		expirer.SetWithExpiration(ctx, key, value, expiresAt)
+	}
+
+	result.Stored = true
+
+	return result, nil
+}
+
+// lookup returns the current value of the key as found before a write, keys
+// holding another type than strings are found without a previous value.
+func (s *Storage) lookup(ctx context.Context, key string) SetResult {
+	var result SetResult
+
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		result.Previous, result.Found = s.engine.Get(ctx, key)
+		return result
+	}
+
+	result.Found = typed.View(ctx, key, func(value *Value) {
+		if value.Type() == TypeString {
+			result.Previous = value.Str()
+		}
+	})
+
+	return result
+}
+
+// GetDel deletes the key and returns the value it had, ErrNotFound if it
+// did not exist.
+func (s *Storage) GetDel(ctx context.Context, key string) (string, error) {
+	defer s.isolate(ctx)()
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	if err := s.checkType(ctx, key, TypeString); err != nil {
+		return "", err
+	}
+
+	if err := s.log(ctx, wal.NewRecord(wal.OperationDel, key)); err != nil {
+		return "", err
+	}
+
+	value, found := s.engine.GetDel(ctx, key)
+	if !found {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// SetWithExpiration stores the value that expires at the given time.
+func (s *Storage) SetWithExpiration(ctx context.Context, key, value string, expiresAt time.Time) error {
+	defer s.isolate(ctx)()
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok {
+		return errExpirationUnsupported
+	}
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	deadline := strconv.FormatInt(expiresAt.UnixMilli(), 10)
+	if err := s.log(ctx, wal.NewRecord(wal.OperationSetWithExpiration, key, value, deadline)); err != nil {
+		return err
+	}
+
+	expirer.SetWithExpiration(ctx, key, value, expiresAt)
+
+	return nil
+}
+
+// Expire sets the expiration time of the key, it reports whether the key exists.
+func (s *Storage) Expire(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	defer s.isolate(ctx)()
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok {
+		return false, errExpirationUnsupported
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	deadline := strconv.FormatInt(expiresAt.UnixMilli(), 10)
+	if err := s.log(ctx, wal.NewRecord(wal.OperationExpire, key, deadline)); err != nil {
+		return false, err
+	}
+
+	return expirer.Expire(ctx, key, expiresAt), nil
+}
+
+// Persist removes the expiration of the key, it reports whether the key had one.
+func (s *Storage) Persist(ctx context.Context, key string) (bool, error) {
+	defer s.isolate(ctx)()
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok {
+		return false, errExpirationUnsupported
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	unlock := s.lockKey(key)
+	defer unlock()
+
+	if err := s.log(ctx, wal.NewRecord(wal.OperationPersist, key)); err != nil {
+		return false, err
+	}
+
+	return expirer.Persist(ctx, key), nil
+}
+
+// TTL returns the remaining time to live of the key or NoExpiration, and
+// whether the key exists.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	defer s.isolate(ctx)()
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok {
+		if _, found := s.engine.Get(ctx, key); found {
+			return NoExpiration, true, nil
+		}
+
+		return 0, false, nil
+	}
+
+	ttl, found := expirer.TTL(ctx, key)
+	return ttl, found, nil
+}
+
+// Range returns pairs with keys in [start, end) in ascending order, an empty
+// end means no upper bound. At most limit pairs are returned unless it is zero.
+func (s *Storage) Range(ctx context.Context, start, end string, limit int) ([]KeyValue, error) {
+	defer s.isolate(ctx)()
+
+	ordered, ok := s.engine.(OrderedEngine)
+	if !ok {
+		return nil, errOrderUnsupported
+	}
+
+	var pairs []KeyValue
+	ordered.Ascend(ctx, start, end, func(key, value string) bool {
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		return limit <= 0 || len(pairs) < limit
+	})
+
+	return pairs, nil
+}
+
+// Del deletes the keys and returns how many of them existed. Several keys are
+// deleted while no other command runs and logged as a single record, so they
+// are never observed or recovered partially deleted.
+func (s *Storage) Del(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) > 1 {
+		defer s.exclusive(ctx)()
+	} else {
+		defer s.isolate(ctx)()
+	}
+
+	return s.del(ctx, keys...)
+}
+
+// del deletes the keys of an isolated command, see isolate.
+func (s *Storage) del(ctx context.Context, keys ...string) (int, error) {
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	// several keys are deleted exclusively, a single one is ordered with
+	// other writes of the key
+	if len(keys) == 1 {
+		unlock := s.lockKey(keys[0])
+		defer unlock()
+	}
+
+	if err := s.log(ctx, wal.NewRecord(wal.OperationDel, keys...)); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if _, found := s.engine.GetDel(ctx, key); found {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// GetMany returns the values of the keys, found reports which of them exist.
+// The keys are read while no batch of writes runs, see SetMany.
+func (s *Storage) GetMany(ctx context.Context, keys []string) (values []string, found []bool) {
+	defer s.isolate(ctx)()
+
+	values, found = make([]string, len(keys)), make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], found[i] = s.engine.Get(ctx, key)
+	}
+
+	return values, found
+}
+
+// SetMany stores the values if the condition holds for every key and reports
+// whether they were stored, clearing expiration of the keys. The values are
+// stored while no other command runs and logged as a single record, so they
+// are never observed or recovered partially stored.
+func (s *Storage) SetMany(ctx context.Context, pairs []KeyValue, condition Condition) (bool, error) {
+	defer s.exclusive(ctx)()
+
+	if err := s.reserveMemory(ctx); err != nil {
+		return false, err
+	}
+
+	s.mutations.RLock()
+	defer s.mutations.RUnlock()
+
+	for _, pair := range pairs {
+		value, found := s.engine.Get(ctx, pair.Key)
+		// keys holding other types exist as well
+		if !found && s.checkType(ctx, pair.Key, TypeString) != nil {
+			found = true
+		}
+
+		if !condition.Holds(value, found) {
+			return false, nil
+		}
+	}
+
+	args := make([]string, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		args = append(args, pair.Key, pair.Value)
+	}
+
+	if err := s.log(ctx, wal.NewRecord(wal.OperationSetMany, args...)); err != nil {
+		return false, err
+	}
+
+	for _, pair := range pairs {
+		s.engine.Set(ctx, pair.Key, pair.Value)
+	}
+
+	return true, nil
+}
+
+// Atomically runs fn while no other command runs, so that fn observes no
+// changes but its own and its changes are observed all at once. Commands run
+// by fn must be passed the context fn is given. Their changes are logged as
+// a single record once fn returns, so they are recovered all together or not
+// at all. If that record cannot be logged, the changes stay in the engine
+// and the error is returned.
+func (s *Storage) Atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	defer s.exclusive(ctx)()
+
+	transaction := &transaction{storage: s}
+	err := fn(context.WithValue(ctx, isolationKey{}, transaction))
+
+	// snapshots wait for transactions, the records follow the last rotation
+	switch len(transaction.records) {
+	case 0:
+	case 1:
+		err = errors.Join(err, s.append(transaction.records[0]))
+	default:
+		err = errors.Join(err, s.append(wal.NewBatchRecord(transaction.records)))
+	}
+
+	return err
+}
+
+// Version returns the version of the key, which changes whenever the key is
+// modified, deleted or expires.
+func (s *Storage) Version(ctx context.Context, key string) (uint64, error) {
+	defer s.isolate(ctx)()
+
+	versioner, ok := s.engine.(Versioner)
+	if !ok {
+		return 0, errVersionsUnsupported
+	}
+
+	return versioner.Version(ctx, key), nil
+}
+
+// Recover restores the engine state from the latest snapshot followed by
+// the write-ahead log records written after it.
+func (s *Storage) Recover(ctx context.Context) error {
+	var header snapshot.Header
+	if s.snapshots != nil {
+		var err error
+		header, err = s.snapshots.Load(func(key string, valueType uint8, data string, expiresAt int64) error {
+			value, err := DecodeValue(ValueType(valueType), data)
+			if err != nil {
+				return err
+			}
+
+			return s.restoreValue(ctx, key, value, expiresAt)
+		})
+		if err != nil {
+			return fmt.Errorf("load snapshot: %w", err)
+		}
+	}
+
+	if s.wal == nil {
+		return nil
+	}
+
+	return s.wal.Recover(header.WALSegment, func(record wal.Record) error {
+		return s.apply(ctx, record)
+	})
+}
+
+// SnapshotsEnabled reports whether the storage was given a snapshot store.
+func (s *Storage) SnapshotsEnabled() bool {
+	return s.snapshots != nil
+}
+
+// Snapshot saves a point-in-time snapshot of the engine, waiting for
+// a snapshot in progress to finish first.
+func (s *Storage) Snapshot(ctx context.Context) error {
+	if err := s.checkSnapshots(); err != nil {
+		return err
+	}
+
+	// the changes of the transaction are in the engine but not logged yet
+	if s.transaction(ctx) != nil {
+		return errSnapshotInTransaction
+	}
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	return s.snapshot(ctx)
+}
+
+// BackgroundSnapshot starts saving a snapshot in a separate goroutine.
+func (s *Storage) BackgroundSnapshot() error {
+	if err := s.checkSnapshots(); err != nil {
+		return err
+	}
+
+	if !s.snapshotMutex.TryLock() {
+		return errSnapshotInProgress
+	}
+
+	go func() {
+		defer s.snapshotMutex.Unlock()
+
+		if err := s.snapshot(context.Background()); err != nil {
+			s.logger.Error("background snapshot failed", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// SnapshotPeriodically saves a snapshot every interval until the context is done.
+// Ticks that happen while another snapshot is in progress are skipped.
+func (s *Storage) SnapshotPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.BackgroundSnapshot(); err != nil && !errors.Is(err, errSnapshotInProgress) {
+				s.logger.Error("scheduled snapshot failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Storage) Close() error {
+	// wait for a snapshot in progress
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	var err error
+	if s.wal != nil {
+		err = s.wal.Close()
+	}
+
+	if closer, ok := s.engine.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+
+	return err
+}
+
+// reserveMemory evicts keys chosen by the engine until its memory limit is
+// respected. Evictions are regular deletions, so they reach the WAL as well.
+// It must be called before any lock of the storage is taken.
+func (s *Storage) reserveMemory(ctx context.Context) error {
+	limiter, ok := s.engine.(MemoryLimiter)
+	if !ok {
+		return nil
+	}
+
+	for evicted := 0; limiter.MemoryExceeded(); evicted++ {
+		if evicted == maxEvictionsPerWrite {
+			return errOutOfMemory
+		}
+
+		key, ok := limiter.EvictionCandidate(ctx)
+		if !ok {
+			return errOutOfMemory
+		}
+
+		// the command reserving memory is already isolated
+		if _, err := s.del(ctx, key); err != nil {
+			return err
+		}
+
+		s.logger.Debug("key evicted", zap.String("key", key))
+	}
+
+	return nil
+}
+
+func (s *Storage) checkSnapshots() error {
+	if s.snapshots == nil {
+		return errSnapshotsDisabled
+	}
+
+	if _, ok := s.engine.(Snapshotter); !ok {
+		return errSnapshotsUnsupported
+	}
+
+	return nil
+}
+
+// snapshot must be called with snapshotMutex held.
+func (s *Storage) snapshot(ctx context.Context) error {
+	engineSnapshot, segment, err := s.beginSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer engineSnapshot.Release()
+
+	err = s.snapshots.Save(snapshot.Header{WALSegment: segment}, func(write snapshot.WriteFunc) error {
+		return engineSnapshot.ForEach(func(key string, value Value, expiresAt int64) error {
+			return write(key, uint8(value.Type()), value.Encode(), expiresAt)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.wal != nil {
+		if err := s.wal.RemoveSegmentsBefore(segment); err != nil {
+			s.logger.Warn("failed to remove wal segments covered by snapshot", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) beginSnapshot(ctx context.Context) (EngineSnapshot, uint64, error) {
+	// a transaction is either part of the snapshot or logged after it
+	defer s.exclusive(ctx)()
+
+	s.mutations.Lock()
+	defer s.mutations.Unlock()
+
+	var segment uint64
+	if s.wal != nil {
+		var err error
+		if segment, err = s.wal.Rotate(); err != nil {
+			return nil, 0, fmt.Errorf("rotate wal: %w", err)
+		}
+	}
+
+	engineSnapshot, err := s.engine.(Snapshotter).Snapshot(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return engineSnapshot, segment, nil
+}
+
+func (s *Storage) apply(ctx context.Context, record wal.Record) error {
+	args := record.Args
+
+	switch {
+	case record.Operation == wal.OperationSet && len(args) == 2:
+		s.engine.Set(ctx, args[0], args[1])
+	case record.Operation == wal.OperationDel && len(args) >= 1:
+		for _, key := range args {
+			s.engine.Del(ctx, key)
+		}
+	case record.Operation == wal.OperationSetMany && len(args) >= 2 && len(args)%2 == 0:
+		for i := 0; i < len(args); i += 2 {
+			s.engine.Set(ctx, args[i], args[i+1])
+		}
+	case (record.Operation == wal.OperationHSet || record.Operation == wal.OperationHUpdate) && len(args) >= 3 && len(args)%2 == 1,
+		record.Operation == wal.OperationHDel && len(args) >= 2:
+		return s.applyHash(ctx, record)
+	case record.Operation >= wal.OperationLPush && record.Operation <= wal.OperationRPushX && len(args) >= 2,
+		(record.Operation == wal.OperationLPop || record.Operation == wal.OperationRPop) && len(args) == 1,
+		record.Operation == wal.OperationLTrim && len(args) == 3:
+		return s.applyList(ctx, record)
+	case record.Operation == wal.OperationSetWithExpiration && len(args) == 3:
+		expiresAt, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse expiration: %w", err)
+		}
+
+		return s.restore(ctx, args[0], args[1], expiresAt)
+	case record.Operation == wal.OperationExpire && len(args) == 2:
+		expiresAt, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse expiration: %w", err)
+		}
+
+		if expirer, ok := s.engine.(Expirer); ok {
+			expirer.Expire(ctx, args[0], time.UnixMilli(expiresAt))
+		}
+	case record.Operation == wal.OperationPersist && len(args) == 1:
+		if expirer, ok := s.engine.(Expirer); ok {
+			expirer.Persist(ctx, args[0])
+		}
+	case record.Operation == wal.OperationBatch:
+		records, err := record.Records()
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if err := s.apply(ctx, record); err != nil {
+				return err
+			}
+		}
+	case record.Operation == wal.OperationUpdate && len(args) == 2:
+		// the key may have expired since, it must not be brought back
+		err := s.engine.Update(ctx, args[0], func(_ string, found bool) (string, error) {
+			if !found {
+				return "", errKeyMissing
+			}
+			return args[1], nil
+		})
+		if err != nil && !errors.Is(err, errKeyMissing) {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected record: operation %d with %d arguments", record.Operation, len(args))
+	}
+
+	return nil
+}
+
+// restore puts a recovered value into the engine bypassing the WAL.
+func (s *Storage) restore(ctx context.Context, key, value string, expiresAt int64) error {
+	if expiresAt == 0 {
+		s.engine.Set(ctx, key, value)
+		return nil
+	}
+
+	expirer, ok := s.engine.(Expirer)
+	if !ok {
+		return errExpirationUnsupported
+	}
+
+	expirer.SetWithExpiration(ctx, key, value, time.UnixMilli(expiresAt))
+
+	return nil
+}
+
+// restoreValue restores a value of any type, see restore.
+func (s *Storage) restoreValue(ctx context.Context, key string, value Value, expiresAt int64) error {
+	if value.Type() == TypeString {
+		return s.restore(ctx, key, value.Str(), expiresAt)
+	}
+
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		return errTypesUnsupported
+	}
+
+	var deadline time.Time
+	if expiresAt != 0 {
+		deadline = time.UnixMilli(expiresAt)
+	}
+
+	typed.SetValue(ctx, key, value, deadline)
+
+	return nil
+}
+
+// checkType returns ErrWrongType if the key holds a value of another type.
+// Engines storing only strings hold no other types.
+func (s *Storage) checkType(ctx context.Context, key string, valueType ValueType) error {
+	typed, ok := s.engine.(TypedEngine)
+	if !ok {
+		if valueType == TypeString {
+			return nil
+		}
+		return errTypesUnsupported
+	}
+
+	var wrong bool
+	typed.View(ctx, key, func(value *Value) {
+		wrong = value.Type() != valueType
+	})
+
+	if wrong {
+		return ErrWrongType
+	}
+
+	return nil
+}
+
+// log appends the record to the WAL. Records of a transaction are kept
+// until it ends, see Atomically.
+func (s *Storage) log(ctx context.Context, record wal.Record) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	if transaction := s.transaction(ctx); transaction != nil {
+		transaction.records = append(transaction.records, record)
+		return nil
+	}
+
+	return s.append(record)
+}
+
+func (s *Storage) append(record wal.Record) error {
+	if err := s.wal.Append(record); err != nil {
+		s.logger.Error("failed to append to wal", zap.Error(err))
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+
+	return nil
+}
+
+type isolationKey struct{}
+
+// transaction is the state of the commands run by Atomically.
+type transaction struct {
+	storage *Storage
+	// records are logged at once when the transaction ends.
+	records []wal.Record
+}
+
+// transaction returns the transaction the context runs in, nil outside of
+// transactions of the storage.
+func (s *Storage) transaction(ctx context.Context) *transaction {
+	if transaction, ok := ctx.Value(isolationKey{}).(*transaction); ok && transaction.storage == s {
+		return transaction
+	}
+
+	return nil
+}
+
+type isolationStripe struct {
+	sync.RWMutex
+	// keeps stripes on separate cache lines
+	_ [40]byte
+}
+
+// isolate waits for a running transaction to finish and keeps the next one
+// from starting until the returned function is called. Commands of the
+// running transaction itself are not isolated from it. A command holds a
+// single random stripe, so commands on different keys rarely share a lock.
+func (s *Storage) isolate(ctx context.Context) func() {
+	if s.transaction(ctx) != nil {
+		return func() {}
+	}
+
+	stripe := &s.isolation[rand.Uint32()%isolationStripes]
+	stripe.RLock()
+
+	return stripe.RUnlock
+}
+
+// exclusive waits for other commands to finish and keeps new ones from
+// starting until the returned function is called, like Atomically.
+func (s *Storage) exclusive(ctx context.Context) func() {
+	if s.transaction(ctx) != nil {
+		return func() {}
+	}
+
+	for i := range s.isolation {
+		s.isolation[i].Lock()
+	}
+
+	return func() {
+		for i := range s.isolation {
+			s.isolation[i].Unlock()
+		}
+	}
+}
+
+func (s *Storage) lockKey(key string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+
+	mutex := &s.keyLocks[hash.Sum32()%keyLocksNumber]
+	mutex.Lock()
+
+	return mutex.Unlock
 }
@@ -0,0 +1,15 @@
+package storage
+
+type Option func(*Storage)
+
+func WithWAL(wal WAL) Option {
+	return func(s *Storage) {
+		s.wal = wal
+	}
+}
+
+func WithSnapshots(store SnapshotStore) Option {
+	return func(s *Storage) {
+		s.snapshots = store
+	}
+}
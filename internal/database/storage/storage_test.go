@@ -0,0 +1,90 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/in_memory"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// openStorage opens a storage logging to the directory and recovers it.
+func openStorage(t *testing.T, directory string) *storage.Storage {
+	t.Helper()
+
+	logger := zap.NewNop()
+
+	log, err := wal.New(logger, wal.WithDataDirectory(directory), wal.WithSyncPolicy(wal.SyncAlways))
+	require.NoError(t, err)
+
+	s, err := storage.New(logger, inmemory.NewEngine(logger), storage.WithWAL(log))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	require.NoError(t, s.Recover(context.Background()))
+
+	return s
+}
+
+func TestAtomicallyRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	transaction := func(s *storage.Storage) {
+		err := s.Atomically(ctx, func(ctx context.Context) error {
+			if err := s.Set(ctx, "from", "0"); err != nil {
+				return err
+			}
+			return s.Set(ctx, "to", "100")
+		})
+		require.NoError(t, err)
+	}
+
+	get := func(s *storage.Storage, key string) string {
+		value, err := s.Get(ctx, key)
+		if err != nil {
+			return err.Error()
+		}
+		return value
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		directory := t.TempDir()
+
+		s := openStorage(t, directory)
+		require.NoError(t, s.Set(ctx, "from", "100"))
+		transaction(s)
+		require.NoError(t, s.Close())
+
+		s = openStorage(t, directory)
+		assert.Equal(t, "0", get(s, "from"))
+		assert.Equal(t, "100", get(s, "to"))
+	})
+
+	t.Run("cut off", func(t *testing.T) {
+		directory := t.TempDir()
+
+		s := openStorage(t, directory)
+		require.NoError(t, s.Set(ctx, "from", "100"))
+		transaction(s)
+		require.NoError(t, s.Close())
+
+		// the log ends in the middle of the transaction
+		segments, err := filepath.Glob(filepath.Join(directory, "*"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+
+		info, err := os.Stat(segments[0])
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(segments[0], info.Size()-4))
+
+		s = openStorage(t, directory)
+		assert.Equal(t, "100", get(s, "from"))
+		assert.Equal(t, "not found", get(s, "to"))
+	})
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"slices"
+)
+
+// ValueType is the type of a value stored by an engine.
+type ValueType uint8
+
+const (
+	TypeString ValueType = iota
+	TypeHash
+	TypeList
+)
+
+const (
+	// hashFieldOverhead approximates the memory taken by the map slot of a field.
+	hashFieldOverhead = 48
+	// listItemOverhead approximates the memory taken by the slot of a list item.
+	listItemOverhead = 16
+)
+
+// ErrWrongType is returned by commands run against a key holding a value of
+// another type. Like in Redis, the message starts with its error code.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+var errCorruptedValue = errors.New("corrupted value")
+
+// Value is a value of any type stored by a TypedEngine. The zero value is an
+// empty string.
+type Value struct {
+	valueType ValueType
+	str       string
+	hash      map[string]string
+	// list keeps the items of a list in list[head:], the free slots before
+	// head let LPush insert items without moving the others.
+	list []string
+	head int
+	// size is the memory taken by the data of the value in bytes.
+	size int64
+}
+
+// NewString returns a string value.
+func NewString(value string) Value {
+	return Value{valueType: TypeString, str: value, size: int64(len(value))}
+}
+
+// NewHash returns an empty hash.
+func NewHash() Value {
+	return Value{valueType: TypeHash, hash: make(map[string]string)}
+}
+
+// NewList returns an empty list.
+func NewList() Value {
+	return Value{valueType: TypeList}
+}
+
+func (v *Value) Type() ValueType {
+	return v.valueType
+}
+
+// Str returns the value of a string.
+func (v *Value) Str() string {
+	return v.str
+}
+
+// Size returns the memory taken by the data of the value in bytes.
+func (v *Value) Size() int64 {
+	return v.size
+}
+
+// Empty reports whether the value is a collection without elements, keys
+// holding such values are deleted.
+func (v *Value) Empty() bool {
+	switch v.valueType {
+	case TypeHash:
+		return len(v.hash) == 0
+	case TypeList:
+		return v.LLen() == 0
+	default:
+		return false
+	}
+}
+
+// Clone returns a copy of the value that does not share its data.
+func (v *Value) Clone() Value {
+	clone := *v
+	if v.hash != nil {
+		clone.hash = maps.Clone(v.hash)
+	}
+	if v.list != nil {
+		clone.list, clone.head = slices.Clone(v.items()), 0
+	}
+
+	return clone
+}
+
+// HGet returns the value of the field of a hash.
+func (v *Value) HGet(field string) (string, bool) {
+	value, ok := v.hash[field]
+	return value, ok
+}
+
+// HSet sets the field of a hash and reports whether the field is new.
+func (v *Value) HSet(field, value string) bool {
+	previous, ok := v.hash[field]
+	if ok {
+		v.size -= int64(len(previous))
+	} else {
+		v.size += int64(hashFieldOverhead + len(field))
+	}
+
+	v.hash[field] = value
+	v.size += int64(len(value))
+
+	return !ok
+}
+
+// HDel deletes the field of a hash and reports whether it existed.
+func (v *Value) HDel(field string) bool {
+	previous, ok := v.hash[field]
+	if !ok {
+		return false
+	}
+
+	delete(v.hash, field)
+	v.size -= int64(hashFieldOverhead + len(field) + len(previous))
+
+	return true
+}
+
+// HLen returns the number of fields of a hash.
+func (v *Value) HLen() int {
+	return len(v.hash)
+}
+
+// HGetAll returns the fields of a hash with their values ordered by field.
+func (v *Value) HGetAll() []KeyValue {
+	pairs := make([]KeyValue, 0, len(v.hash))
+	for _, field := range slices.Sorted(maps.Keys(v.hash)) {
+		pairs = append(pairs, KeyValue{Key: field, Value: v.hash[field]})
+	}
+
+	return pairs
+}
+
+// LPush inserts the items at the head of a list one after another, so the
+// last item ends up first, and returns the length of the list.
+func (v *Value) LPush(items ...string) int {
+	if v.head < len(items) {
+		v.growHead(len(items))
+	}
+
+	for _, item := range items {
+		v.head--
+		v.list[v.head] = item
+	}
+	v.size += listItemsSize(items)
+
+	return v.LLen()
+}
+
+// growHead moves the items of a list to a new slice with at least n free
+// slots before them. The free slots are at least as many as the items, so
+// series of LPush calls copy every item a constant number of times.
+func (v *Value) growHead(n int) {
+	items := v.items()
+	head := max(n, len(items))
+
+	list := make([]string, head+len(items))
+	copy(list[head:], items)
+
+	v.list, v.head = list, head
+}
+
+// RPush appends the items to the tail of a list and returns its length.
+func (v *Value) RPush(items ...string) int {
+	// reuse the slots freed by LPop rather than grow the list past them,
+	// which is paid for by the pops as there are more of them than items
+	if len(v.list)+len(items) > cap(v.list) && v.head > v.LLen() {
+		length := copy(v.list, v.items())
+		clear(v.list[length:])
+		v.list, v.head = v.list[:length], 0
+	}
+
+	v.list = append(v.list, items...)
+	v.size += listItemsSize(items)
+
+	return v.LLen()
+}
+
+// LPop removes and returns the first item of a list.
+func (v *Value) LPop() (string, bool) {
+	if v.LLen() == 0 {
+		return "", false
+	}
+
+	item := v.list[v.head]
+	v.list[v.head] = ""
+	v.head++
+	v.size -= listItemsSize([]string{item})
+
+	return item, true
+}
+
+// RPop removes and returns the last item of a list.
+func (v *Value) RPop() (string, bool) {
+	if v.LLen() == 0 {
+		return "", false
+	}
+
+	last := len(v.list) - 1
+	item := v.list[last]
+	v.list[last] = ""
+	v.list = v.list[:last]
+	v.size -= listItemsSize([]string{item})
+
+	return item, true
+}
+
+// LLen returns the number of items of a list.
+func (v *Value) LLen() int {
+	return len(v.list) - v.head
+}
+
+// LIndex returns the item of a list at the index, negative indexes count
+// from the tail, -1 being the last item.
+func (v *Value) LIndex(index int) (string, bool) {
+	items := v.items()
+	if index < 0 {
+		index += len(items)
+	}
+
+	if index < 0 || index >= len(items) {
+		return "", false
+	}
+
+	return items[index], true
+}
+
+// LRange returns the items of a list from start to stop inclusive, the
+// indexes are interpreted like in LIndex and clamped to the list.
+func (v *Value) LRange(start, stop int) []string {
+	start, stop = v.listBounds(start, stop)
+	return slices.Clone(v.items()[start:stop])
+}
+
+// LTrim keeps the items of a list from start to stop inclusive only, the
+// indexes are interpreted like in LRange.
+func (v *Value) LTrim(start, stop int) {
+	items := v.items()
+	start, stop = v.listBounds(start, stop)
+
+	v.size -= listItemsSize(items[:start]) + listItemsSize(items[stop:])
+	v.list, v.head = slices.Clone(items[start:stop]), 0
+}
+
+// items returns the items of a list, sharing them with the value.
+func (v *Value) items() []string {
+	return v.list[v.head:]
+}
+
+// listBounds returns the bounds in items of the items from start to stop
+// inclusive.
+func (v *Value) listBounds(start, stop int) (int, int) {
+	length := v.LLen()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+
+	start, stop = max(start, 0), min(stop+1, length)
+	if start >= stop {
+		return 0, 0
+	}
+
+	return start, stop
+}
+
+func listItemsSize(items []string) int64 {
+	var size int64
+	for _, item := range items {
+		size += int64(listItemOverhead + len(item))
+	}
+
+	return size
+}
+
+// Encode returns the value as a string, strings are returned as is, hashes
+// as the number of fields followed by length-prefixed fields and values and
+// lists as the number of items followed by length-prefixed items, see
+// DecodeValue.
+func (v *Value) Encode() string {
+	if v.valueType == TypeString {
+		return v.str
+	}
+
+	data := make([]byte, 0, binary.MaxVarintLen64+v.size)
+	switch v.valueType {
+	case TypeHash:
+		data = binary.AppendUvarint(data, uint64(len(v.hash)))
+		for field, value := range v.hash {
+			data = appendString(data, field)
+			data = appendString(data, value)
+		}
+	case TypeList:
+		data = binary.AppendUvarint(data, uint64(v.LLen()))
+		for _, item := range v.items() {
+			data = appendString(data, item)
+		}
+	}
+
+	return string(data)
+}
+
+// DecodeValue decodes a value of the type encoded by Value.Encode.
+func DecodeValue(valueType ValueType, data string) (Value, error) {
+	switch valueType {
+	case TypeString:
+		return NewString(data), nil
+	case TypeHash:
+		hash := NewHash()
+
+		count, data, ok := readUvarint(data)
+		for ; ok && count > 0; count-- {
+			var field, value string
+			if field, data, ok = readString(data); ok {
+				value, data, ok = readString(data)
+			}
+			if ok {
+				hash.HSet(field, value)
+			}
+		}
+
+		if !ok || len(data) > 0 {
+			return Value{}, errCorruptedValue
+		}
+
+		return hash, nil
+	case TypeList:
+		list := NewList()
+
+		count, data, ok := readUvarint(data)
+		for ; ok && count > 0; count-- {
+			var item string
+			if item, data, ok = readString(data); ok {
+				list.RPush(item)
+			}
+		}
+
+		if !ok || len(data) > 0 {
+			return Value{}, errCorruptedValue
+		}
+
+		return list, nil
+	default:
+		return Value{}, errCorruptedValue
+	}
+}
+
+func appendString(data []byte, value string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+func readUvarint(data string) (uint64, string, bool) {
+	number, n := binary.Uvarint([]byte(data[:min(len(data), binary.MaxVarintLen64)]))
+	if n <= 0 {
+		return 0, data, false
+	}
+
+	return number, data[n:], true
+}
+
+func readString(data string) (string, string, bool) {
+	length, data, ok := readUvarint(data)
+	if !ok || length > uint64(len(data)) {
+		return "", data, false
+	}
+
+	return data[:length], data[length:], true
+}
@@ -0,0 +1,73 @@
+package storage_test
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListRandomOperations(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+
+	list := storage.NewList()
+	var expected []string
+
+	for i := range 20_000 {
+		item := strconv.Itoa(i)
+
+		switch random.IntN(6) {
+		case 0, 1:
+			list.LPush(item, item+"'")
+			expected = slices.Insert(expected, 0, item+"'", item)
+		case 2, 3:
+			list.RPush(item)
+			expected = append(expected, item)
+		case 4:
+			popped, ok := list.LPop()
+			require.Equal(t, len(expected) > 0, ok)
+			if ok {
+				require.Equal(t, expected[0], popped)
+				expected = expected[1:]
+			}
+		case 5:
+			popped, ok := list.RPop()
+			require.Equal(t, len(expected) > 0, ok)
+			if ok {
+				require.Equal(t, expected[len(expected)-1], popped)
+				expected = expected[:len(expected)-1]
+			}
+		}
+
+		require.Equal(t, len(expected), list.LLen())
+	}
+
+	assert.Equal(t, expected, list.LRange(0, -1))
+
+	decoded, err := storage.DecodeValue(storage.TypeList, list.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, expected, decoded.LRange(0, -1))
+	assert.Equal(t, list.Size(), decoded.Size())
+}
+
+func TestListPushAllocations(t *testing.T) {
+	for name, push := range map[string]func(list *storage.Value){
+		"lpush": func(list *storage.Value) { list.LPush("item") },
+		"rpush": func(list *storage.Value) { list.RPush("item") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			list := storage.NewList()
+			for range 1000 {
+				push(&list)
+			}
+
+			// pushes reallocate the list only when its room runs out
+			allocations := testing.AllocsPerRun(1000, func() { push(&list) })
+			assert.Less(t, allocations, 0.1)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Operation identifies a mutating operation stored in the log.
+type Operation uint8
+
+const (
+	OperationUnknown Operation = iota
+	OperationSet
+	// OperationDel stores one or more keys.
+	OperationDel
+	// OperationSetWithExpiration stores key, value and deadline in unix milliseconds.
+	OperationSetWithExpiration
+	// OperationExpire stores key and deadline in unix milliseconds.
+	OperationExpire
+	OperationPersist
+	// OperationUpdate stores key and value replacing the value of an existing
+	// key, keeping its expiration.
+	OperationUpdate
+	// OperationCompareAndSet is not written anymore, conditional writes are
+	// logged as OperationSet or OperationSetWithExpiration once stored. It
+	// keeps its number so that the following operations keep theirs.
+	OperationCompareAndSet
+	// OperationSetMany stores keys and values interleaved.
+	OperationSetMany
+	// OperationHSet stores the key of a hash followed by fields and values
+	// interleaved, the hash is created if missing.
+	OperationHSet
+	// OperationHUpdate is OperationHSet of an existing hash, which is not
+	// created again if it has expired since.
+	OperationHUpdate
+	// OperationHDel stores the key of a hash followed by fields.
+	OperationHDel
+	// OperationLPush stores the key of a list followed by items pushed to its
+	// head, the list is created if missing.
+	OperationLPush
+	// OperationRPush is OperationLPush pushing to the tail.
+	OperationRPush
+	// OperationLPushX is OperationLPush of an existing list, which is not
+	// created again if it has expired since.
+	OperationLPushX
+	// OperationRPushX is OperationLPushX pushing to the tail.
+	OperationRPushX
+	// OperationLPop stores the key of a list whose first item is removed.
+	OperationLPop
+	// OperationRPop stores the key of a list whose last item is removed.
+	OperationRPop
+	// OperationLTrim stores the key of a list followed by the start and stop
+	// indexes of the items kept.
+	OperationLTrim
+	// OperationBatch stores records written together, such as the writes of
+	// a transaction, each encoded as an argument. See NewBatchRecord.
+	OperationBatch
+)
+
+const recordHeaderSize = 8
+
+var (
+	errCorruptedRecord = errors.New("corrupted record")
+	crcTable           = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Record is a single entry of the write-ahead log.
+type Record struct {
+	Operation Operation
+	Args      []string
+}
+
+func NewRecord(operation Operation, args ...string) Record {
+	return Record{
+		Operation: operation,
+		Args:      args,
+	}
+}
+
+// NewBatchRecord returns a record holding the records, which are recovered
+// all together or not at all.
+func NewBatchRecord(records []Record) Record {
+	args := make([]string, 0, len(records))
+	for _, record := range records {
+		args = append(args, string(record.encode()[recordHeaderSize:]))
+	}
+
+	return NewRecord(OperationBatch, args...)
+}
+
+// Records returns the records held by a batch record.
+func (r Record) Records() ([]Record, error) {
+	records := make([]Record, 0, len(r.Args))
+	for _, arg := range r.Args {
+		record, err := decodePayload([]byte(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// encode serializes the record as
+// [payload length uint32][crc32 uint32][operation uint8][args count uvarint]([arg length uvarint][arg])*
+func (r Record) encode() []byte {
+	size := 1 + binary.MaxVarintLen64
+	for _, arg := range r.Args {
+		size += binary.MaxVarintLen64 + len(arg)
+	}
+
+	buffer := make([]byte, recordHeaderSize, recordHeaderSize+size)
+	buffer = append(buffer, byte(r.Operation))
+	buffer = binary.AppendUvarint(buffer, uint64(len(r.Args)))
+	for _, arg := range r.Args {
+		buffer = binary.AppendUvarint(buffer, uint64(len(arg)))
+		buffer = append(buffer, arg...)
+	}
+
+	payload := buffer[recordHeaderSize:]
+	binary.LittleEndian.PutUint32(buffer[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buffer[4:8], crc32.Checksum(payload, crcTable))
+
+	return buffer
+}
+
+// decodeRecord reads the next record from the reader holding remaining bytes.
+// It returns io.EOF when the reader is exhausted on a record boundary, and
+// io.ErrUnexpectedEOF or errCorruptedRecord when the tail of the log is torn.
+func decodeRecord(reader *bufio.Reader, remaining int64) (Record, int, error) {
+	header := make([]byte, recordHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) && n == 0 {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+
+	// a torn header must not make replay allocate more than the log holds
+	if int64(length) > remaining-recordHeaderSize {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return Record{}, 0, errCorruptedRecord
+	}
+
+	record, err := decodePayload(payload)
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	return record, recordHeaderSize + int(length), nil
+}
+
+func decodePayload(payload []byte) (Record, error) {
+	if len(payload) == 0 {
+		return Record{}, errCorruptedRecord
+	}
+
+	record := Record{Operation: Operation(payload[0])}
+	payload = payload[1:]
+
+	// every argument takes at least a byte for its length
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)-n) {
+		return Record{}, errCorruptedRecord
+	}
+	payload = payload[n:]
+
+	record.Args = make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < length {
+			return Record{}, fmt.Errorf("%w: argument %d", errCorruptedRecord, i)
+		}
+		payload = payload[n:]
+
+		record.Args = append(record.Args, string(payload[:length]))
+		payload = payload[length:]
+	}
+
+	return record, nil
+}
@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentPrefix    = "wal_"
+	segmentExtension = ".log"
+)
+
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64
+}
+
+func segmentFileName(id uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentExtension)
+}
+
+func parseSegmentFileName(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExtension) {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExtension), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// listSegments returns identifiers of the segments stored in the directory in ascending order.
+func listSegments(directory string) ([]uint64, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("read wal directory: %w", err)
+	}
+
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if id, ok := parseSegmentFileName(entry.Name()); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func createSegment(directory string, id uint64) (*segment, error) {
+	path := filepath.Join(directory, segmentFileName(id))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create wal segment: %w", err)
+	}
+
+	return &segment{
+		id:   id,
+		file: file,
+	}, nil
+}
+
+func (s *segment) write(data []byte) error {
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write wal segment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *segment) sync() error {
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal segment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *segment) close() error {
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return fmt.Errorf("sync wal segment: %w", err)
+	}
+
+	return s.file.Close()
+}
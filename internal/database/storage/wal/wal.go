@@ -0,0 +1,349 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SyncPolicy controls when appended records are fsynced to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs the segment after every appended record.
+	SyncAlways SyncPolicy = "always"
+	// SyncBatch fsyncs once the batch size is reached or the batch timeout expires.
+	SyncBatch SyncPolicy = "batch"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+var (
+	errInvalidLogger     = errors.New("invalid logger")
+	errInvalidDirectory  = errors.New("invalid data directory")
+	errInvalidSyncPolicy = errors.New("invalid sync policy")
+	errClosed            = errors.New("wal is closed")
+)
+
+func ParseSyncPolicy(policy string) (SyncPolicy, error) {
+	switch SyncPolicy(policy) {
+	case SyncAlways, SyncBatch, SyncNever:
+		return SyncPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w: %q", errInvalidSyncPolicy, policy)
+	}
+}
+
+// WAL is a segmented append-only log of mutating operations.
+type WAL struct {
+	mutex sync.Mutex
+
+	directory      string
+	syncPolicy     SyncPolicy
+	batchSize      int
+	batchTimeout   time.Duration
+	maxSegmentSize int64
+
+	segment *segment
+	pending int
+	closed  bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	logger *zap.Logger
+}
+
+func New(logger *zap.Logger, options ...Option) (*WAL, error) {
+	if logger == nil {
+		return nil, errInvalidLogger
+	}
+
+	w := &WAL{
+		syncPolicy:     SyncBatch,
+		batchSize:      100,
+		batchTimeout:   10 * time.Millisecond,
+		maxSegmentSize: 10 << 20,
+		done:           make(chan struct{}),
+		logger:         logger,
+	}
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	if w.directory == "" {
+		return nil, errInvalidDirectory
+	}
+
+	if _, err := ParseSyncPolicy(string(w.syncPolicy)); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(w.directory, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal directory: %w", err)
+	}
+
+	ids, err := listSegments(w.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var nextID uint64 = 1
+	if len(ids) > 0 {
+		nextID = ids[len(ids)-1] + 1
+	}
+
+	w.segment, err = createSegment(w.directory, nextID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.syncPolicy == SyncBatch && w.batchTimeout > 0 {
+		w.wg.Add(1)
+		go w.syncPeriodically()
+	}
+
+	return w, nil
+}
+
+// Append writes the record to the active segment and syncs it according to the policy.
+func (w *WAL) Append(record Record) error {
+	data := record.encode()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return errClosed
+	}
+
+	if w.segment.size > 0 && w.segment.size+int64(len(data)) > w.maxSegmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.segment.write(data); err != nil {
+		return err
+	}
+
+	switch w.syncPolicy {
+	case SyncAlways:
+		return w.segment.sync()
+	case SyncBatch:
+		w.pending++
+		if w.pending >= w.batchSize {
+			return w.sync()
+		}
+	}
+
+	return nil
+}
+
+// Recover replays records of segments starting from the given one that were
+// written before this WAL was opened. A torn record at the tail of the last
+// segment is truncated away.
+func (w *WAL) Recover(from uint64, apply func(Record) error) error {
+	w.mutex.Lock()
+	activeID := w.segment.id
+	w.mutex.Unlock()
+
+	ids, err := listSegments(w.directory)
+	if err != nil {
+		return err
+	}
+
+	var segments []uint64
+	for _, id := range ids {
+		if id >= from && id < activeID {
+			segments = append(segments, id)
+		}
+	}
+
+	replayed := 0
+	for i, id := range segments {
+		count, err := w.replaySegment(id, i == len(segments)-1, apply)
+		replayed += count
+		if err != nil {
+			return err
+		}
+	}
+
+	w.logger.Info("wal recovered", zap.Int("segments", len(segments)), zap.Int("records", replayed))
+
+	return nil
+}
+
+func (w *WAL) replaySegment(id uint64, last bool, apply func(Record) error) (int, error) {
+	path := filepath.Join(w.directory, segmentFileName(id))
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open wal segment: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat wal segment: %w", err)
+	}
+
+	var (
+		reader = bufio.NewReader(file)
+		offset int64
+		count  int
+	)
+
+	for {
+		record, n, err := decodeRecord(reader, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+
+		if err != nil {
+			if !last {
+				return count, fmt.Errorf("wal segment %s at offset %d: %w", path, offset, err)
+			}
+
+			w.logger.Warn("truncating torn wal tail",
+				zap.String("segment", path),
+				zap.Int64("offset", offset),
+				zap.Error(err))
+
+			if err := os.Truncate(path, offset); err != nil {
+				return count, fmt.Errorf("truncate wal segment: %w", err)
+			}
+
+			return count, nil
+		}
+
+		if err := apply(record); err != nil {
+			return count, fmt.Errorf("apply wal record: %w", err)
+		}
+
+		offset += int64(n)
+		count++
+	}
+}
+
+// Rotate starts a new segment and returns its identifier, so every record
+// appended before the call is stored in a segment with a smaller identifier.
+func (w *WAL) Rotate() (uint64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, errClosed
+	}
+
+	if w.segment.size == 0 {
+		return w.segment.id, nil
+	}
+
+	if err := w.rotate(); err != nil {
+		return 0, err
+	}
+
+	return w.segment.id, nil
+}
+
+// RemoveSegmentsBefore deletes segments that are no longer needed for recovery.
+func (w *WAL) RemoveSegmentsBefore(id uint64) error {
+	ids, err := listSegments(w.directory)
+	if err != nil {
+		return err
+	}
+
+	for _, segmentID := range ids {
+		if segmentID >= id {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(w.directory, segmentFileName(segmentID))); err != nil {
+			return fmt.Errorf("remove wal segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close syncs and closes the active segment and stops background flushing.
+func (w *WAL) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	empty := w.segment.size == 0
+	if err := w.segment.close(); err != nil {
+		return err
+	}
+
+	if empty {
+		return os.Remove(filepath.Join(w.directory, segmentFileName(w.segment.id)))
+	}
+
+	return nil
+}
+
+func (w *WAL) syncPeriodically() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.batchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			if !w.closed && w.pending > 0 {
+				if err := w.sync(); err != nil {
+					w.logger.Error("failed to sync wal", zap.Error(err))
+				}
+			}
+			w.mutex.Unlock()
+		}
+	}
+}
+
+// sync must be called with the mutex held.
+func (w *WAL) sync() error {
+	w.pending = 0
+	return w.segment.sync()
+}
+
+// rotate must be called with the mutex held.
+func (w *WAL) rotate() error {
+	if err := w.segment.close(); err != nil {
+		return err
+	}
+
+	next, err := createSegment(w.directory, w.segment.id+1)
+	if err != nil {
+		return err
+	}
+
+	w.segment = next
+	w.pending = 0
+
+	return nil
+}
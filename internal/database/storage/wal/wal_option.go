@@ -0,0 +1,35 @@
+package wal
+
+import "time"
+
+type Option func(*WAL)
+
+func WithDataDirectory(directory string) Option {
+	return func(w *WAL) {
+		w.directory = directory
+	}
+}
+
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(w *WAL) {
+		w.syncPolicy = policy
+	}
+}
+
+func WithFlushingBatchSize(size int) Option {
+	return func(w *WAL) {
+		w.batchSize = size
+	}
+}
+
+func WithFlushingBatchTimeout(timeout time.Duration) Option {
+	return func(w *WAL) {
+		w.batchTimeout = timeout
+	}
+}
+
+func WithMaxSegmentSize(size int64) Option {
+	return func(w *WAL) {
+		w.maxSegmentSize = size
+	}
+}
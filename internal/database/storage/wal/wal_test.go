@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func collect(t *testing.T, w *WAL) []Record {
+	t.Helper()
+
+	var records []Record
+	err := w.Recover(0, func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return records
+}
+
+func TestWALRecover(t *testing.T) {
+	directory := t.TempDir()
+
+	w, err := New(zap.NewNop(), WithDataDirectory(directory), WithSyncPolicy(SyncAlways))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(NewRecord(OperationSet, "key", "value with spaces")))
+	require.NoError(t, w.Append(NewRecord(OperationDel, "key")))
+	require.NoError(t, w.Close())
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Equal(t, []Record{
+		NewRecord(OperationSet, "key", "value with spaces"),
+		NewRecord(OperationDel, "key"),
+	}, collect(t, w))
+}
+
+func TestWALSegmentRotation(t *testing.T) {
+	directory := t.TempDir()
+
+	w, err := New(zap.NewNop(), WithDataDirectory(directory), WithMaxSegmentSize(32))
+	require.NoError(t, err)
+
+	for range 10 {
+		require.NoError(t, w.Append(NewRecord(OperationSet, "key", "value")))
+	}
+	require.NoError(t, w.Close())
+
+	ids, err := listSegments(directory)
+	require.NoError(t, err)
+	assert.Len(t, ids, 10)
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Len(t, collect(t, w), 10)
+}
+
+func TestWALTruncatesTornTail(t *testing.T) {
+	directory := t.TempDir()
+
+	w, err := New(zap.NewNop(), WithDataDirectory(directory), WithSyncPolicy(SyncNever))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(NewRecord(OperationSet, "a", "1")))
+	require.NoError(t, w.Append(NewRecord(OperationSet, "b", "2")))
+	require.NoError(t, w.Close())
+
+	path := filepath.Join(directory, segmentFileName(1))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Record{NewRecord(OperationSet, "a", "1")}, collect(t, w))
+	require.NoError(t, w.Append(NewRecord(OperationSet, "c", "3")))
+	require.NoError(t, w.Close())
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Equal(t, []Record{
+		NewRecord(OperationSet, "a", "1"),
+		NewRecord(OperationSet, "c", "3"),
+	}, collect(t, w))
+}
+
+func TestWALTruncatesTornHeader(t *testing.T) {
+	directory := t.TempDir()
+
+	w, err := New(zap.NewNop(), WithDataDirectory(directory), WithSyncPolicy(SyncNever))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(NewRecord(OperationSet, "a", "1")))
+	require.NoError(t, w.Close())
+
+	// a header claiming a 4 GiB payload is not followed by one
+	path := filepath.Join(directory, segmentFileName(1))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Equal(t, []Record{NewRecord(OperationSet, "a", "1")}, collect(t, w))
+}
+
+func TestWALRotate(t *testing.T) {
+	directory := t.TempDir()
+
+	w, err := New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(NewRecord(OperationSet, "a", "1")))
+	id, err := w.Rotate()
+	require.NoError(t, err)
+	require.NoError(t, w.Append(NewRecord(OperationSet, "b", "2")))
+	require.NoError(t, w.RemoveSegmentsBefore(id))
+	require.NoError(t, w.Close())
+
+	w, err = New(zap.NewNop(), WithDataDirectory(directory))
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Equal(t, []Record{NewRecord(OperationSet, "b", "2")}, collect(t, w))
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	policy, err := ParseSyncPolicy("always")
+	require.NoError(t, err)
+	assert.Equal(t, SyncAlways, policy)
+
+	_, err = ParseSyncPolicy("sometimes")
+	assert.ErrorIs(t, err, errInvalidSyncPolicy)
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+)
+
+var (
+	errNoConnection         = errors.New("transactions require a client connection")
+	errNestedMulti          = errors.New("MULTI calls can not be nested")
+	errExecWithoutMulti     = errors.New("EXEC without MULTI")
+	errDiscardWithoutMulti  = errors.New("DISCARD without MULTI")
+	errWatchInsideMulti     = errors.New("WATCH inside MULTI is not allowed")
+	errTransactionDiscarded = errors.New("transaction discarded because of previous errors")
+	errWatchedKeyChanged    = errors.New("transaction aborted, a watched key changed")
+)
+
+// Transaction is the MULTI and WATCH state of a client connection, which
+// lives as long as the connection and is passed to the database in the
+// request context.
+type Transaction struct {
+	mutex sync.Mutex
+	// multi is set between MULTI and EXEC or DISCARD, while commands are queued.
+	multi bool
+	// failed is set when a command could not be queued, EXEC discards the
+	// transaction then.
+	failed bool
+	queued []compute.Query
+	// watched holds versions of the watched keys at the time of WATCH.
+	watched map[string]uint64
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{}
+}
+
+type transactionKey struct{}
+
+// NewTransactionContext returns a context carrying the transaction state.
+func NewTransactionContext(ctx context.Context, transaction *Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, transaction)
+}
+
+func transactionFromContext(ctx context.Context) (*Transaction, bool) {
+	transaction, ok := ctx.Value(transactionKey{}).(*Transaction)
+	return transaction, ok
+}
+
+func (t *Transaction) begin() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.multi {
+		return errNestedMulti
+	}
+
+	t.multi = true
+	return nil
+}
+
+// queue queues the query and reports true after MULTI. Commands controlling
+// the transaction are never queued.
+func (t *Transaction) queue(query compute.Query) bool {
+	switch query.CommandID() {
+	case compute.MultiCommandID, compute.ExecCommandID, compute.DiscardCommandID, compute.WatchCommandID:
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.multi {
+		return false
+	}
+
+	t.queued = append(t.queued, query)
+	return true
+}
+
+// fail marks the transaction as failed after MULTI.
+func (t *Transaction) fail() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.multi {
+		t.failed = true
+	}
+}
+
+// exec ends the transaction and returns the queued queries along with the
+// watched keys, which are unwatched.
+func (t *Transaction) exec() ([]compute.Query, map[string]uint64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.multi {
+		return nil, nil, errExecWithoutMulti
+	}
+
+	queued, watched, failed := t.queued, t.watched, t.failed
+	t.reset()
+
+	if failed {
+		return nil, nil, errTransactionDiscarded
+	}
+
+	return queued, watched, nil
+}
+
+func (t *Transaction) discard() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.multi {
+		return errDiscardWithoutMulti
+	}
+
+	t.reset()
+	return nil
+}
+
+// watch watches the keys with their current versions, keys already watched
+// keep the version they were watched with.
+func (t *Transaction) watch(versions map[string]uint64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.multi {
+		return errWatchInsideMulti
+	}
+
+	if t.watched == nil {
+		t.watched = make(map[string]uint64, len(versions))
+	}
+
+	for key, version := range versions {
+		if _, ok := t.watched[key]; !ok {
+			t.watched[key] = version
+		}
+	}
+
+	return nil
+}
+
+func (t *Transaction) unwatch() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.watched = nil
+}
+
+// reset must be called with mutex held.
+func (t *Transaction) reset() {
+	t.multi, t.failed = false, false
+	t.queued, t.watched = nil, nil
+}
@@ -1,32 +1,186 @@
 package initialization
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
 	"github.com/buurzx/in-mem-kvdb/internal/database"
 	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
 	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
-	inmemory "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/in_memory"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine"
+	_ "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/bitcask"
+	_ "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/btree"
+	_ "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/in_memory"
+	_ "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/lsm"
+	_ "github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/sync_map"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/snapshot"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/wal"
+	"github.com/ilyakaznacheev/cleanenv"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 )
 
-func CreateDatabase(logger *zap.Logger) (*database.Database, error) {
+// DatabaseConfig groups settings of the database layers.
+type DatabaseConfig struct {
+	Engine   EngineConfig
+	WAL      WALConfig
+	Snapshot SnapshotConfig
+	Auth     auth.Config
+}
+
+// EngineConfig is a storage engine configuration section
+type EngineConfig struct {
+	Type string `yaml:"type" env:"ENGINE_TYPE" env-description:"Database engine type" env-default:"in_memory"`
+	// Sections holds settings of every engine keyed by the engine type,
+	// only the section of the selected engine is decoded.
+	Sections map[string]yaml.Node `yaml:",inline"`
+}
+
+// WALConfig is a write-ahead log configuration section
+type WALConfig struct {
+	Enabled              bool   `yaml:"enabled" env:"KVDB_WAL_ENABLED" env-description:"Enable write-ahead log" env-default:"false"`
+	DataDirectory        string `yaml:"data-directory" env:"KVDB_WAL_DATA_DIRECTORY" env-description:"Write-ahead log directory" env-default:"data/wal"`
+	SyncPolicy           string `yaml:"sync-policy" env:"KVDB_WAL_SYNC_POLICY" env-description:"Fsync policy: always, batch or never" env-default:"batch"`
+	FlushingBatchSize    int    `yaml:"flushing-batch-size" env:"KVDB_WAL_FLUSHING_BATCH_SIZE" env-description:"Records written before fsync in batch policy" env-default:"100"`
+	FlushingBatchTimeout int    `yaml:"flushing-batch-timeout" env:"KVDB_WAL_FLUSHING_BATCH_TIMEOUT" env-description:"Fsync interval in milliseconds in batch policy" env-default:"10"`
+	MaxSegmentSize       int    `yaml:"max-segment-size" env:"KVDB_WAL_MAX_SEGMENT_SIZE" env-description:"Maximum segment size in bytes" env-default:"10485760"`
+}
+
+// SnapshotConfig is a snapshot configuration section
+type SnapshotConfig struct {
+	Enabled       bool   `yaml:"enabled" env:"KVDB_SNAPSHOT_ENABLED" env-description:"Enable snapshots" env-default:"false"`
+	DataDirectory string `yaml:"data-directory" env:"KVDB_SNAPSHOT_DATA_DIRECTORY" env-description:"Snapshot directory" env-default:"data/snapshot"`
+	Interval      int    `yaml:"interval" env:"KVDB_SNAPSHOT_INTERVAL" env-description:"Interval between scheduled snapshots in seconds, 0 disables the schedule" env-default:"300"`
+}
+
+// CreateDatabase builds the database and restores its state. Scheduled
+// snapshots, when enabled, are taken until the context is done.
+func CreateDatabase(ctx context.Context, cfg DatabaseConfig, logger *zap.Logger) (*database.Database, error) {
 	compute, err := compute.New(logger)
 	if err != nil {
 		return nil, fmt.Errorf("initialize compute: %w", err)
 	}
 
-	engine := inmemory.NewEngine(logger)
+	var databaseOptions []database.Option
+	if cfg.Auth.Enabled {
+		authenticator, err := auth.NewAuthenticator(cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("initialize auth: %w", err)
+		}
+
+		databaseOptions = append(databaseOptions, database.WithAuthenticator(authenticator))
+	}
+
+	section := engineSection{}
+	if node, ok := cfg.Engine.Sections[cfg.Engine.Type]; ok {
+		section.node = &node
+	}
+
+	engine, err := engine.New(cfg.Engine.Type, logger, section)
+	if err != nil {
+		return nil, fmt.Errorf("initialize engine: %w", err)
+	}
+
+	// resources are released until the storage owns them
+	var closers []io.Closer
+	if closer, ok := engine.(io.Closer); ok {
+		closers = append(closers, closer)
+	}
+
+	// engines persisting their own data can not be snapshotted, the storage
+	// WAL and snapshots would only duplicate their writes
+	if persistent, ok := engine.(storage.PersistentEngine); ok && (cfg.WAL.Enabled || cfg.Snapshot.Enabled) {
+		logger.Warn("wal and snapshots are ignored, the engine persists its own data",
+			zap.String("engine", cfg.Engine.Type),
+			zap.String("data_directory", persistent.DataDirectory()),
+		)
+
+		cfg.WAL.Enabled = false
+		cfg.Snapshot.Enabled = false
+	}
+
+	var options []storage.Option
+	if cfg.WAL.Enabled {
+		wal, err := createWAL(cfg.WAL, logger)
+		if err != nil {
+			closeAll(closers)
+			return nil, fmt.Errorf("initialize wal: %w", err)
+		}
+
+		closers = append(closers, wal)
+		options = append(options, storage.WithWAL(wal))
+	}
+
+	if cfg.Snapshot.Enabled {
+		store, err := snapshot.NewStore(logger, cfg.Snapshot.DataDirectory)
+		if err != nil {
+			closeAll(closers)
+			return nil, fmt.Errorf("initialize snapshots: %w", err)
+		}
+
+		options = append(options, storage.WithSnapshots(store))
+	}
 
-	storage, err := storage.New(logger, engine)
+	storage, err := storage.New(logger, engine, options...)
 	if err != nil {
-		return nil, fmt.Errorf("initialize storage: %w", err)
+		closeAll(closers)
+		return nil, fmt.Errorf("initialize storage %q: %w", cfg.Engine.Type, err)
+	}
+
+	if err := storage.Recover(ctx); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("recover storage: %w", err)
 	}
 
-	db, err := database.New(compute, storage, logger)
+	db, err := database.New(compute, storage, logger, databaseOptions...)
 	if err != nil {
+		storage.Close()
 		return nil, fmt.Errorf("initialize database: %w", err)
 	}
 
+	if cfg.Snapshot.Enabled && cfg.Snapshot.Interval > 0 {
+		go storage.SnapshotPeriodically(ctx, time.Duration(cfg.Snapshot.Interval)*time.Second)
+	}
+
 	return db, nil
 }
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+func createWAL(cfg WALConfig, logger *zap.Logger) (*wal.WAL, error) {
+	policy, err := wal.ParseSyncPolicy(cfg.SyncPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return wal.New(
+		logger,
+		wal.WithDataDirectory(cfg.DataDirectory),
+		wal.WithSyncPolicy(policy),
+		wal.WithFlushingBatchSize(cfg.FlushingBatchSize),
+		wal.WithFlushingBatchTimeout(time.Duration(cfg.FlushingBatchTimeout)*time.Millisecond),
+		wal.WithMaxSegmentSize(int64(cfg.MaxSegmentSize)),
+	)
+}
+
+// engineSection implements engine.Config on top of a YAML node.
+type engineSection struct {
+	node *yaml.Node
+}
+
+func (s engineSection) Decode(out any) error {
+	if s.node != nil {
+		if err := s.node.Decode(out); err != nil {
+			return fmt.Errorf("decode engine section: %w", err)
+		}
+	}
+
+	return cleanenv.ReadEnv(out)
+}
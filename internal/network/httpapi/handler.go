@@ -0,0 +1,258 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	errUnknownEndpoint  = errors.New("unknown endpoint")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errConflictingTTL   = errors.New("only one of ex and px may be set")
+)
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+type keyBody struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type resultBody struct {
+	Result any `json:"result"`
+}
+
+// handler dispatches requests of the JSON API into the database:
+//
+//	GET    /v1/keys/{key}               the value of the key, 404 if it does not exist
+//	PUT    /v1/keys/{key}[?ex=|?px=]    sets the key to the request body
+//	DELETE /v1/keys/{key}               deletes the key
+//	POST   /v1/query                    executes the request body in the text protocol
+type handler struct {
+	db          *database.Database
+	maxBodySize int64
+	logger      *zap.Logger
+}
+
+func newHandler(db *database.Database, maxBodySize int64, logger *zap.Logger) http.Handler {
+	h := &handler{
+		db:          db,
+		maxBodySize: maxBodySize,
+		logger:      logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key...}", h.handleKey)
+	mux.HandleFunc("/v1/query", h.handleQuery)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, errUnknownEndpoint)
+	})
+
+	return h.withSession(mux)
+}
+
+// withSession gives every request a session of its own, authenticated with
+// the basic auth credentials of the request if it has them.
+func (h *handler) withSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.NewContext(r.Context(), auth.NewSession(r.RemoteAddr))
+
+		if user, password, ok := r.BasicAuth(); ok {
+			reply := h.db.HandleCommand(ctx, []string{compute.AuthCommand, user, password})
+			if reply.Kind == database.ErrorReply {
+				writeError(w, errorStatus(reply.Err), reply.Err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *handler) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		reply := h.db.HandleCommand(r.Context(), []string{compute.GetCommand, key})
+		switch reply.Kind {
+		case database.NilReply:
+			writeError(w, http.StatusNotFound, storage.ErrNotFound)
+		case database.ErrorReply:
+			writeError(w, errorStatus(reply.Err), reply.Err)
+		default:
+			writeJSON(w, http.StatusOK, keyBody{Key: key, Value: reply.Str})
+		}
+	case http.MethodPut:
+		value, err := h.readBody(w, r)
+		if err != nil {
+			return
+		}
+
+		tokens := []string{compute.SetCommand, key, string(value)}
+
+		ex, px := r.URL.Query().Get("ex"), r.URL.Query().Get("px")
+		switch {
+		case ex != "" && px != "":
+			writeError(w, http.StatusBadRequest, errConflictingTTL)
+			return
+		case ex != "":
+			tokens = append(tokens, "EX", ex)
+		case px != "":
+			tokens = append(tokens, "PX", px)
+		}
+
+		h.writeReply(w, h.db.HandleCommand(r.Context(), tokens))
+	case http.MethodDelete:
+		h.writeReply(w, h.db.HandleCommand(r.Context(), []string{compute.DelCommand, key}))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+func (h *handler) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	request, err := h.readBody(w, r)
+	if err != nil {
+		return
+	}
+
+	h.writeReply(w, h.db.Execute(r.Context(), string(request)))
+}
+
+// readBody reads the request body, replying with an error if it cannot.
+func (h *handler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxBytesErr.Limit))
+		} else {
+			h.logger.Debug("failed to read request body", zap.Error(err))
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (h *handler) writeReply(w http.ResponseWriter, reply database.Reply) {
+	if reply.Kind == database.ErrorReply {
+		writeError(w, errorStatus(reply.Err), reply.Err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resultBody{Result: replyValue(reply)})
+}
+
+// errorStatus maps errors of the database to status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrAuthRequired), errors.Is(err, auth.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrNoPermission):
+		return http.StatusForbidden
+	case errors.Is(err, auth.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, compute.ErrEmptyRequest),
+		errors.Is(err, compute.ErrUnknownCommand),
+		errors.Is(err, compute.ErrWrongArgumentsNumber),
+		errors.Is(err, compute.ErrInvalidArgument),
+		errors.Is(err, compute.ErrInvalidSyntax),
+		errors.Is(err, auth.ErrDisabled),
+		errors.Is(err, storage.ErrWrongType):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kvdb"`)
+	}
+
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// replyValue converts a reply to a value encoded as JSON. Maps are encoded as
+// objects keeping the order of their keys.
+func replyValue(reply database.Reply) any {
+	switch reply.Kind {
+	case database.StatusReply, database.BulkReply:
+		return reply.Str
+	case database.IntegerReply:
+		return reply.Int
+	case database.ErrorReply:
+		return errorBody{Error: reply.Err.Error()}
+	case database.ArrayReply:
+		values := make([]any, 0, len(reply.Elements))
+		for _, element := range reply.Elements {
+			values = append(values, replyValue(element))
+		}
+		return values
+	case database.MapReply:
+		return orderedObject(reply.Elements)
+	default:
+		return nil
+	}
+}
+
+// orderedObject holds keys and values of an object interleaved.
+type orderedObject []database.Reply
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+
+	for i := 0; i+1 < len(o); i += 2 {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, err := json.Marshal(o[i].Str)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(replyValue(o[i+1]))
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
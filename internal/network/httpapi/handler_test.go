@@ -0,0 +1,138 @@
+package httpapi
+
+import (
+	"cmp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/btree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestHandler(t *testing.T, options ...database.Option) http.Handler {
+	t.Helper()
+
+	logger := zap.NewNop()
+
+	computeLayer, err := compute.New(logger)
+	require.NoError(t, err)
+
+	storageLayer, err := storage.New(logger, btree.NewEngine(logger))
+	require.NoError(t, err)
+
+	db, err := database.New(computeLayer, storageLayer, logger, options...)
+	require.NoError(t, err)
+
+	return newHandler(db, 64, logger)
+}
+
+func TestHandler(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		expect string
+	}{
+		{name: "missing key", method: http.MethodGet, target: "/v1/keys/a", status: http.StatusNotFound, expect: `{"error":"not found"}`},
+		{name: "put", method: http.MethodPut, target: "/v1/keys/a", body: "value with spaces", status: http.StatusOK, expect: `{"result":"OK"}`},
+		{name: "get", method: http.MethodGet, target: "/v1/keys/a", status: http.StatusOK, expect: `{"key":"a","value":"value with spaces"}`},
+		{name: "key with slashes", method: http.MethodPut, target: "/v1/keys/b/c%20d", body: "2", status: http.StatusOK, expect: `{"result":"OK"}`},
+		{name: "query", method: http.MethodPost, target: "/v1/query", body: `GET "b/c d"`, status: http.StatusOK, expect: `{"result":"2"}`},
+		{name: "invalid ttl", method: http.MethodPut, target: "/v1/keys/a?px=soon", body: "1", status: http.StatusBadRequest},
+		{name: "conflicting ttl", method: http.MethodPut, target: "/v1/keys/a?ex=1&px=1", body: "1", status: http.StatusBadRequest, expect: `{"error":"only one of ex and px may be set"}`},
+		{name: "range", method: http.MethodPost, target: "/v1/query", body: "RANGE a z", status: http.StatusOK, expect: `{"result":{"a":"value with spaces","b/c d":"2"}}`},
+		{name: "scan", method: http.MethodPost, target: "/v1/query", body: "SCAN 0", status: http.StatusOK, expect: `{"result":["0",["a","b/c d"]]}`},
+		{name: "query nil", method: http.MethodPost, target: "/v1/query", body: "GET missing", status: http.StatusOK, expect: `{"result":null}`},
+		{name: "unknown command", method: http.MethodPost, target: "/v1/query", body: "FLUSHALL", status: http.StatusBadRequest},
+		{name: "syntax error", method: http.MethodPost, target: "/v1/query", body: `GET "a`, status: http.StatusBadRequest, expect: `{"error":"syntax error at byte 4: unterminated quoted string"}`},
+		{name: "delete", method: http.MethodDelete, target: "/v1/keys/a", status: http.StatusOK, expect: `{"result":1}`},
+		{name: "deleted", method: http.MethodGet, target: "/v1/keys/a", status: http.StatusNotFound},
+		{name: "too large body", method: http.MethodPut, target: "/v1/keys/a", body: strings.Repeat("x", 65), status: http.StatusRequestEntityTooLarge},
+		{name: "method not allowed", method: http.MethodPost, target: "/v1/keys/a", status: http.StatusMethodNotAllowed, expect: `{"error":"method not allowed"}`},
+		{name: "unknown endpoint", method: http.MethodGet, target: "/v2/keys/a", status: http.StatusNotFound, expect: `{"error":"unknown endpoint"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+
+			assert.Equal(t, test.status, recorder.Code)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+			if test.expect != "" {
+				assert.JSONEq(t, test.expect, recorder.Body.String())
+			}
+		})
+	}
+}
+
+// testPasswordHash is "secret" hashed with few iterations to keep tests fast.
+const testPasswordHash = "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$tiKWHy4FAGCWE8gn6GtKhaxD2OeeAUUWXFT/p1aaNl8"
+
+func TestHandlerAuth(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{Users: []auth.UserConfig{
+		{Name: "admin", PasswordHash: testPasswordHash},
+		{Name: "reader", PasswordHash: testPasswordHash, Categories: []string{"read"}},
+	}})
+	require.NoError(t, err)
+
+	handler := newTestHandler(t, database.WithAuthenticator(authenticator))
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		password string
+		status   int
+		expect   string
+	}{
+		{name: "no credentials", status: http.StatusUnauthorized, expect: `{"error":"authentication required"}`},
+		{name: "wrong password", user: "admin", password: "wrong", status: http.StatusUnauthorized, expect: `{"error":"invalid username-password pair"}`},
+		{name: "authenticated", user: "admin", password: "secret", status: http.StatusNotFound, expect: `{"error":"not found"}`},
+		{name: "no permission", method: http.MethodDelete, user: "reader", password: "secret", status: http.StatusForbidden, expect: `{"error":"no permission to run DEL"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(cmp.Or(test.method, http.MethodGet), "/v1/keys/a", nil)
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.status, recorder.Code)
+			assert.JSONEq(t, test.expect, recorder.Body.String())
+			if test.status == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="kvdb"`, recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	t.Run("too many attempts", func(t *testing.T) {
+		var recorder *httptest.ResponseRecorder
+		for range 7 {
+			request := httptest.NewRequest(http.MethodGet, "/v1/keys/a", nil)
+			request.SetBasicAuth("admin", "wrong")
+
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+		}
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.JSONEq(t, `{"error":"too many failed authentication attempts, try again later"}`, recorder.Body.String())
+	})
+}
@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"go.uber.org/zap"
+)
+
+// readHeaderTimeout bounds the time a client may take to send request headers.
+const readHeaderTimeout = 10 * time.Second
+
+// HTTPServer serves the JSON API over HTTP.
+type HTTPServer struct {
+	listener net.Listener
+	server   *http.Server
+
+	address           string
+	tlsConfig         *tls.Config
+	maxBodySize       int64
+	idleTimeout       time.Duration
+	activeConnections atomic.Int32
+	// connectionsWG tracks open connections, which are closed once their
+	// requests are handled.
+	connectionsWG sync.WaitGroup
+
+	logger *zap.Logger
+}
+
+func NewHTTPServer(logger *zap.Logger, options ...HTTPServerOption) (*HTTPServer, error) {
+	if logger == nil {
+		return nil, errors.New("http server: logger is required")
+	}
+
+	server := &HTTPServer{
+		address:     "localhost:8081",
+		maxBodySize: 1 << 20,
+		idleTimeout: 300 * time.Second,
+		logger:      logger,
+	}
+
+	for _, opt := range options {
+		opt(server)
+	}
+
+	listener, err := net.Listen("tcp", server.address)
+	if err != nil {
+		return nil, err
+	}
+
+	if server.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+
+	server.listener = listener
+	server.server = &http.Server{
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       server.idleTimeout,
+		ErrorLog:          zap.NewStdLog(logger),
+		ConnState:         server.trackConn,
+	}
+
+	return server, nil
+}
+
+// Start serves requests until the server is shut down or closed,
+// requests are executed with the context.
+func (s *HTTPServer) Start(ctx context.Context, db *database.Database) {
+	s.server.Handler = newHandler(db, s.maxBodySize, s.logger)
+	s.server.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("http server stopped", zap.Error(err))
+	}
+}
+
+// Shutdown stops accepting connections, closes idle connections and waits for
+// the others to finish their requests, or for the context to be done.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	// the listener is closed by the server only once it serves it
+	return errors.Join(s.server.Shutdown(ctx), s.closeListener())
+}
+
+// Close stops accepting connections and closes all connections at once.
+func (s *HTTPServer) Close() error {
+	return errors.Join(s.server.Close(), s.closeListener())
+}
+
+// Wait waits for the open connections to be closed, or for the context to
+// be done. After Close it waits for the handlers of their requests to return.
+func (s *HTTPServer) Wait(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		s.connectionsWG.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ActiveConnections returns the number of open connections.
+func (s *HTTPServer) ActiveConnections() int {
+	return int(s.activeConnections.Load())
+}
+
+// Addr returns the address the server listens on.
+func (s *HTTPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *HTTPServer) closeListener() error {
+	err := s.listener.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPServer) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.connectionsWG.Add(1)
+		s.activeConnections.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		s.activeConnections.Add(-1)
+		s.connectionsWG.Done()
+	}
+}
@@ -0,0 +1,34 @@
+package httpapi
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+type HTTPServerOption func(*HTTPServer)
+
+func WithServerAddress(address string) HTTPServerOption {
+	return func(s *HTTPServer) {
+		s.address = address
+	}
+}
+
+// WithServerMaxBodySize limits the size of a request body, larger bodies are rejected.
+func WithServerMaxBodySize(size int64) HTTPServerOption {
+	return func(s *HTTPServer) {
+		s.maxBodySize = size
+	}
+}
+
+func WithServerIdleTimeout(timeout time.Duration) HTTPServerOption {
+	return func(s *HTTPServer) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithServerTLSConfig makes the server accept HTTPS connections only.
+func WithServerTLSConfig(config *tls.Config) HTTPServerOption {
+	return func(s *HTTPServer) {
+		s.tlsConfig = config
+	}
+}
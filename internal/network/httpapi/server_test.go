@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"github.com/buurzx/in-mem-kvdb/internal/database/compute"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage/engine/btree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// selfSignedCertificate returns a certificate for 127.0.0.1 and a pool
+// trusting it.
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kvdb"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestHTTPServerTLS(t *testing.T) {
+	logger := zap.NewNop()
+
+	computeLayer, err := compute.New(logger)
+	require.NoError(t, err)
+
+	storageLayer, err := storage.New(logger, btree.NewEngine(logger))
+	require.NoError(t, err)
+
+	db, err := database.New(computeLayer, storageLayer, logger)
+	require.NoError(t, err)
+
+	certificate, pool := selfSignedCertificate(t)
+	server, err := NewHTTPServer(logger,
+		WithServerAddress("127.0.0.1:0"),
+		WithServerTLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}}),
+	)
+	require.NoError(t, err)
+	defer server.Close()
+
+	go server.Start(context.Background(), db)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	response, err := client.Get("https://" + server.Addr().String() + "/v1/keys/missing")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.JSONEq(t, `{"error":"not found"}`, string(body))
+
+	// cleartext requests are refused
+	response, err = http.Get("http://" + server.Addr().String() + "/v1/keys/missing")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err = io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Contains(t, string(body), "HTTPS server")
+}
@@ -1,57 +1,96 @@
 package network
 
 import (
+	"bufio"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"time"
 )
 
+// defaultMaxResponseSize bounds responses, which are much larger than
+// requests when they list many keys or values.
+const defaultMaxResponseSize = 512 << 20
+
 type TCPClient struct {
-	conn        net.Conn
-	idleTimeout time.Duration
-	bufferSize  int
+	conn            net.Conn
+	reader          *bufio.Reader
+	idleTimeout     time.Duration
+	maxResponseSize int
+	tlsConfig       *tls.Config
 }
 
 func NewTcpClient(address string, options ...TCPClientOption) (*TCPClient, error) {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return nil, err
-	}
-
 	client := &TCPClient{
-		conn:        conn,
-		idleTimeout: 300 * time.Second,
-		bufferSize:  4096,
+		idleTimeout:     300 * time.Second,
+		maxResponseSize: defaultMaxResponseSize,
 	}
 
 	for _, opt := range options {
 		opt(client)
 	}
 
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake is bounded by the idle timeout as well
 	if client.idleTimeout != 0 {
-		if err := client.conn.SetDeadline(time.Now().Add(client.idleTimeout)); err != nil {
+		if err := conn.SetDeadline(time.Now().Add(client.idleTimeout)); err != nil {
+			conn.Close()
 			return nil, fmt.Errorf("tcp client: set deadline: %w", err)
 		}
 	}
 
+	if client.tlsConfig != nil {
+		tlsConn := tls.Client(conn, client.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tcp client: tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	client.conn = conn
+	client.reader = bufio.NewReader(conn)
+
 	return client, nil
 }
 
 func (c *TCPClient) Send(data []byte) ([]byte, error) {
-	if _, err := c.conn.Write(data); err != nil {
+	if err := c.extendDeadline(); err != nil {
+		return nil, err
+	}
+
+	if err := writeMessage(c.conn, data); err != nil {
 		return nil, fmt.Errorf("tcp client: write: %w", err)
 	}
 
-	response := make([]byte, c.bufferSize)
-	count, err := c.conn.Read(response)
-	if err != nil && err != io.EOF {
+	if err := c.extendDeadline(); err != nil {
+		return nil, err
+	}
+
+	response, err := readMessage(c.reader, c.maxResponseSize)
+	if err != nil {
 		return nil, fmt.Errorf("tcp client: read: %w", err)
-	} else if count == c.bufferSize {
-		return nil, fmt.Errorf("tcp client: response buffer overflow")
 	}
 
-	return response[:count], nil
+	return response, nil
+}
+
+// extendDeadline gives the next write or read of a message the idle timeout,
+// so a connection in use never times out.
+func (c *TCPClient) extendDeadline() error {
+	if c.idleTimeout == 0 {
+		return nil
+	}
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.idleTimeout)); err != nil {
+		return fmt.Errorf("tcp client: set deadline: %w", err)
+	}
+
+	return nil
 }
 
 func (c *TCPClient) Close() error {
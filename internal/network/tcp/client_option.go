@@ -1,6 +1,9 @@
 package network
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 type TCPClientOption func(*TCPClient)
 
@@ -10,8 +13,17 @@ func WithClientIdleTimeout(timeout time.Duration) TCPClientOption {
 	}
 }
 
-func WithBufferSize(size int) TCPClientOption {
+// WithClientMaxResponseSize limits the size of a response, larger responses
+// are rejected. Zero disables the limit.
+func WithClientMaxResponseSize(size int) TCPClientOption {
 	return func(c *TCPClient) {
-		c.bufferSize = size
+		c.maxResponseSize = size
+	}
+}
+
+// WithClientTLSConfig makes the client connect over TLS.
+func WithClientTLSConfig(config *tls.Config) TCPClientOption {
+	return func(c *TCPClient) {
+		c.tlsConfig = config
 	}
 }
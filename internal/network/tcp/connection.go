@@ -0,0 +1,126 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errServerClosed = errors.New("server closed")
+
+// connection is a client connection the server can interrupt on shutdown.
+type connection struct {
+	net.Conn
+
+	mutex    sync.Mutex
+	draining bool
+	// busy is set while a request is read, executed and replied to.
+	busy bool
+	// cancelWait ends the wait of a request waiting for data, see watch.
+	cancelWait context.CancelFunc
+}
+
+// extendReadDeadline gives the client the timeout to send the next request,
+// zero means no timeout. It fails once the connection is draining.
+func (c *connection) extendReadDeadline(timeout time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.draining {
+		return errServerClosed
+	}
+
+	if timeout <= 0 {
+		return nil
+	}
+
+	return c.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// startRequest marks the connection busy, unless it is draining.
+func (c *connection) startRequest() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.draining {
+		return false
+	}
+
+	c.busy = true
+	return true
+}
+
+func (c *connection) finishRequest() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.busy = false
+}
+
+// drain makes the connection close before its next request. The wait for
+// a request of an idle connection is interrupted, while a busy connection
+// replies to its request first, ending a wait for data.
+func (c *connection) drain() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.draining = true
+	if c.cancelWait != nil {
+		c.cancelWait()
+	}
+	if !c.busy {
+		c.SetReadDeadline(time.Now())
+	}
+}
+
+// watch is the database.WatchFunc of a request waiting for data, such as
+// BLPOP. The read deadline is lifted while the request waits, the returned
+// context is done once the client closes the connection or the connection
+// drains. A request pipelined meanwhile stays buffered in the reader.
+func (c *connection) watch(ctx context.Context, reader *bufio.Reader) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.draining {
+		cancel()
+		return ctx, cancel
+	}
+
+	c.cancelWait = cancel
+	c.SetReadDeadline(time.Time{})
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		if _, err := reader.Peek(1); err != nil {
+			cancel()
+		}
+	}()
+
+	stop := func() {
+		cancel()
+
+		c.mutex.Lock()
+		c.cancelWait = nil
+		c.mutex.Unlock()
+
+		// interrupt the peek, extendReadDeadline sets the deadline of the next request
+		c.SetReadDeadline(time.Now())
+		<-closed
+		c.SetReadDeadline(time.Time{})
+	}
+
+	return ctx, stop
+}
+
+func (c *connection) isDraining() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.draining
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frameHeaderSize is the size of the big-endian uint32 length every message
+// is prefixed with.
+const frameHeaderSize = 4
+
+var errMessageTooLarge = errors.New("message exceeds max message size")
+
+// writeMessage writes the message prefixed with its length in a single write.
+func writeMessage(w io.Writer, message []byte) error {
+	_, err := w.Write(encodeMessage(message))
+	return err
+}
+
+// encodeMessage prefixes the message with its length.
+func encodeMessage(message []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+
+	return append(frame, message...)
+}
+
+// readMessage reads a length-prefixed message. A message longer than maxSize
+// is skipped, so the stream stays in sync, and errMessageTooLarge is returned.
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if maxSize > 0 && uint64(size) > uint64(maxSize) {
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		return nil, fmt.Errorf("%w: %d > %d bytes", errMessageTooLarge, size, maxSize)
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return message, nil
+}
+
+// unexpectedEOF reports a stream closed in the middle of a message.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageFraming(t *testing.T) {
+	t.Run("coalesced messages", func(t *testing.T) {
+		var stream bytes.Buffer
+		require.NoError(t, writeMessage(&stream, []byte("GET a")))
+		require.NoError(t, writeMessage(&stream, []byte("")))
+		require.NoError(t, writeMessage(&stream, []byte("SET a 1")))
+
+		for _, expected := range []string{"GET a", "", "SET a 1"} {
+			message, err := readMessage(&stream, 16)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(message))
+		}
+
+		_, err := readMessage(&stream, 16)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("split message", func(t *testing.T) {
+		var stream bytes.Buffer
+		require.NoError(t, writeMessage(&stream, []byte("SET key value")))
+
+		message, err := readMessage(iotest.OneByteReader(&stream), 0)
+		require.NoError(t, err)
+		assert.Equal(t, "SET key value", string(message))
+	})
+
+	t.Run("too large message is skipped", func(t *testing.T) {
+		var stream bytes.Buffer
+		require.NoError(t, writeMessage(&stream, []byte(strings.Repeat("x", 32))))
+		require.NoError(t, writeMessage(&stream, []byte("GET a")))
+
+		_, err := readMessage(&stream, 16)
+		assert.ErrorIs(t, err, errMessageTooLarge)
+
+		message, err := readMessage(&stream, 16)
+		require.NoError(t, err)
+		assert.Equal(t, "GET a", string(message))
+	})
+
+	t.Run("truncated message", func(t *testing.T) {
+		var stream bytes.Buffer
+		require.NoError(t, writeMessage(&stream, []byte("GET a")))
+		stream.Truncate(stream.Len() - 1)
+
+		_, err := readMessage(&stream, 16)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestServerFraming(t *testing.T) {
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		return strings.Repeat(request, 2)
+	}, WithServerMaxMessageSize(64))
+
+	client, err := NewTcpClient(server.listener.Addr().String(), WithClientMaxResponseSize(64))
+	require.NoError(t, err)
+	defer client.Close()
+
+	response, err := client.Send([]byte("ab\ncd"))
+	require.NoError(t, err)
+	assert.Equal(t, "ab\ncdab\ncd", string(response))
+
+	response, err = client.Send([]byte(strings.Repeat("x", 100)))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(response), "[error] message exceeds max message size"), string(response))
+
+	_, err = client.Send([]byte(strings.Repeat("y", 40)))
+	assert.True(t, errors.Is(err, errMessageTooLarge), err)
+
+	// the connection stays usable after rejected messages
+	response, err = client.Send([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "okok", string(response))
+}
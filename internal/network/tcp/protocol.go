@@ -0,0 +1,40 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Protocol is the wire protocol spoken by a server.
+type Protocol string
+
+const (
+	// ProtocolText is the length-prefixed plain text protocol of kvdb-cli.
+	ProtocolText Protocol = "text"
+	// ProtocolRESP is the Redis serialization protocol, versions 2 and 3.
+	ProtocolRESP Protocol = "resp"
+)
+
+var errUnknownProtocol = errors.New("unknown protocol")
+
+// ParseProtocol returns the protocol with the name, an empty name stands
+// for the text protocol.
+func ParseProtocol(name string) (Protocol, error) {
+	switch protocol := Protocol(name); protocol {
+	case "":
+		return ProtocolText, nil
+	case ProtocolText, ProtocolRESP:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("%w %q", errUnknownProtocol, name)
+	}
+}
+
+// session reads requests of a connection and executes them.
+type session interface {
+	// serve reads and executes a request and returns the encoded reply, nil if
+	// there is nothing to reply. An error with a reply means the reply has to
+	// be written before the connection is closed.
+	serve(ctx context.Context) ([]byte, error)
+}
@@ -0,0 +1,236 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+)
+
+const (
+	resp2 = 2
+	resp3 = 3
+)
+
+var errProtocol = errors.New("protocol error")
+
+// respSession serves RESP. Requests are arrays of bulk strings or inline
+// commands, replies are encoded in the version chosen by HELLO, RESP2 until then.
+type respSession struct {
+	reader         *bufio.Reader
+	maxMessageSize int
+	version        int
+	handler        func(context.Context, []string) database.Reply
+}
+
+func newRESPSession(reader *bufio.Reader, maxMessageSize int, handler func(context.Context, []string) database.Reply) *respSession {
+	return &respSession{
+		reader:         reader,
+		maxMessageSize: maxMessageSize,
+		version:        resp2,
+		handler:        handler,
+	}
+}
+
+func (s *respSession) serve(ctx context.Context) ([]byte, error) {
+	tokens, err := readRESPCommand(s.reader, s.maxMessageSize)
+	if errors.Is(err, errProtocol) || errors.Is(err, errMessageTooLarge) {
+		// the rest of the stream cannot be trusted after a malformed request
+		return appendRESPError(nil, "ERR", err.Error()), err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	if strings.EqualFold(tokens[0], "HELLO") {
+		return s.hello(tokens[1:]), nil
+	}
+
+	return appendRESPReply(nil, s.handler(ctx, tokens), s.version), nil
+}
+
+// hello switches the protocol version, HELLO [protover], and replies with
+// the server properties.
+func (s *respSession) hello(args []string) []byte {
+	if len(args) > 1 {
+		return appendRESPError(nil, "ERR", "only HELLO [protover] is supported")
+	}
+
+	if len(args) == 1 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return appendRESPError(nil, "ERR", "Protocol version is not an integer or out of range")
+		}
+
+		if version != resp2 && version != resp3 {
+			return appendRESPError(nil, "NOPROTO", "unsupported protocol version")
+		}
+
+		s.version = version
+	}
+
+	bulk := func(value string) database.Reply {
+		return database.Reply{Kind: database.BulkReply, Str: value}
+	}
+
+	properties := database.Reply{Kind: database.MapReply, Elements: []database.Reply{
+		bulk("server"), bulk("kvdb"),
+		bulk("proto"), {Kind: database.IntegerReply, Int: int64(s.version)},
+		bulk("mode"), bulk("standalone"),
+		bulk("role"), bulk("master"),
+		bulk("modules"), {Kind: database.ArrayReply},
+	}}
+
+	return appendRESPReply(nil, properties, s.version)
+}
+
+// readRESPCommand reads an array of bulk strings or an inline command,
+// a line of whitespace separated tokens. Bulk strings may not take more than
+// maxSize bytes in total.
+func readRESPCommand(r *bufio.Reader, maxSize int) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(string(line)), nil
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || (maxSize > 0 && count > maxSize) {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	tokens := make([]string, 0, max(count, 0))
+	size := 0
+
+	for range count {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, line[:min(len(line), 1)])
+		}
+
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		size += length
+		if maxSize > 0 && size > maxSize {
+			return nil, fmt.Errorf("%w: request is longer than %d bytes", errMessageTooLarge, maxSize)
+		}
+
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string is not terminated by CRLF", errProtocol)
+		}
+
+		tokens = append(tokens, string(data[:length]))
+	}
+
+	return tokens, nil
+}
+
+// readRESPLine reads a line without its terminating CRLF, which cannot be
+// longer than the reader buffer.
+func readRESPLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+	}
+	if err != nil {
+		if len(line) > 0 {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+// appendRESPReply encodes the reply in the RESP version. RESP2 has no null
+// and map types, so nulls are sent as null bulk strings and maps as arrays of
+// keys and values.
+func appendRESPReply(buffer []byte, reply database.Reply, version int) []byte {
+	switch reply.Kind {
+	case database.StatusReply:
+		return appendRESPLine(buffer, '+', reply.Str)
+	case database.ErrorReply:
+		if errors.Is(reply.Err, storage.ErrWrongType) {
+			// the error starts with its code like in Redis
+			code, message, _ := strings.Cut(reply.Err.Error(), " ")
+			return appendRESPError(buffer, code, message)
+		}
+		return appendRESPError(buffer, "ERR", reply.Err.Error())
+	case database.IntegerReply:
+		return appendRESPLine(buffer, ':', strconv.FormatInt(reply.Int, 10))
+	case database.BulkReply:
+		buffer = appendRESPLine(buffer, '$', strconv.Itoa(len(reply.Str)))
+		buffer = append(buffer, reply.Str...)
+		return append(buffer, "\r\n"...)
+	case database.NilReply:
+		if version == resp3 {
+			return append(buffer, "_\r\n"...)
+		}
+		return append(buffer, "$-1\r\n"...)
+	case database.NilArrayReply:
+		if version == resp3 {
+			return append(buffer, "_\r\n"...)
+		}
+		return append(buffer, "*-1\r\n"...)
+	case database.MapReply:
+		if version == resp3 {
+			buffer = appendRESPLine(buffer, '%', strconv.Itoa(len(reply.Elements)/2))
+			return appendRESPElements(buffer, reply.Elements, version)
+		}
+		fallthrough
+	case database.ArrayReply:
+		buffer = appendRESPLine(buffer, '*', strconv.Itoa(len(reply.Elements)))
+		return appendRESPElements(buffer, reply.Elements, version)
+	default:
+		return appendRESPError(buffer, "ERR", fmt.Sprintf("unsupported reply kind %d", reply.Kind))
+	}
+}
+
+func appendRESPElements(buffer []byte, elements []database.Reply, version int) []byte {
+	for _, element := range elements {
+		buffer = appendRESPReply(buffer, element, version)
+	}
+
+	return buffer
+}
+
+// appendRESPError encodes an error with the code, such as ERR, the first
+// word of the error by convention.
+func appendRESPError(buffer []byte, code, message string) []byte {
+	// a simple error cannot span lines
+	message = strings.NewReplacer("\r", " ", "\n", " ").Replace(message)
+
+	return appendRESPLine(buffer, '-', code+" "+message)
+}
+
+func appendRESPLine(buffer []byte, prefix byte, line string) []byte {
+	buffer = append(buffer, prefix)
+	buffer = append(buffer, line...)
+	return append(buffer, "\r\n"...)
+}
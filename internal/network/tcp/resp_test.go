@@ -0,0 +1,119 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/buurzx/in-mem-kvdb/internal/database"
+	"github.com/buurzx/in-mem-kvdb/internal/database/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		err      error
+	}{
+		{name: "array", input: "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\na\r\nb \r\n", expected: []string{"SET", "key", "a\r\nb "}},
+		{name: "empty bulk string", input: "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", expected: []string{"GET", ""}},
+		{name: "inline", input: "GET  key\r\n", expected: []string{"GET", "key"}},
+		{name: "empty inline", input: "\r\n", expected: []string{}},
+		{name: "invalid multibulk length", input: "*x\r\n", err: errProtocol},
+		{name: "missing bulk string", input: "*1\r\n:1\r\n", err: errProtocol},
+		{name: "unterminated bulk string", input: "*1\r\n$3\r\nGETxx", err: errProtocol},
+		{name: "too large", input: "*1\r\n$32\r\n", err: errMessageTooLarge},
+		{name: "truncated", input: "*2\r\n$3\r\nGET\r\n", err: io.ErrUnexpectedEOF},
+		{name: "closed", input: "", err: io.EOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := readRESPCommand(bufio.NewReader(strings.NewReader(test.input)), 16)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, append([]string{}, tokens...))
+		})
+	}
+}
+
+func TestAppendRESPReply(t *testing.T) {
+	bulk := func(value string) database.Reply {
+		return database.Reply{Kind: database.BulkReply, Str: value}
+	}
+
+	pairs := database.Reply{Kind: database.MapReply, Elements: []database.Reply{bulk("a"), bulk("1")}}
+
+	tests := []struct {
+		name     string
+		reply    database.Reply
+		version  int
+		expected string
+	}{
+		{name: "status", reply: database.Reply{Kind: database.StatusReply, Str: "OK"}, version: resp2, expected: "+OK\r\n"},
+		{name: "error", reply: database.Reply{Kind: database.ErrorReply, Err: errors.New("bad\r\nrequest")}, version: resp2, expected: "-ERR bad  request\r\n"},
+		{name: "wrong type", reply: database.Reply{Kind: database.ErrorReply, Err: storage.ErrWrongType}, version: resp2, expected: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{name: "integer", reply: database.Reply{Kind: database.IntegerReply, Int: -2}, version: resp2, expected: ":-2\r\n"},
+		{name: "bulk", reply: bulk("a\r\nb"), version: resp2, expected: "$4\r\na\r\nb\r\n"},
+		{name: "resp2 nil", reply: database.Reply{Kind: database.NilReply}, version: resp2, expected: "$-1\r\n"},
+		{name: "resp3 nil", reply: database.Reply{Kind: database.NilReply}, version: resp3, expected: "_\r\n"},
+		{name: "resp2 nil array", reply: database.Reply{Kind: database.NilArrayReply}, version: resp2, expected: "*-1\r\n"},
+		{name: "resp3 nil array", reply: database.Reply{Kind: database.NilArrayReply}, version: resp3, expected: "_\r\n"},
+		{
+			name:     "nested array",
+			reply:    database.Reply{Kind: database.ArrayReply, Elements: []database.Reply{bulk("0"), {Kind: database.ArrayReply}}},
+			version:  resp2,
+			expected: "*2\r\n$1\r\n0\r\n*0\r\n",
+		},
+		{name: "resp2 map", reply: pairs, version: resp2, expected: "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{name: "resp3 map", reply: pairs, version: resp3, expected: "%1\r\n$1\r\na\r\n$1\r\n1\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, string(appendRESPReply(nil, test.reply, test.version)))
+		})
+	}
+}
+
+func TestRESPSession(t *testing.T) {
+	input := strings.Join([]string{
+		"*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n",
+		"HELLO 4\r\n",
+		"*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n",
+		"GET missing\r\n",
+		"*1\r\n:1\r\n",
+	}, "")
+
+	s := newRESPSession(bufio.NewReader(strings.NewReader(input)), 4096, func(_ context.Context, tokens []string) database.Reply {
+		return database.Reply{Kind: database.NilReply}
+	})
+
+	serve := func() string {
+		reply, err := s.serve(context.Background())
+		require.NoError(t, err)
+		return string(reply)
+	}
+
+	assert.Equal(t, "$-1\r\n", serve())
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", serve())
+
+	hello := serve()
+	assert.True(t, strings.HasPrefix(hello, "%5\r\n$6\r\nserver\r\n$4\r\nkvdb\r\n$5\r\nproto\r\n:3\r\n"), hello)
+
+	assert.Equal(t, "_\r\n", serve())
+
+	reply, err := s.serve(context.Background())
+	assert.ErrorIs(t, err, errProtocol)
+	assert.Equal(t, "-ERR protocol error: expected '$', got ':'\r\n", string(reply))
+}
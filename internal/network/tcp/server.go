@@ -1,7 +1,9 @@
 package network
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -10,19 +12,34 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/buurzx/in-mem-kvdb/internal/auth"
 	"github.com/buurzx/in-mem-kvdb/internal/database"
 	"go.uber.org/zap"
 )
 
+// noticeTimeout bounds the time spent writing a notice to a connection that
+// is about to be closed.
+const noticeTimeout = time.Second
+
+// shutdownNotice is sent to idle clients when the server shuts down.
+const shutdownNotice = "server is shutting down"
+
 type TCPServer struct {
 	listener net.Listener
 
 	address           string
 	maxConn           int
 	idleTimeout       time.Duration
-	bufferSize        int
+	maxMessageSize    int
+	protocol          Protocol
+	tlsConfig         *tls.Config
 	activeConnections atomic.Int32
 
+	connectionsMutex sync.Mutex
+	connections      map[*connection]struct{}
+	// connectionsWG tracks connections being served.
+	connectionsWG sync.WaitGroup
+
 	logger *zap.Logger
 }
 
@@ -33,8 +50,10 @@ func NewTCPServer(logger *zap.Logger, options ...TCPServerOption) (*TCPServer, e
 
 	server := &TCPServer{
 		address:     "localhost:8080",
+		protocol:    ProtocolText,
 		maxConn:     100,
 		idleTimeout: 300 * time.Second,
+		connections: make(map[*connection]struct{}),
 		logger:      logger,
 	}
 
@@ -47,23 +66,85 @@ func NewTCPServer(logger *zap.Logger, options ...TCPServerOption) (*TCPServer, e
 		return nil, err
 	}
 
+	if server.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+
 	server.listener = listener
 
-	if server.bufferSize == 0 {
-		server.bufferSize = 4 << 10
+	if server.maxMessageSize == 0 {
+		server.maxMessageSize = 4 << 10
 	}
 
 	return server, nil
 }
 
+// Start serves connections until the server is shut down or closed,
+// requests are executed with the context.
 func (t *TCPServer) Start(ctx context.Context, db *database.Database) {
-	t.handleQueries(ctx, func(ctx context.Context, request []byte) string {
-		response := db.HandleRequest(ctx, string(request))
-		return response
-	})
+	switch t.protocol {
+	case ProtocolRESP:
+		t.handleQueries(ctx, func(reader *bufio.Reader) session {
+			return newRESPSession(reader, t.maxMessageSize, db.HandleCommand)
+		})
+	default:
+		t.handleQueries(ctx, func(reader *bufio.Reader) session {
+			return newTextSession(reader, t.maxMessageSize, db.HandleRequest)
+		})
+	}
 }
 
+// Shutdown stops accepting connections, closes idle connections with a
+// shutdown notice and waits for the others to reply to requests being
+// executed. If the context is done first, its error is returned and the
+// remaining connections are left to Close.
+func (t *TCPServer) Shutdown(ctx context.Context) error {
+	if err := t.closeListener(); err != nil {
+		return err
+	}
+
+	t.drainConns()
+
+	return t.Wait(ctx)
+}
+
+// Wait waits for the connections being served to be closed, or for the
+// context to be done. After Close it waits for their handlers to return.
+func (t *TCPServer) Wait(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		t.connectionsWG.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting connections and closes all connections at once.
 func (t *TCPServer) Close() error {
+	err := t.closeListener()
+
+	t.connectionsMutex.Lock()
+	defer t.connectionsMutex.Unlock()
+
+	for c := range t.connections {
+		err = errors.Join(err, c.Close())
+	}
+
+	return err
+}
+
+// ActiveConnections returns the number of connections being served.
+func (t *TCPServer) ActiveConnections() int {
+	return int(t.activeConnections.Load())
+}
+
+func (t *TCPServer) closeListener() error {
 	if t.listener == nil {
 		return nil
 	}
@@ -75,114 +156,181 @@ func (t *TCPServer) Close() error {
 	return nil
 }
 
-func (t *TCPServer) handleQueries(ctx context.Context, handler func(context.Context, []byte) string) {
-	var (
-		wg sync.WaitGroup
-	)
+func (t *TCPServer) handleQueries(ctx context.Context, newSession func(*bufio.Reader) session) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 
-	wg.Add(1)
+			t.logger.Error("tcp server accept", zap.Error(err))
+			continue
+		}
 
-	go func() {
-		defer wg.Done()
+		connAmount := t.activeConnections.Add(1)
+		if int(connAmount) > t.maxConn {
+			t.activeConnections.Add(-1)
+			t.logger.Warn("max connections reached, connection rejected",
+				zap.String("remote_address", conn.RemoteAddr().String()),
+				zap.Int("max_connections", t.maxConn))
 
-		// reuse buffer
-		buffer := make([]byte, t.bufferSize)
+			t.writeNotice(conn, "max number of clients reached")
+			conn.Close()
+			continue
+		}
 
-		for {
-			conn, err := t.listener.Accept()
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
+		t.logger.Info("new connection accepted", zap.Int("active_connections", int(connAmount)))
 
-				t.logger.Error("tcp server accept", zap.Error(err))
-				continue
-			}
+		c := t.trackConn(conn)
 
-			if int(t.activeConnections.Load()) >= t.maxConn {
-				t.logger.Error("max connection reached")
-				return
-			}
+		t.connectionsWG.Add(1)
+		go func() {
+			defer t.connectionsWG.Done()
+			t.handleConn(ctx, c, newSession)
+		}()
+	}
+}
 
-			connAmount := t.activeConnections.Add(1)
-			t.logger.Info("new connection accepted", zap.Int("active_connections", int(connAmount)))
+// writeNotice writes an error in the server protocol to a connection that is
+// about to be closed.
+func (t *TCPServer) writeNotice(conn net.Conn, message string) {
+	var reply []byte
+	switch t.protocol {
+	case ProtocolRESP:
+		reply = appendRESPError(nil, "ERR", message)
+	default:
+		reply = encodeMessage([]byte("[error] " + message))
+	}
 
-			t.handleConn(ctx, conn, buffer, handler)
-		}
+	if err := conn.SetWriteDeadline(time.Now().Add(noticeTimeout)); err != nil {
+		return
+	}
 
-	}()
+	if _, err := conn.Write(reply); err != nil {
+		t.logger.Debug("failed to write notice", zap.Error(err))
+	}
+}
 
-	<-ctx.Done()
-	// Close listener first to stop accepting new connections
-	t.Close()
-	// Wait for existing connections to finish
-	wg.Wait()
+func (t *TCPServer) trackConn(conn net.Conn) *connection {
+	c := &connection{Conn: conn}
+
+	t.connectionsMutex.Lock()
+	t.connections[c] = struct{}{}
+	t.connectionsMutex.Unlock()
+
+	return c
+}
+
+func (t *TCPServer) untrackConn(c *connection) {
+	t.connectionsMutex.Lock()
+	delete(t.connections, c)
+	t.connectionsMutex.Unlock()
+}
+
+func (t *TCPServer) drainConns() {
+	t.connectionsMutex.Lock()
+	defer t.connectionsMutex.Unlock()
+
+	for c := range t.connections {
+		c.drain()
+	}
 }
 
 func (t *TCPServer) handleConn(
 	ctx context.Context,
-	c net.Conn,
-	buffer []byte,
-	handler func(context.Context, []byte) string,
+	c *connection,
+	newSession func(*bufio.Reader) session,
 ) {
 	defer func() {
+		t.untrackConn(c)
 		c.Close()
 		val := t.activeConnections.Add(-1)
 		t.logger.Info("connection closed", zap.Int("active_connections", int(val)))
 	}()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if t.idleTimeout > 0 {
-				deadline := time.Now().Add(t.idleTimeout)
-				t.logger.Info("setting read deadline",
-					zap.Duration("timeout", t.idleTimeout),
-					zap.Time("deadline", deadline))
-
-				if err := c.SetReadDeadline(deadline); err != nil {
-					t.logger.Error("failed to set read deadline", zap.Error(err))
-					return
-				}
-			}
+	// commands of the connection share its authentication and transaction state
+	ctx = auth.NewContext(ctx, auth.NewSession(c.RemoteAddr().String()))
+	ctx = database.NewTransactionContext(ctx, database.NewTransaction())
 
-			n, err := c.Read(buffer)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					t.logger.Info("connection was closed")
-					return
-				}
+	reader := bufio.NewReader(c)
+	session := newSession(reader)
 
-				if errors.Is(err, os.ErrDeadlineExceeded) {
-					t.logger.Info("read timed out due to idle timeout", zap.Error(err))
-					return
-				}
+	// requests waiting for data end when the client goes away
+	ctx = database.NewWatchContext(ctx, func(ctx context.Context) (context.Context, context.CancelFunc) {
+		return c.watch(ctx, reader)
+	})
 
-				t.logger.Error("failed to read from connection", zap.Error(err))
-				return
+	for {
+		if err := c.extendReadDeadline(t.idleTimeout); err != nil {
+			if errors.Is(err, errServerClosed) {
+				t.writeNotice(c, shutdownNotice)
+				t.logger.Info("connection closed on shutdown")
+			} else {
+				t.logger.Error("failed to set read deadline", zap.Error(err))
 			}
-			request := buffer[:n]
+			return
+		}
 
-			if t.idleTimeout > 0 {
-				if err := c.SetWriteDeadline(time.Now().Add(t.idleTimeout)); err != nil {
-					t.logger.Error("failed to set write deadline", zap.Error(err))
-					return
-				}
+		// wait for the next request while idle, so that a drain can interrupt it
+		if _, err := reader.Peek(1); err != nil || !c.startRequest() {
+			switch {
+			case c.isDraining():
+				t.writeNotice(c, shutdownNotice)
+				t.logger.Info("connection closed on shutdown")
+			case errors.Is(err, io.EOF):
+				t.logger.Info("connection was closed")
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				t.logger.Info("read timed out due to idle timeout", zap.Error(err))
+			default:
+				t.logger.Error("failed to read from connection", zap.Error(err))
 			}
+			return
+		}
 
-			response := handler(ctx, request)
-
-			_, err = c.Write([]byte(response))
-			if err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) {
-					t.logger.Info("write timed out due to idle timeout", zap.Error(err))
-				} else {
-					t.logger.Error("failed to write to connection", zap.Error(err))
-				}
+		// a session may reply to a request it cannot recover from,
+		// the connection is closed after the reply is written
+		reply, err := session.serve(ctx)
+		if reply != nil {
+			if err := t.writeReply(c, reply); err != nil {
+				c.finishRequest()
 				return
 			}
 		}
+
+		c.finishRequest()
+
+		switch {
+		case err == nil:
+		case reply != nil:
+			t.logger.Warn("closing connection after protocol error", zap.Error(err))
+			return
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			t.logger.Info("read timed out due to idle timeout", zap.Error(err))
+			return
+		default:
+			t.logger.Error("failed to read from connection", zap.Error(err))
+			return
+		}
 	}
 }
+
+func (t *TCPServer) writeReply(c *connection, reply []byte) error {
+	if t.idleTimeout > 0 {
+		if err := c.SetWriteDeadline(time.Now().Add(t.idleTimeout)); err != nil {
+			t.logger.Error("failed to set write deadline", zap.Error(err))
+			return err
+		}
+	}
+
+	if _, err := c.Write(reply); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.logger.Info("write timed out due to idle timeout", zap.Error(err))
+		} else {
+			t.logger.Error("failed to write to connection", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
@@ -1,6 +1,9 @@
 package network
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 type TCPServerOption func(*TCPServer)
 
@@ -22,8 +25,23 @@ func WithServerIdleTimeout(timeout time.Duration) TCPServerOption {
 	}
 }
 
-func WithServerBufferSize(bufferSize int) TCPServerOption {
+// WithServerMaxMessageSize limits the size of a request, larger requests are rejected.
+func WithServerMaxMessageSize(size int) TCPServerOption {
 	return func(s *TCPServer) {
-		s.bufferSize = bufferSize
+		s.maxMessageSize = size
+	}
+}
+
+// WithServerProtocol sets the wire protocol spoken by the server.
+func WithServerProtocol(protocol Protocol) TCPServerOption {
+	return func(s *TCPServer) {
+		s.protocol = protocol
+	}
+}
+
+// WithServerTLSConfig makes the server accept TLS connections only.
+func WithServerTLSConfig(config *tls.Config) TCPServerOption {
+	return func(s *TCPServer) {
+		s.tlsConfig = config
 	}
 }
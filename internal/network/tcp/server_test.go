@@ -0,0 +1,298 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// startTestServer serves the text protocol with the handler until the test ends.
+func startTestServer(t *testing.T, handler func(context.Context, string) string, options ...TCPServerOption) *TCPServer {
+	t.Helper()
+
+	server, err := NewTCPServer(zap.NewNop(), append([]TCPServerOption{WithServerAddress("localhost:0")}, options...)...)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.handleQueries(context.Background(), func(reader *bufio.Reader) session {
+			return newTextSession(reader, server.maxMessageSize, handler)
+		})
+	}()
+
+	t.Cleanup(func() {
+		server.Close()
+		<-done
+	})
+
+	return server
+}
+
+func TestServerConcurrentConnections(t *testing.T) {
+	const clients = 4
+
+	// every request waits for all clients to send theirs, which is possible
+	// only if connections are served concurrently
+	var arrived sync.WaitGroup
+	arrived.Add(clients)
+
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		arrived.Done()
+		arrived.Wait()
+		return request
+	})
+
+	var wg sync.WaitGroup
+	for range clients {
+		client, err := NewTcpClient(server.listener.Addr().String(), WithClientIdleTimeout(5*time.Second))
+		require.NoError(t, err)
+		defer client.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			response, err := client.Send([]byte("PING"))
+			assert.NoError(t, err)
+			assert.Equal(t, "PING", string(response))
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestServerRejectsConnectionsOverLimit(t *testing.T) {
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		return request
+	}, WithServerMaxConnections(1))
+
+	first, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+
+	// the first connection is accepted once it is served
+	response, err := first.Send([]byte("first"))
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(response))
+
+	second, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	message, err := readMessage(second.reader, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "[error] max number of clients reached", string(message))
+
+	// the server keeps accepting connections
+	response, err = first.Send([]byte("again"))
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(response))
+
+	first.Close()
+	require.Eventually(t, func() bool {
+		return server.activeConnections.Load() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	third, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+
+	response, err = third.Send([]byte("third"))
+	require.NoError(t, err)
+	assert.Equal(t, "third", string(response))
+}
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		if request == "slow" {
+			close(started)
+			<-release
+		}
+		return request
+	})
+
+	idle, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	_, err = idle.Send([]byte("hello"))
+	require.NoError(t, err)
+
+	busy, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer busy.Close()
+
+	slowResponse := make(chan string)
+	go func() {
+		response, err := busy.Send([]byte("slow"))
+		assert.NoError(t, err)
+		slowResponse <- string(response)
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	// the idle client is notified and disconnected right away
+	message, err := readMessage(idle.reader, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "[error] server is shutting down", string(message))
+
+	_, err = readMessage(idle.reader, 0)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// new connections are refused
+	_, err = NewTcpClient(server.listener.Addr().String())
+	assert.Error(t, err)
+
+	// the request being executed is replied to before the connection is closed
+	close(release)
+	assert.Equal(t, "slow", <-slowResponse)
+	require.NoError(t, <-shutdown)
+
+	message, err = readMessage(busy.reader, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "[error] server is shutting down", string(message))
+	assert.Zero(t, server.ActiveConnections())
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		close(started)
+		<-release
+		return request
+	})
+
+	client, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, writeMessage(client.conn, []byte("stuck")))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 1, server.ActiveConnections())
+
+	// the remaining connection is closed by force
+	require.NoError(t, server.Close())
+	_, err = readMessage(client.reader, 0)
+	assert.Error(t, err)
+
+	// its request is still executed until the handler returns
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+	assert.ErrorIs(t, server.Wait(waitCtx), context.DeadlineExceeded)
+
+	releaseOnce()
+	assert.NoError(t, server.Wait(context.Background()))
+}
+
+func TestConnectionWatch(t *testing.T) {
+	newConn := func(t *testing.T) (*connection, *bufio.Reader, net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() {
+			server.Close()
+			client.Close()
+		})
+
+		c := &connection{Conn: server, busy: true}
+		return c, bufio.NewReader(c), client
+	}
+
+	t.Run("client goes away", func(t *testing.T) {
+		c, reader, client := newConn(t)
+
+		ctx, stop := c.watch(context.Background(), reader)
+		defer stop()
+
+		client.Close()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expected the wait to end")
+		}
+	})
+
+	t.Run("connection drains", func(t *testing.T) {
+		c, reader, _ := newConn(t)
+
+		ctx, stop := c.watch(context.Background(), reader)
+		defer stop()
+
+		c.drain()
+		assert.Error(t, ctx.Err())
+	})
+
+	t.Run("pipelined request", func(t *testing.T) {
+		c, reader, client := newConn(t)
+
+		ctx, stop := c.watch(context.Background(), reader)
+		go writeMessage(client, []byte("next"))
+
+		// the request does not end the wait and is kept for the session
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, ctx.Err())
+		stop()
+
+		message, err := readMessage(reader, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "next", string(message))
+	})
+}
+
+func TestClientIdleTimeoutExtended(t *testing.T) {
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		return request
+	})
+
+	client, err := NewTcpClient(server.listener.Addr().String(), WithClientIdleTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// the connection outlives the idle timeout while it is in use
+	for range 4 {
+		response, err := client.Send([]byte("PING"))
+		require.NoError(t, err)
+		assert.Equal(t, "PING", string(response))
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestClientLargeResponse(t *testing.T) {
+	server := startTestServer(t, func(_ context.Context, request string) string {
+		return strings.Repeat(request, 10_000)
+	}, WithServerMaxMessageSize(4096))
+
+	client, err := NewTcpClient(server.listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// responses are not limited by the size of requests
+	response, err := client.Send([]byte("KEYS"))
+	require.NoError(t, err)
+	assert.Len(t, response, 40_000)
+}
@@ -0,0 +1,37 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// textSession serves the text protocol, where requests and replies are
+// length-prefixed strings.
+type textSession struct {
+	reader         *bufio.Reader
+	maxMessageSize int
+	handler        func(context.Context, string) string
+}
+
+func newTextSession(reader *bufio.Reader, maxMessageSize int, handler func(context.Context, string) string) *textSession {
+	return &textSession{
+		reader:         reader,
+		maxMessageSize: maxMessageSize,
+		handler:        handler,
+	}
+}
+
+func (s *textSession) serve(ctx context.Context) ([]byte, error) {
+	request, err := readMessage(s.reader, s.maxMessageSize)
+	if errors.Is(err, errMessageTooLarge) {
+		// the message is skipped, so the connection stays usable
+		return encodeMessage([]byte(fmt.Sprintf("[error] %s", err))), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeMessage([]byte(s.handler(ctx, string(request)))), nil
+}
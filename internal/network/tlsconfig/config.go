@@ -0,0 +1,75 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Config describes TLS of the server and the client. Certificate files are
+// watched and reloaded on change.
+type Config struct {
+	Enabled bool `yaml:"enabled" env:"KVDB_TLS_ENABLED" env-description:"Enable TLS" env-default:"false"`
+	// CertFile and KeyFile are the server certificate, or the client
+	// certificate for mutual TLS.
+	CertFile string `yaml:"cert-file" env:"KVDB_TLS_CERT_FILE" env-description:"PEM certificate file"`
+	KeyFile  string `yaml:"key-file" env:"KVDB_TLS_KEY_FILE" env-description:"PEM private key file"`
+	// CAFile verifies client certificates on the server and the server
+	// certificate on the client, which uses system roots without it.
+	CAFile     string `yaml:"ca-file" env:"KVDB_TLS_CA_FILE" env-description:"PEM CA certificates file"`
+	MinVersion string `yaml:"min-version" env:"KVDB_TLS_MIN_VERSION" env-description:"Minimal TLS version: 1.2 or 1.3" env-default:"1.2"`
+	// ClientAuth is the server policy for client certificates, one of none,
+	// request, require, verify-if-given and require-and-verify.
+	ClientAuth string `yaml:"client-auth" env:"KVDB_TLS_CLIENT_AUTH" env-description:"Client certificate policy" env-default:"none"`
+	// ServerName is the name the client verifies the server certificate against,
+	// the host of the server address by default.
+	ServerName     string `yaml:"server-name" env:"KVDB_TLS_SERVER_NAME" env-description:"Expected server name"`
+	ReloadInterval int    `yaml:"reload-interval" env:"KVDB_TLS_RELOAD_INTERVAL" env-description:"Interval of checking certificate files for changes in seconds" env-default:"10"`
+}
+
+var (
+	errMissingCertificate = errors.New("cert-file and key-file are required")
+	errMissingCA          = errors.New("ca-file is required to verify client certificates")
+	errInvalidMinVersion  = errors.New("invalid min-version")
+	errInvalidClientAuth  = errors.New("invalid client-auth")
+)
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+func (c Config) minVersion() (uint16, error) {
+	if c.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	version, ok := versions[c.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", errInvalidMinVersion, c.MinVersion)
+	}
+
+	return version, nil
+}
+
+func (c Config) clientAuth() (tls.ClientAuthType, error) {
+	if c.ClientAuth == "" {
+		return tls.NoClientCert, nil
+	}
+
+	clientAuth, ok := clientAuthTypes[strings.ToLower(c.ClientAuth)]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", errInvalidClientAuth, c.ClientAuth)
+	}
+
+	return clientAuth, nil
+}
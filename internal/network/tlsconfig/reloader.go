@@ -0,0 +1,228 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	errInvalidLogger = errors.New("invalid logger")
+	errInvalidCA     = errors.New("no certificates found in ca-file")
+)
+
+// fileState is used to tell whether a file changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileState) equal(other fileState) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+// Reloader keeps certificates of a Config loaded and reloads them when their
+// files change, so TLS configs it returns pick up rotated certificates for
+// new connections.
+type Reloader struct {
+	config     Config
+	minVersion uint16
+	clientAuth tls.ClientAuthType
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	caPool      *x509.CertPool
+	files       map[string]fileState
+
+	logger *zap.Logger
+}
+
+func NewReloader(config Config, logger *zap.Logger) (*Reloader, error) {
+	if logger == nil {
+		return nil, errInvalidLogger
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errMissingCertificate
+	}
+
+	minVersion, err := config.minVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := config.clientAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := &Reloader{
+		config:     config,
+		minVersion: minVersion,
+		clientAuth: clientAuth,
+		logger:     logger,
+	}
+
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// ServerConfig returns the TLS config of a server, which requires a certificate.
+func (r *Reloader) ServerConfig() (*tls.Config, error) {
+	if r.config.CertFile == "" {
+		return nil, errMissingCertificate
+	}
+
+	if r.clientAuth >= tls.VerifyClientCertIfGiven && r.config.CAFile == "" {
+		return nil, errMissingCA
+	}
+
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   r.minVersion,
+				Certificates: []tls.Certificate{*r.certificate},
+				ClientAuth:   r.clientAuth,
+				ClientCAs:    r.caPool,
+			}, nil
+		},
+	}, nil
+}
+
+// ClientConfig returns the TLS config of a client connecting to the server
+// with the name, unless the config overrides it.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.config.ServerName != "" {
+		serverName = r.config.ServerName
+	}
+
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		ServerName: serverName,
+		RootCAs:    r.caPool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+
+			if r.certificate == nil {
+				// no certificate is sent
+				return &tls.Certificate{}, nil
+			}
+
+			return r.certificate, nil
+		},
+	}
+}
+
+// WatchPeriodically reloads certificates whose files changed every reload
+// interval until the context is done. A failed reload keeps the certificates
+// in use and is retried on the next tick.
+func (r *Reloader) WatchPeriodically(ctx context.Context) {
+	if r.config.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(r.config.ReloadInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				r.logger.Error("failed to reload tls certificates", zap.Error(err))
+				continue
+			}
+
+			if reloaded {
+				r.logger.Info("tls certificates reloaded")
+			}
+		}
+	}
+}
+
+// reload loads the certificates if any of their files changed since the last
+// successful load.
+func (r *Reloader) reload() (bool, error) {
+	files, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+
+	r.mutex.RLock()
+	unchanged := r.files != nil && maps.EqualFunc(files, r.files, fileState.equal)
+	r.mutex.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	var certificate *tls.Certificate
+	if r.config.CertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("load certificate: %w", err)
+		}
+		certificate = &loaded
+	}
+
+	var caPool *x509.CertPool
+	if r.config.CAFile != "" {
+		data, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("load ca: %w", err)
+		}
+
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(data) {
+			return false, errInvalidCA
+		}
+	}
+
+	r.mutex.Lock()
+	r.certificate = certificate
+	r.caPool = caPool
+	r.files = files
+	r.mutex.Unlock()
+
+	return true, nil
+}
+
+func (r *Reloader) stat() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+
+	for _, path := range []string{r.config.CertFile, r.config.KeyFile, r.config.CAFile} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("stat tls file: %w", err)
+		}
+
+		files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,255 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, directory string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kvdb test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(directory, "ca.pem"), "CERTIFICATE", der)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes a certificate signed by the CA and its key to name.pem and name.key.
+func (ca *testCA) issue(t *testing.T, directory, name string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(directory, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(directory, name+".key"), "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+}
+
+// serve accepts TLS connections and echoes what they send.
+func serve(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// exchange sends a message over TLS and returns the serial number of the
+// server certificate.
+func exchange(address string, config *tls.Config) (int64, error) {
+	conn, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	// with TLS 1.3 a rejected client certificate surfaces on the first read
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return 0, err
+	}
+
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return 0, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	directory := t.TempDir()
+	ca := newTestCA(t, directory)
+	ca.issue(t, directory, "server", 10)
+	ca.issue(t, directory, "client", 20)
+
+	server, err := NewReloader(Config{
+		CertFile:   filepath.Join(directory, "server.pem"),
+		KeyFile:    filepath.Join(directory, "server.key"),
+		CAFile:     filepath.Join(directory, "ca.pem"),
+		ClientAuth: "require-and-verify",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	serverConfig, err := server.ServerConfig()
+	require.NoError(t, err)
+
+	address := serve(t, serverConfig)
+
+	t.Run("client certificate", func(t *testing.T) {
+		client, err := NewReloader(Config{
+			CertFile: filepath.Join(directory, "client.pem"),
+			KeyFile:  filepath.Join(directory, "client.key"),
+			CAFile:   filepath.Join(directory, "ca.pem"),
+		}, zap.NewNop())
+		require.NoError(t, err)
+
+		serial, err := exchange(address, client.ClientConfig("localhost"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), serial)
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		client, err := NewReloader(Config{CAFile: filepath.Join(directory, "ca.pem")}, zap.NewNop())
+		require.NoError(t, err)
+
+		_, err = exchange(address, client.ClientConfig("localhost"))
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		client, err := NewReloader(Config{}, zap.NewNop())
+		require.NoError(t, err)
+
+		_, err = exchange(address, client.ClientConfig("localhost"))
+		assert.Error(t, err)
+	})
+}
+
+func TestReload(t *testing.T) {
+	directory := t.TempDir()
+	ca := newTestCA(t, directory)
+	ca.issue(t, directory, "server", 10)
+
+	server, err := NewReloader(Config{
+		CertFile: filepath.Join(directory, "server.pem"),
+		KeyFile:  filepath.Join(directory, "server.key"),
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	serverConfig, err := server.ServerConfig()
+	require.NoError(t, err)
+
+	address := serve(t, serverConfig)
+
+	client, err := NewReloader(Config{CAFile: filepath.Join(directory, "ca.pem")}, zap.NewNop())
+	require.NoError(t, err)
+
+	serial, err := exchange(address, client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), serial)
+
+	reloaded, err := server.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "files did not change")
+
+	// a half written rotation keeps the certificate in use
+	require.NoError(t, os.WriteFile(filepath.Join(directory, "server.pem"), []byte("garbage"), 0o600))
+	_, err = server.reload()
+	assert.Error(t, err)
+
+	serial, err = exchange(address, client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), serial)
+
+	ca.issue(t, directory, "server", 11)
+	reloaded, err = server.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	serial, err = exchange(address, client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), serial)
+}
+
+func TestConfigValidation(t *testing.T) {
+	directory := t.TempDir()
+	ca := newTestCA(t, directory)
+	ca.issue(t, directory, "server", 10)
+
+	_, err := NewReloader(Config{MinVersion: "1.1"}, zap.NewNop())
+	assert.ErrorIs(t, err, errInvalidMinVersion)
+
+	_, err = NewReloader(Config{ClientAuth: "sometimes"}, zap.NewNop())
+	assert.ErrorIs(t, err, errInvalidClientAuth)
+
+	_, err = NewReloader(Config{CertFile: filepath.Join(directory, "server.pem")}, zap.NewNop())
+	assert.ErrorIs(t, err, errMissingCertificate)
+
+	reloader, err := NewReloader(Config{
+		CertFile:   filepath.Join(directory, "server.pem"),
+		KeyFile:    filepath.Join(directory, "server.key"),
+		ClientAuth: "require-and-verify",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = reloader.ServerConfig()
+	assert.ErrorIs(t, err, errMissingCA)
+}